@@ -46,6 +46,9 @@ type Config struct {
 	List              bool
 	Family            string
 	Scanner           string
+	FollowRedirects   bool
+	SARIF             bool
+	Baseline          string
 }
 
 // registerFlags defines all cfssl command flags and associates their values with variables.
@@ -80,6 +83,9 @@ func registerFlags(c *Config, f *flag.FlagSet) {
 	f.BoolVar(&c.List, "list", false, "list possible scanners")
 	f.StringVar(&c.Family, "family", "", "scanner family regular expression")
 	f.StringVar(&c.Scanner, "scanner", "", "scanner regular expression")
+	f.BoolVar(&c.FollowRedirects, "follow-redirects", false, "also scan each host that HTTPS requests are redirected to")
+	f.BoolVar(&c.SARIF, "sarif", false, "print failed scans of every host as a single SARIF 2.1.0 log")
+	f.StringVar(&c.Baseline, "baseline", "", "print only deviations from the baseline report in this file, recording one if it doesn't exist")
 
 	if pkcs11.Enabled {
 		f.StringVar(&c.Module, "pkcs11-module", "", "PKCS #11 module")
@@ -2,7 +2,9 @@ package scan
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
 
 	"github.com/cloudflare/cfssl/cli"
 	"github.com/cloudflare/cfssl/scan"
@@ -10,24 +12,63 @@ import (
 
 var scanUsageText = `cfssl scan -- scan a host for issues
 Usage of scan:
-        cfssl scan [-family regexp] [-scanner regexp] HOST+
+        cfssl scan [-family regexp] [-scanner regexp] [-follow-redirects] [-sarif] HOST+
+        cfssl scan [-family regexp] [-scanner regexp] -baseline FILE HOST
         cfssl scan -list
 
 Arguments:
         HOST:    Host(s) to scan (including port)
 Flags:
 `
-var scanFlags = []string{"list", "family", "scanner"}
+var scanFlags = []string{"list", "family", "scanner", "follow-redirects", "sarif", "baseline"}
 
 func printJSON(v interface{}) {
 	b, _ := json.MarshalIndent(v, "", "  ")
 	fmt.Printf("%s\n", b)
 }
 
+// scanBaseline scans host and prints its deviations from the baseline report
+// in file, or records its report as the baseline if file doesn't exist.
+func scanBaseline(host, file string, c cli.Config) error {
+	results, err := scan.Default.RunScans(host, c.Family, c.Scanner)
+	if err != nil {
+		return err
+	}
+	report := scan.HostReport{Host: host, Families: results}
+
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		if f, err = os.Create(file); err != nil {
+			return err
+		}
+		defer f.Close()
+		return scan.SaveBaseline(report, f)
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	deviations, err := scan.CompareBaseline(report, f)
+	if err != nil {
+		return err
+	}
+	printJSON(deviations)
+	return nil
+}
+
 func scanMain(args []string, c cli.Config) (err error) {
 	if c.List {
 		printJSON(scan.Default)
+	} else if c.Baseline != "" {
+		if len(args) != 1 {
+			return errors.New("a baseline is compared against a single host")
+		}
+		return scanBaseline(args[0], c.Baseline, c)
 	} else {
+		// Reports of every host are collected into one SARIF log.
+		var sarifReports []scan.HostReport
+
 		// Execute for each HOST argument given
 		for len(args) > 0 {
 			var host string
@@ -36,13 +77,36 @@ func scanMain(args []string, c cli.Config) (err error) {
 				return
 			}
 
+			if c.FollowRedirects {
+				var reports []scan.HostReport
+				reports, err = scan.Default.RunScansFollowingRedirects(host, c.Family, c.Scanner)
+				if err != nil {
+					return
+				}
+
+				if c.SARIF {
+					sarifReports = append(sarifReports, reports...)
+				} else {
+					printJSON(reports)
+				}
+				continue
+			}
+
 			var results map[string]scan.FamilyResult
 			results, err = scan.Default.RunScans(host, c.Family, c.Scanner)
 			if err != nil {
 				return
 			}
 
-			printJSON(results)
+			if c.SARIF {
+				sarifReports = append(sarifReports, scan.HostReport{Host: host, Families: results})
+			} else {
+				printJSON(results)
+			}
+		}
+
+		if c.SARIF {
+			printJSON(scan.Default.SARIF(sarifReports))
 		}
 	}
 	return
@@ -0,0 +1,136 @@
+package scan
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+)
+
+// BaselineResult is the recorded result of a single scan in a baseline.
+type BaselineResult struct {
+	Grade  Grade  `json:"grade"`
+	Output string `json:"output,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// ErrorKind classifies Error as by errorKind.
+	ErrorKind string `json:"error_kind,omitempty"`
+}
+
+// errorKind classifies err as a "handshake", "timeout", "network", "panic" or
+// other "scan" error. Baselines compare errors by kind, since their messages
+// carry details such as ephemeral ports that change between any two runs.
+func errorKind(err error) string {
+	if err == nil {
+		return ""
+	}
+	if err == errScannerTimeout {
+		return "timeout"
+	}
+	switch e := err.(type) {
+	case *HandshakeError:
+		return "handshake"
+	case *PanicError:
+		return "panic"
+	case net.Error:
+		if e.Timeout() {
+			return "timeout"
+		}
+		return "network"
+	}
+	return "scan"
+}
+
+// Baseline is a report recorded for later comparison, its results keyed by
+// family and scanner, as in "PKI/CertExpiration".
+type Baseline struct {
+	Host    string                    `json:"host"`
+	Results map[string]BaselineResult `json:"results"`
+}
+
+// newBaseline records report as a Baseline.
+func newBaseline(report HostReport) Baseline {
+	baseline := Baseline{Host: report.Host, Results: make(map[string]BaselineResult)}
+	for familyName, familyResult := range report.Families {
+		for scannerName, result := range familyResult {
+			recorded := BaselineResult{Grade: result.Grade}
+			if result.Output != nil {
+				recorded.Output = result.Output.String()
+			}
+			if result.Error != nil {
+				recorded.Error = result.Error.Error()
+				recorded.ErrorKind = errorKind(result.Error)
+			}
+			baseline.Results[familyName+"/"+scannerName] = recorded
+		}
+	}
+	return baseline
+}
+
+// SaveBaseline writes report to w as a JSON Baseline, for comparison with
+// later reports by CompareBaseline.
+func SaveBaseline(report HostReport, w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(newBaseline(report))
+}
+
+// Deviation is a scan whose result differs from its baseline. Was is nil for
+// scans missing from the baseline, and Now for scans missing from the report.
+type Deviation struct {
+	Scanner string          `json:"scanner"`
+	Was     *BaselineResult `json:"was,omitempty"`
+	Now     *BaselineResult `json:"now,omitempty"`
+}
+
+func (d Deviation) String() string {
+	describe := func(r *BaselineResult) string {
+		switch {
+		case r == nil:
+			return "not run"
+		case r.Error != "":
+			return fmt.Sprintf("%s (%s)", r.Grade, r.Error)
+		}
+		return r.Grade.String()
+	}
+	return fmt.Sprintf("%s: %s, was %s", d.Scanner, describe(d.Now), describe(d.Was))
+}
+
+// CompareBaseline reads a Baseline saved by SaveBaseline from r and returns
+// the deviations of report from it, sorted by scanner. A scan deviates when
+// its grade or kind of error changes, or when it is only in one of the two.
+// Outputs and error messages are recorded for context but not compared,
+// since many of them, such as the time left before a certificate expires,
+// change between any two runs. A baseline for another host is an error.
+func CompareBaseline(report HostReport, r io.Reader) ([]Deviation, error) {
+	var baseline Baseline
+	if err := json.NewDecoder(r).Decode(&baseline); err != nil {
+		return nil, err
+	}
+	if baseline.Host != report.Host {
+		return nil, fmt.Errorf("baseline is for %s, not %s", baseline.Host, report.Host)
+	}
+	current := newBaseline(report)
+
+	var deviations []Deviation
+	for name, was := range baseline.Results {
+		was := was
+		now, ok := current.Results[name]
+		switch {
+		case !ok:
+			deviations = append(deviations, Deviation{Scanner: name, Was: &was})
+		case now.Grade != was.Grade || now.ErrorKind != was.ErrorKind:
+			deviations = append(deviations, Deviation{Scanner: name, Was: &was, Now: &now})
+		}
+	}
+	for name, now := range current.Results {
+		now := now
+		if _, ok := baseline.Results[name]; !ok {
+			deviations = append(deviations, Deviation{Scanner: name, Now: &now})
+		}
+	}
+	sort.Slice(deviations, func(i, j int) bool {
+		return deviations[i].Scanner < deviations[j].Scanner
+	})
+	return deviations, nil
+}
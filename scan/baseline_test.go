@@ -0,0 +1,86 @@
+package scan
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestBaselineRoundTrip(t *testing.T) {
+	report := HostReport{Host: "example.com:443", Families: map[string]FamilyResult{
+		"PKI": {
+			"CertExpiration": {Grade: Good, Output: OutputString("expires later")},
+			"Issuer":         {Grade: Good, Output: OutputString("Test CA")},
+		},
+		"Connectivity": {
+			"TLSDial": {Grade: Bad, Error: errors.New("connection refused")},
+		},
+		"TLSHandshake": {
+			"Renegotiation": {Grade: Skipped, Error: &HandshakeError{Host: "127.0.0.1:50123", Err: errors.New("EOF")}},
+		},
+	}}
+
+	var buf bytes.Buffer
+	if err := SaveBaseline(report, &buf); err != nil {
+		t.Fatal(err)
+	}
+	deviations, err := CompareBaseline(report, bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deviations) != 0 {
+		t.Fatalf("expected no deviations from own baseline, got %v", deviations)
+	}
+
+	// Outputs aren't compared, so a changed expiration doesn't deviate.
+	drifted := HostReport{Host: "example.com:443", Families: map[string]FamilyResult{
+		"PKI": {
+			"CertExpiration": {Grade: Warning, Output: OutputString("expires soon")},
+			"SelfSigned":     {Grade: Good},
+		},
+		"Connectivity": {
+			"TLSDial": {Grade: Good},
+		},
+		"TLSHandshake": {
+			// The same kind of error, reported through another port.
+			"Renegotiation": {Grade: Skipped, Error: &HandshakeError{Host: "127.0.0.1:50456", Err: errors.New("EOF")}},
+		},
+	}}
+	deviations, err = CompareBaseline(drifted, bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"Connectivity/TLSDial: Good, was Bad (connection refused)",
+		"PKI/CertExpiration: Warning, was Good",
+		"PKI/Issuer: not run, was Good",
+		"PKI/SelfSigned: Good, was not run",
+	}
+	if len(deviations) != len(expected) {
+		t.Fatalf("expected %d deviations, got %v", len(expected), deviations)
+	}
+	for i, deviation := range deviations {
+		if deviation.String() != expected[i] {
+			t.Fatalf("expected deviation %q, got %q", expected[i], deviation)
+		}
+	}
+	if deviations[1].Was.Output != "expires later" || deviations[1].Now.Output != "expires soon" {
+		t.Fatalf("expected outputs recorded for context, got %+v", deviations[1])
+	}
+}
+
+func TestCompareBaselineMalformed(t *testing.T) {
+	if _, err := CompareBaseline(HostReport{}, bytes.NewReader([]byte("not json"))); err == nil {
+		t.Fatal("expected malformed baseline to be rejected")
+	}
+}
+
+func TestCompareBaselineOtherHost(t *testing.T) {
+	var buf bytes.Buffer
+	if err := SaveBaseline(HostReport{Host: "example.com:443"}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CompareBaseline(HostReport{Host: "example.net:443"}, &buf); err == nil {
+		t.Fatal("expected baseline for another host to be rejected")
+	}
+}
@@ -1,9 +1,15 @@
 package scan
 
 import (
+	"encoding/binary"
 	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/cloudflare/cf-tls/tls"
 )
@@ -13,20 +19,31 @@ var Connectivity = &Family{
 	Description: "Scans for basic connectivity with the host through DNS and TCP/TLS dials",
 	Scanners: map[string]*Scanner{
 		"DNSLookup": {
-			"Host can be resolved through DNS",
-			dnsLookupScan,
+			Description: "Host can be resolved through DNS",
+			scan:        dnsLookupScan,
 		},
 		"TCPDial": {
-			"Host accepts TCP connection",
-			tcpDialScan,
+			Description: "Host accepts TCP connection",
+			scan:        tcpDialScan,
 		},
 		"TLSDial": {
-			"Host can perform TLS handshake",
-			tlsDialScan,
+			Description: "Host can perform TLS handshake",
+			scanState:   tlsDialScan,
+		},
+		"CloseNotify": {
+			Description: "Host sends a close_notify alert before closing TLS connections",
+			scan:        closeNotifyScan,
+		},
+		"TCPCharacteristics": {
+			Description: "Reports whether host supports TCP Fast Open and how long it keeps idle connections open",
+			scan:        tcpCharacteristicsScan,
 		},
 	},
 }
 
+// closeNotifyTimeout bounds how long closeNotifyScan waits for the host to close the connection.
+var closeNotifyTimeout = 5 * time.Second
+
 // lookupAddrs is a list of host's addresses returned by DNS lookup
 type lookupAddrs []string
 
@@ -42,7 +59,7 @@ func dnsLookupScan(host string) (grade Grade, output Output, err error) {
 	}
 
 	var addrs lookupAddrs
-	addrs, err = net.LookupHost(host)
+	addrs, err = DNSResolver.LookupHost(host)
 	if err != nil {
 		return
 	}
@@ -56,7 +73,7 @@ func dnsLookupScan(host string) (grade Grade, output Output, err error) {
 
 // tcpDialScan tests that the host can be connected to through TCP.
 func tcpDialScan(host string) (grade Grade, output Output, err error) {
-	conn, err := Dialer.Dial(Network, host)
+	conn, err := dial(Network, host)
 	if err != nil {
 		return
 	}
@@ -66,12 +83,156 @@ func tcpDialScan(host string) (grade Grade, output Output, err error) {
 }
 
 // tlsDialScan tests that the host can perform a TLS Handshake.
-func tlsDialScan(host string) (grade Grade, output Output, err error) {
-	conn, err := tls.DialWithDialer(Dialer, Network, host, defaultTLSConfig(host))
+func tlsDialScan(host string, state *tls.ConnectionState) (grade Grade, output Output, err error) {
+	grade = Good
+	return
+}
+
+// recordConn notes the type of the last TLS record read through it.
+type recordConn struct {
+	net.Conn
+	header    []byte
+	remaining int
+	lastType  uint8
+}
+
+func (c *recordConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.observe(b[:n])
+	return n, err
+}
+
+// observe follows the record boundaries in data, which continues the data
+// previously observed.
+func (c *recordConn) observe(data []byte) {
+	for len(data) > 0 {
+		if c.remaining > 0 {
+			skip := c.remaining
+			if skip > len(data) {
+				skip = len(data)
+			}
+			c.remaining -= skip
+			data = data[skip:]
+			continue
+		}
+
+		need := 5 - len(c.header)
+		if need > len(data) {
+			c.header = append(c.header, data...)
+			return
+		}
+		c.header = append(c.header, data[:need]...)
+		data = data[need:]
+		c.lastType = c.header[0]
+		c.remaining = int(binary.BigEndian.Uint16(c.header[3:]))
+		c.header = c.header[:0]
+	}
+}
+
+// closeBehavior describes how the host closed a TLS connection.
+type closeBehavior string
+
+func (c closeBehavior) String() string {
+	return string(c)
+}
+
+// closeNotifyScan tests that the host sends a close_notify alert before
+// closing a connection, without which clients can't tell a complete response
+// from one truncated by an attacker.
+func closeNotifyScan(host string) (grade Grade, output Output, err error) {
+	tcpConn, err := dial(Network, host)
 	if err != nil {
 		return
 	}
-	conn.Close()
-	grade = Good
+	rc := &recordConn{Conn: tcpConn}
+	config := defaultTLSConfig(host)
+	// Alert records can only be told apart from application data before TLS 1.3.
+	config.MaxVersion = tls.VersionTLS12
+	conn := tls.Client(rc, config)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(closeNotifyTimeout))
+
+	if err = conn.Handshake(); err != nil {
+		atomic.AddUint64(&stats.HandshakeFailures, 1)
+		return
+	}
+	if _, err = fmt.Fprintf(conn, "HEAD / HTTP/1.1\r\nHost: %s\r\nConnection: close\r\n\r\n", config.ServerName); err != nil {
+		return
+	}
+
+	_, readErr := io.Copy(ioutil.Discard, conn)
+	if netErr, ok := readErr.(net.Error); ok && netErr.Timeout() {
+		return Skipped, closeBehavior("host didn't close the connection"), nil
+	}
+	switch {
+	case readErr != nil:
+		grade, output = Warning, closeBehavior("connection ended with an error: "+readErr.Error())
+	case rc.lastType == recordTypeAlert:
+		grade, output = Good, closeBehavior("host sent close_notify before closing the connection")
+	default:
+		grade, output = Warning, closeBehavior("host closed the connection without sending close_notify")
+	}
 	return
 }
+
+// tcpIdleProbe is how long tcpCharacteristicsScan leaves a connection idle
+// before concluding the host keeps idle connections open.
+var tcpIdleProbe = 5 * time.Second
+
+// errTCPFastOpenUnsupported is returned by tcpFastOpen where TCP Fast Open
+// can't be probed.
+var errTCPFastOpenUnsupported = errors.New("TCP Fast Open isn't supported on this platform")
+
+// tcpCharacteristics describes the host's TCP behavior.
+type tcpCharacteristics struct {
+	FastOpen bool `json:"fast_open"`
+	// IdleClose is how long the host left an idle connection open before
+	// closing it, or zero if it kept it open for IdleProbe.
+	IdleClose time.Duration `json:"idle_close,omitempty"`
+	IdleProbe time.Duration `json:"idle_probe"`
+}
+
+func (c tcpCharacteristics) String() string {
+	fastOpen := "TCP Fast Open not supported"
+	if c.FastOpen {
+		fastOpen = "TCP Fast Open supported"
+	}
+	idle := fmt.Sprintf("idle connection kept open for %s", c.IdleProbe)
+	if c.IdleClose > 0 {
+		idle = fmt.Sprintf("idle connection closed after %s", c.IdleClose)
+	}
+	return fastOpen + "; " + idle
+}
+
+// tcpCharacteristicsScan reports whether the host accepts data in the SYN
+// through TCP Fast Open, saving a round trip on repeat connections, and
+// whether it keeps a connection open while the client stays idle for
+// tcpIdleProbe. The grade is informational. Hosts are Skipped on platforms
+// where TCP Fast Open can't be probed.
+func tcpCharacteristicsScan(host string) (grade Grade, output Output, err error) {
+	fastOpen, err := tcpFastOpen(host)
+	if err == errTCPFastOpenUnsupported {
+		return Skipped, nil, nil
+	}
+	if err != nil {
+		return
+	}
+
+	conn, err := dial(Network, host)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	start := time.Now()
+	conn.SetReadDeadline(start.Add(tcpIdleProbe))
+
+	characteristics := tcpCharacteristics{FastOpen: fastOpen, IdleProbe: tcpIdleProbe}
+	_, readErr := conn.Read(make([]byte, 1))
+	if netErr, ok := readErr.(net.Error); !ok || !netErr.Timeout() {
+		characteristics.IdleClose = time.Since(start).Round(time.Millisecond)
+		if characteristics.IdleClose == 0 {
+			characteristics.IdleClose = time.Millisecond
+		}
+	}
+	return Good, characteristics, nil
+}
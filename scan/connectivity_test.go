@@ -0,0 +1,145 @@
+package scan
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// serveWithoutCloseNotify starts a TLS server that answers a single request on
+// each connection and then drops it without sending close_notify.
+func serveWithoutCloseNotify(t *testing.T) net.Listener {
+	leaf := newTestCert(t, testTemplate("localhost"), testKey.Public(), nil, testKey)
+	config := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{leaf.Raw}, PrivateKey: testKey}}}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			rawConn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer rawConn.Close()
+				conn := tls.Server(rawConn, config)
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil {
+					return
+				}
+				resp := &http.Response{StatusCode: http.StatusOK, ProtoMajor: 1, ProtoMinor: 1, Request: req, Close: true}
+				resp.Write(conn)
+			}()
+		}
+	}()
+	return l
+}
+
+func TestCloseNotifyScan(t *testing.T) {
+	leaf := newTestCert(t, testTemplate("localhost"), testKey.Public(), nil, testKey)
+	server := serveChain(testKey, leaf)
+	defer server.Close()
+
+	grade, output, err := closeNotifyScan(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grade != Good {
+		t.Fatalf("expected server sending close_notify to be Good, got %s (%s)", grade, output)
+	}
+}
+
+func TestCloseNotifyScanOmitted(t *testing.T) {
+	l := serveWithoutCloseNotify(t)
+	defer l.Close()
+
+	grade, output, err := closeNotifyScan(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grade != Warning {
+		t.Fatalf("expected server omitting close_notify to be Warning, got %s (%s)", grade, output)
+	}
+}
+
+func TestRecordConnObserve(t *testing.T) {
+	c := new(recordConn)
+	// A handshake record split across reads, followed by an alert record.
+	c.observe([]byte{22, 3, 3})
+	c.observe([]byte{0, 2, 1})
+	if c.lastType != recordTypeHandshake {
+		t.Fatalf("expected handshake record, got %d", c.lastType)
+	}
+	c.observe([]byte{2, 21, 3, 3, 0, 2, 1, 0})
+	if c.lastType != recordTypeAlert {
+		t.Fatalf("expected alert record, got %d", c.lastType)
+	}
+}
+
+// serveIdle starts a TCP server that closes each connection after idle, or
+// holds it open until the client closes it if idle is zero.
+func serveIdle(t *testing.T, idle time.Duration) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if idle > 0 {
+					time.Sleep(idle)
+					return
+				}
+				io.Copy(ioutil.Discard, conn)
+			}()
+		}
+	}()
+	return l
+}
+
+func TestTCPCharacteristicsScan(t *testing.T) {
+	defer func(probe time.Duration) { tcpIdleProbe = probe }(tcpIdleProbe)
+	tcpIdleProbe = 200 * time.Millisecond
+
+	for _, test := range []struct {
+		idle   time.Duration
+		closed bool
+	}{
+		{0, false},
+		{10 * time.Millisecond, true},
+	} {
+		l := serveIdle(t, test.idle)
+		grade, output, err := tcpCharacteristicsScan(l.Addr().String())
+		l.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if grade == Skipped {
+			t.Skip("TCP Fast Open can't be probed on this platform")
+		}
+		if grade != Good {
+			t.Fatalf("expected TCP characteristics to be Good, got %s (%s)", grade, output)
+		}
+		// Local listeners don't enable TCP Fast Open.
+		characteristics := output.(tcpCharacteristics)
+		if characteristics.FastOpen {
+			t.Fatalf("expected TCP Fast Open to be unsupported: %s", characteristics)
+		}
+		if closed := characteristics.IdleClose > 0; closed != test.closed {
+			t.Fatalf("expected idle close %v for server idling %s: %s", test.closed, test.idle, characteristics)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package scan
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Resolver looks up the DNS records that scanners inspect.
+type Resolver interface {
+	// LookupHost returns the addresses of host.
+	LookupHost(host string) ([]string, error)
+	// LookupHTTPS returns the HTTPS records published for name.
+	LookupHTTPS(name string) ([]dnsmessage.HTTPSResource, error)
+	// LookupTLSA returns the TLSA records published for name.
+	LookupTLSA(name string) ([]TLSARecord, error)
+	// LookupCERT returns the CERT records published for name.
+	LookupCERT(name string) ([]CERTRecord, error)
+}
+
+// typeTLSA is the DNS record type of TLSA records, which dnsmessage doesn't name.
+const typeTLSA dnsmessage.Type = 52
+
+// TLSARecord is a DANE TLSA record, which binds a certificate or public key to
+// a service as described by RFC 6698.
+type TLSARecord struct {
+	Usage        uint8  `json:"usage"`
+	Selector     uint8  `json:"selector"`
+	MatchingType uint8  `json:"matching_type"`
+	Data         []byte `json:"data"`
+	// Authenticated reports whether the resolver validated the record with
+	// DNSSEC, setting the AD bit in its response.
+	Authenticated bool `json:"authenticated"`
+}
+
+// String returns the record in its presentation format, such as "3 1 1 0a1b...".
+func (r TLSARecord) String() string {
+	return fmt.Sprintf("%d %d %d %x", r.Usage, r.Selector, r.MatchingType, r.Data)
+}
+
+// typeCERT is the DNS record type of CERT records, which dnsmessage doesn't name.
+const typeCERT dnsmessage.Type = 37
+
+// certTypePKIX is the CERT record type of records holding a DER encoded X.509 certificate.
+const certTypePKIX uint16 = 1
+
+// CERTRecord is a DNS CERT record, which publishes a certificate for a name as
+// described by RFC 4398.
+type CERTRecord struct {
+	Type      uint16 `json:"type"`
+	KeyTag    uint16 `json:"key_tag"`
+	Algorithm uint8  `json:"algorithm"`
+	Data      []byte `json:"data"`
+}
+
+// DNSResolver is the Resolver used by scanners. It queries the system's
+// resolver by default.
+var DNSResolver Resolver = systemResolver{}
+
+var (
+	// nameserver overrides the nameserver queried by systemResolver for
+	// records the net package can't look up; by default the first nameserver
+	// in resolvConf is used.
+	nameserver string
+	// resolvConf is the resolver configuration nameservers are read from.
+	resolvConf = "/etc/resolv.conf"
+	// dnsTimeout bounds each DNS query made directly by systemResolver, and
+	// each lookup made through lookupHost.
+	dnsTimeout = 5 * time.Second
+)
+
+// systemResolver looks up addresses with the net package, and other records
+// by querying the system's nameserver directly.
+type systemResolver struct{}
+
+func (systemResolver) LookupHost(host string) ([]string, error) {
+	return net.LookupHost(host)
+}
+
+func (r systemResolver) LookupHTTPS(name string) ([]dnsmessage.HTTPSResource, error) {
+	answers, _, err := r.query(name, dnsmessage.TypeHTTPS)
+	if err != nil {
+		return nil, err
+	}
+	var records []dnsmessage.HTTPSResource
+	for _, answer := range answers {
+		if https, ok := answer.Body.(*dnsmessage.HTTPSResource); ok {
+			records = append(records, *https)
+		}
+	}
+	return records, nil
+}
+
+func (r systemResolver) LookupTLSA(name string) ([]TLSARecord, error) {
+	answers, authenticated, err := r.query(name, typeTLSA)
+	if err != nil {
+		return nil, err
+	}
+	var records []TLSARecord
+	for _, answer := range answers {
+		unknown, ok := answer.Body.(*dnsmessage.UnknownResource)
+		if !ok || unknown.Type != typeTLSA {
+			continue
+		}
+		if len(unknown.Data) < 3 {
+			return nil, errors.New("malformed TLSA record for " + name)
+		}
+		records = append(records, TLSARecord{
+			Usage:         unknown.Data[0],
+			Selector:      unknown.Data[1],
+			MatchingType:  unknown.Data[2],
+			Data:          unknown.Data[3:],
+			Authenticated: authenticated,
+		})
+	}
+	return records, nil
+}
+
+func (r systemResolver) LookupCERT(name string) ([]CERTRecord, error) {
+	answers, _, err := r.query(name, typeCERT)
+	if err != nil {
+		return nil, err
+	}
+	var records []CERTRecord
+	for _, answer := range answers {
+		unknown, ok := answer.Body.(*dnsmessage.UnknownResource)
+		if !ok || unknown.Type != typeCERT {
+			continue
+		}
+		if len(unknown.Data) < 5 {
+			return nil, errors.New("malformed CERT record for " + name)
+		}
+		records = append(records, CERTRecord{
+			Type:      binary.BigEndian.Uint16(unknown.Data),
+			KeyTag:    binary.BigEndian.Uint16(unknown.Data[2:]),
+			Algorithm: unknown.Data[4],
+			Data:      unknown.Data[5:],
+		})
+	}
+	return records, nil
+}
+
+// lookupHost looks up the addresses of host with DNSResolver, failing if the
+// lookup takes longer than dnsTimeout.
+func lookupHost(host string) ([]string, error) {
+	type result struct {
+		addrs []string
+		err   error
+	}
+	resolver := DNSResolver
+	done := make(chan result, 1)
+	go func() {
+		addrs, err := resolver.LookupHost(host)
+		done <- result{addrs, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.addrs, r.err
+	case <-time.After(dnsTimeout):
+		return nil, &net.DNSError{Err: "lookup timed out", Name: host, IsTimeout: true}
+	}
+}
+
+// systemNameserver returns the address of the nameserver to query.
+func systemNameserver() (string, error) {
+	if nameserver != "" {
+		return nameserver, nil
+	}
+	f, err := os.Open(resolvConf)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			return net.JoinHostPort(fields[1], "53"), nil
+		}
+	}
+	if err = s.Err(); err != nil {
+		return "", err
+	}
+	return "", errors.New("no nameserver configured in " + resolvConf)
+}
+
+// query sends a recursive query for records of type qtype for name to the
+// system's nameserver over UDP, and returns the answers and whether the
+// nameserver authenticated them with DNSSEC. The query sets the AD bit to ask
+// for that, as RFC 6840 section 5.7 describes.
+func (systemResolver) query(name string, qtype dnsmessage.Type) ([]dnsmessage.Resource, bool, error) {
+	server, err := systemNameserver()
+	if err != nil {
+		return nil, false, err
+	}
+	qname, err := dnsmessage.NewName(strings.TrimSuffix(name, ".") + ".")
+	if err != nil {
+		return nil, false, err
+	}
+
+	id := uint16(rand.Uint32())
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, RecursionDesired: true, AuthenticData: true})
+	b.EnableCompression()
+	if err = b.StartQuestions(); err != nil {
+		return nil, false, err
+	}
+	if err = b.Question(dnsmessage.Question{Name: qname, Type: qtype, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, false, err
+	}
+	// Advertise a large UDP payload size, since HTTPS records carrying ECH
+	// configurations can exceed the traditional 512 byte limit.
+	if err = b.StartAdditionals(); err != nil {
+		return nil, false, err
+	}
+	var opt dnsmessage.ResourceHeader
+	if err = opt.SetEDNS0(4096, dnsmessage.RCodeSuccess, false); err != nil {
+		return nil, false, err
+	}
+	if err = b.OPTResource(opt, dnsmessage.OPTResource{}); err != nil {
+		return nil, false, err
+	}
+	msg, err := b.Finish()
+	if err != nil {
+		return nil, false, err
+	}
+
+	conn, err := Dialer.Dial("udp", server)
+	if err != nil {
+		return nil, false, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(dnsTimeout))
+	if _, err = conn.Write(msg); err != nil {
+		return nil, false, err
+	}
+
+	resp := make([]byte, 4096)
+	for {
+		n, err := conn.Read(resp)
+		if err != nil {
+			return nil, false, err
+		}
+
+		var p dnsmessage.Parser
+		header, err := p.Start(resp[:n])
+		if err != nil || header.ID != id || !header.Response {
+			// Ignore stray packets, as the net package's resolver does.
+			continue
+		}
+		switch {
+		case header.Truncated:
+			return nil, false, errors.New("DNS response for " + name + " was truncated")
+		case header.RCode == dnsmessage.RCodeNameError:
+			return nil, false, nil
+		case header.RCode != dnsmessage.RCodeSuccess:
+			return nil, false, errors.New("DNS query for " + name + " failed: " + header.RCode.String())
+		}
+		if err = p.SkipAllQuestions(); err != nil {
+			return nil, false, err
+		}
+		answers, err := p.AllAnswers()
+		return answers, header.AuthenticData, err
+	}
+}
@@ -0,0 +1,149 @@
+package scan
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// stubResolver answers lookups from fixed tables, failing for unknown names.
+type stubResolver struct {
+	hosts map[string][]string
+	https map[string][]dnsmessage.HTTPSResource
+	tlsa  map[string][]TLSARecord
+	cert  map[string][]CERTRecord
+}
+
+func (r stubResolver) LookupHost(host string) ([]string, error) {
+	addrs, ok := r.hosts[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return addrs, nil
+}
+
+func (r stubResolver) LookupHTTPS(name string) ([]dnsmessage.HTTPSResource, error) {
+	return r.https[name], nil
+}
+
+func (r stubResolver) LookupTLSA(name string) ([]TLSARecord, error) {
+	return r.tlsa[name], nil
+}
+
+func (r stubResolver) LookupCERT(name string) ([]CERTRecord, error) {
+	return r.cert[name], nil
+}
+
+// withResolver points DNSResolver at r for the duration of f.
+func withResolver(r Resolver, f func()) {
+	defer func(r Resolver) { DNSResolver = r }(DNSResolver)
+	DNSResolver = r
+	f()
+}
+
+// testHTTPSRecord returns an HTTPS record with the given ECH configuration,
+// or none if ech is nil.
+func testHTTPSRecord(ech []byte) dnsmessage.HTTPSResource {
+	var record dnsmessage.HTTPSResource
+	record.Priority = 1
+	record.Target = dnsmessage.MustNewName(".")
+	record.SetParam(dnsmessage.SVCParamALPN, []byte("\x02h2"))
+	if ech != nil {
+		record.SetParam(dnsmessage.SVCParamECH, ech)
+	}
+	return record
+}
+
+// serveDNS answers a single UDP query with an HTTPS record and points
+// nameserver at the server for the duration of f.
+func serveDNS(t *testing.T, record dnsmessage.HTTPSResource, f func()) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	go func() {
+		buf := make([]byte, 512)
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		var p dnsmessage.Parser
+		header, err := p.Start(buf[:n])
+		if err != nil {
+			return
+		}
+		question, err := p.Question()
+		if err != nil {
+			return
+		}
+
+		b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: header.ID, Response: true})
+		b.StartQuestions()
+		b.Question(question)
+		b.StartAnswers()
+		b.HTTPSResource(dnsmessage.ResourceHeader{Name: question.Name, Class: dnsmessage.ClassINET, TTL: 300}, record)
+		resp, err := b.Finish()
+		if err != nil {
+			return
+		}
+		conn.WriteTo(resp, addr)
+	}()
+
+	defer func(s string) { nameserver = s }(nameserver)
+	nameserver = conn.LocalAddr().String()
+	f()
+}
+
+func TestSystemResolverLookupHTTPS(t *testing.T) {
+	serveDNS(t, testHTTPSRecord([]byte{0, 1, 2, 3}), func() {
+		records, err := systemResolver{}.LookupHTTPS("example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != 1 {
+			t.Fatalf("expected 1 record, got %d", len(records))
+		}
+		if ech, ok := records[0].GetParam(dnsmessage.SVCParamECH); !ok || len(ech) != 4 {
+			t.Fatalf("expected ECH configuration in %#v", records[0])
+		}
+	})
+}
+
+func TestDNSLookupScanResolver(t *testing.T) {
+	withResolver(stubResolver{hosts: map[string][]string{"example.com": {"192.0.2.1"}}}, func() {
+		grade, output, err := dnsLookupScan("example.com:443")
+		if err != nil || grade != Good || output.String() != "192.0.2.1" {
+			t.Fatalf("unexpected lookup result %s (%v): %v", grade, output, err)
+		}
+		if _, _, err = dnsLookupScan("missing.example.com:443"); err == nil {
+			t.Fatal("expected lookup of unknown host to fail")
+		}
+	})
+}
+
+// slowResolver answers lookups after a delay.
+type slowResolver struct {
+	stubResolver
+	delay time.Duration
+}
+
+func (r slowResolver) LookupHost(host string) ([]string, error) {
+	time.Sleep(r.delay)
+	return r.stubResolver.LookupHost(host)
+}
+
+func TestLookupHostTimeout(t *testing.T) {
+	defer func(d time.Duration) { dnsTimeout = d }(dnsTimeout)
+	dnsTimeout = 10 * time.Millisecond
+
+	withResolver(slowResolver{delay: time.Second}, func() {
+		_, err := lookupHost("example.com")
+		if dnsErr, ok := err.(*net.DNSError); !ok || !dnsErr.IsTimeout {
+			t.Fatalf("expected lookup to time out, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,95 @@
+package scan
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// execScannerTimeout bounds each run of an external command by a scanner
+// from NewExecScanner, after which the command is killed.
+var execScannerTimeout = time.Minute
+
+// execOutputLimit caps how much of an external command's standard output is
+// kept as the output of its scan. The rest is discarded.
+const execOutputLimit = 64 << 10
+
+// execOutput is the standard output of an external command.
+type execOutput string
+
+func (o execOutput) String() string {
+	return string(o)
+}
+
+// ExitGrade maps exit codes to grades as external commands conventionally
+// report them: 0 is Good, 1 is Warning and anything else is Bad.
+func ExitGrade(code int) Grade {
+	switch code {
+	case 0:
+		return Good
+	case 1:
+		return Warning
+	}
+	return Bad
+}
+
+// NewExecScanner returns a scanner running the external command cmd with "--"
+// and the host as its arguments, for checks better implemented outside Go.
+// The grade is gradeFromExit of the command's exit code, or ExitGrade if it is
+// nil, and the output its trimmed standard output, up to execOutputLimit
+// bytes. The command runs without a shell, so cmd names an executable rather
+// than a command line. Failing to run the command, it being killed by a
+// signal, or it running longer than execScannerTimeout, is an error.
+func NewExecScanner(cmd string, gradeFromExit func(int) Grade) *Scanner {
+	if gradeFromExit == nil {
+		gradeFromExit = ExitGrade
+	}
+	return &Scanner{
+		Description: "Host passes the external check " + cmd,
+		scan: func(host string) (grade Grade, output Output, err error) {
+			ctx, cancel := context.WithTimeout(context.Background(), execScannerTimeout)
+			defer cancel()
+			var stderr bytes.Buffer
+			command := exec.CommandContext(ctx, cmd, "--", host)
+			command.Stderr = &stderr
+			stdout, err := command.StdoutPipe()
+			if err != nil {
+				return
+			}
+			if err = command.Start(); err != nil {
+				return
+			}
+			out, _ := ioutil.ReadAll(io.LimitReader(stdout, execOutputLimit))
+			// Drain the rest so the command isn't blocked writing it.
+			io.Copy(ioutil.Discard, stdout)
+
+			code := 0
+			if err = command.Wait(); err != nil {
+				if ctx.Err() != nil {
+					err = fmt.Errorf("%s timed out after %s", cmd, execScannerTimeout)
+					return
+				}
+				exitErr, ok := err.(*exec.ExitError)
+				if !ok {
+					return
+				}
+				if code = exitErr.ExitCode(); code < 0 {
+					err = fmt.Errorf("%s was killed: %v", cmd, exitErr)
+					return
+				}
+				err = nil
+				ScanLogger.Debugf("scan: %s exited with %d against %s: %s", cmd, code, host, strings.TrimSpace(stderr.String()))
+			}
+			if out := strings.TrimSpace(string(out)); out != "" {
+				output = execOutput(out)
+			}
+			grade = gradeFromExit(code)
+			return
+		},
+	}
+}
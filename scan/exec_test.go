@@ -0,0 +1,74 @@
+package scan
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// writeFakeCommand writes an executable shell script with the given body to
+// a temporary directory, returning its path.
+func writeFakeCommand(t *testing.T, body string) string {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake commands are shell scripts")
+	}
+	path := filepath.Join(t.TempDir(), "check")
+	if err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExecScanner(t *testing.T) {
+	cases := []struct {
+		body          string
+		gradeFromExit func(int) Grade
+		grade         Grade
+		output        string
+	}{
+		{`echo "checked $2 after $1"`, nil, Good, "checked example.com:443 after --"},
+		{`echo "weak protocol"; exit 1`, nil, Warning, "weak protocol"},
+		{`echo broken >&2; exit 2`, nil, Bad, ""},
+		{`exit 3`, func(code int) Grade {
+			if code == 3 {
+				return Skipped
+			}
+			return Bad
+		}, Skipped, ""},
+	}
+
+	for _, c := range cases {
+		scanner := NewExecScanner(writeFakeCommand(t, c.body), c.gradeFromExit)
+		grade, output, err := scanner.Scan("example.com:443")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if grade != c.grade || (output == nil) != (c.output == "") || output != nil && output.String() != c.output {
+			t.Fatalf("%q: expected %s (%q), got %s (%v)", c.body, c.grade, c.output, grade, output)
+		}
+	}
+
+	if _, _, err := NewExecScanner(filepath.Join(t.TempDir(), "missing"), nil).Scan("example.com:443"); err == nil {
+		t.Fatal("expected a missing command to fail")
+	}
+
+	if _, _, err := NewExecScanner(writeFakeCommand(t, "kill -9 $$"), nil).Scan("example.com:443"); err == nil {
+		t.Fatal("expected a killed command to fail")
+	}
+
+	_, output, err := NewExecScanner(writeFakeCommand(t, "head -c 1000000 /dev/zero | tr '\\0' x"), nil).Scan("example.com:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(output.String()) != execOutputLimit {
+		t.Fatalf("expected output capped at %d bytes, got %d", execOutputLimit, len(output.String()))
+	}
+
+	defer func(timeout time.Duration) { execScannerTimeout = timeout }(execScannerTimeout)
+	execScannerTimeout = 100 * time.Millisecond
+	if _, _, err := NewExecScanner(writeFakeCommand(t, "exec sleep 10"), nil).Scan("example.com:443"); err == nil {
+		t.Fatal("expected a hung command to time out")
+	}
+}
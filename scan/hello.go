@@ -0,0 +1,530 @@
+package scan
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// TLS protocol values used by hand-built handshake messages, including those
+// the tls package doesn't implement.
+const (
+	versionTLS10 uint16 = 0x0301
+	versionTLS12 uint16 = 0x0303
+	versionTLS13 uint16 = 0x0304
+
+	recordTypeAlert     uint8 = 21
+	recordTypeHandshake uint8 = 22
+
+	alertLevelWarning uint8 = 1
+
+	typeClientHello       uint8 = 1
+	typeServerHello       uint8 = 2
+	typeNewSessionTicket  uint8 = 4
+	typeCertificate       uint8 = 11
+	typeServerKeyExchange uint8 = 12
+	typeServerHelloDone   uint8 = 14
+
+	extensionServerName          uint16 = 0
+	extensionMaxFragmentLength   uint16 = 1
+	extensionSupportedGroups     uint16 = 10
+	extensionECPointFormats      uint16 = 11
+	extensionHeartbeat           uint16 = 15
+	extensionSignatureAlgorithms uint16 = 13
+	extensionPadding             uint16 = 21
+	extensionSupportedVersions   uint16 = 43
+	extensionKeyShare            uint16 = 51
+
+	groupSecp160k1 uint16 = 15
+	groupSecp160r1 uint16 = 16
+	groupSecp160r2 uint16 = 17
+	groupSecp192k1 uint16 = 18
+	groupSecp192r1 uint16 = 19
+	groupSecp224k1 uint16 = 20
+	groupSecp224r1 uint16 = 21
+	groupP256      uint16 = 23
+	groupP384      uint16 = 24
+	groupP521      uint16 = 25
+	groupX25519    uint16 = 29
+	groupX448      uint16 = 30
+
+	// Hybrid post-quantum groups combining X25519 with ML-KEM-768, in its
+	// standard form and as the Kyber draft deployed before it.
+	groupX25519MLKEM768        uint16 = 0x11ec
+	groupX25519Kyber768Draft00 uint16 = 0x6399
+
+	// mlkem768EncapsulationKeySize is the size of an ML-KEM-768 encapsulation key.
+	mlkem768EncapsulationKeySize = 1184
+)
+
+var (
+	// helloTimeout bounds the exchange of a hand-built ClientHello and its response.
+	helloTimeout = 5 * time.Second
+
+	// ecdheAEADCipherSuites are the ECDHE cipher suites using AES-GCM or
+	// ChaCha20-Poly1305.
+	ecdheAEADCipherSuites = []uint16{0xc02b, 0xc02f, 0xc02c, 0xc030, 0xcca9, 0xcca8}
+	// ecdheCBCCipherSuites are the ECDHE cipher suites using AES-CBC.
+	ecdheCBCCipherSuites = []uint16{0xc009, 0xc013, 0xc00a, 0xc014}
+	// rsaAEADCipherSuites are the RSA key exchange cipher suites using AES-GCM.
+	rsaAEADCipherSuites = []uint16{0x009c, 0x009d}
+	// rsaCBCCipherSuites are the RSA key exchange cipher suites using AES-CBC.
+	rsaCBCCipherSuites = []uint16{0x002f, 0x0035}
+	// helloCipherSuites are the TLS 1.2 and earlier cipher suites offered by a
+	// hand-built ClientHello, in order of preference.
+	helloCipherSuites = joinSuites(ecdheAEADCipherSuites, ecdheCBCCipherSuites, rsaAEADCipherSuites, rsaCBCCipherSuites)
+	// tls13CipherSuites are the cipher suites defined for TLS 1.3.
+	tls13CipherSuites = []uint16{0x1301, 0x1302, 0x1303}
+	// tls13CipherSuiteNames names tls13CipherSuites, which the tls package doesn't know.
+	tls13CipherSuiteNames = map[uint16]string{
+		0x1301: "TLS_AES_128_GCM_SHA256",
+		0x1302: "TLS_AES_256_GCM_SHA384",
+		0x1303: "TLS_CHACHA20_POLY1305_SHA256",
+	}
+	// helloGroups are the named groups offered by a hand-built ClientHello.
+	helloGroups = []uint16{groupX25519, groupP256, groupP384, groupP521}
+	// groupNames names the named groups hand-built ClientHellos may offer.
+	groupNames = map[uint16]string{
+		groupSecp160k1: "secp160k1",
+		groupSecp160r1: "secp160r1",
+		groupSecp160r2: "secp160r2",
+		groupSecp192k1: "secp192k1",
+		groupSecp192r1: "secp192r1",
+		groupSecp224k1: "secp224k1",
+		groupSecp224r1: "secp224r1",
+		groupP256:      "secp256r1",
+		groupP384:      "secp384r1",
+		groupP521:      "secp521r1",
+		groupX25519:    "x25519",
+		groupX448:      "x448",
+
+		groupX25519MLKEM768:        "X25519MLKEM768",
+		groupX25519Kyber768Draft00: "X25519Kyber768Draft00",
+	}
+	// ecdheCipherSuites are the cipher suites of helloCipherSuites using ECDHE key exchange.
+	ecdheCipherSuites = joinSuites(ecdheAEADCipherSuites, ecdheCBCCipherSuites)
+	// aeadCipherSuites are the cipher suites of helloCipherSuites using AEAD ciphers.
+	aeadCipherSuites = joinSuites(ecdheAEADCipherSuites, rsaAEADCipherSuites)
+	// helloSignatureSchemes are the signature schemes offered by a hand-built ClientHello.
+	helloSignatureSchemes = []uint16{
+		0x0403, 0x0503, 0x0603, // ECDSA
+		0x0804, 0x0805, 0x0806, // RSA-PSS
+		0x0401, 0x0501, 0x0601, // RSA PKCS#1 v1.5
+		0x0203, 0x0201, // SHA-1
+	}
+	// signatureSchemeNames names the signature schemes of helloSignatureSchemes.
+	signatureSchemeNames = map[uint16]string{
+		0x0403: "ecdsa_secp256r1_sha256",
+		0x0503: "ecdsa_secp384r1_sha384",
+		0x0603: "ecdsa_secp521r1_sha512",
+		0x0804: "rsa_pss_rsae_sha256",
+		0x0805: "rsa_pss_rsae_sha384",
+		0x0806: "rsa_pss_rsae_sha512",
+		0x0401: "rsa_pkcs1_sha256",
+		0x0501: "rsa_pkcs1_sha384",
+		0x0601: "rsa_pkcs1_sha512",
+		0x0203: "ecdsa_sha1",
+		0x0201: "rsa_pkcs1_sha1",
+	}
+
+	// downgradeSentinelTLS12 ends the ServerHello random of a TLS 1.3 server
+	// that negotiates TLS 1.2, per RFC 8446 section 4.1.3.
+	downgradeSentinelTLS12 = []byte("DOWNGRD\x01")
+	// helloRetryRequestRandom is the ServerHello random of a HelloRetryRequest.
+	helloRetryRequestRandom = []byte{
+		0xcf, 0x21, 0xad, 0x74, 0xe5, 0x9a, 0x61, 0x11, 0xbe, 0x1d, 0x8c, 0x02, 0x1e, 0x65, 0xb8, 0x91,
+		0xc2, 0xa2, 0x11, 0x16, 0x7a, 0xbb, 0x8c, 0x5e, 0x07, 0x9e, 0x09, 0xe2, 0xc8, 0xa8, 0x33, 0x9c,
+	}
+)
+
+// helloExtension is a single ClientHello or ServerHello extension.
+type helloExtension struct {
+	typ  uint16
+	data []byte
+}
+
+// clientHello is a ClientHello built by hand, so that scanners can offer
+// versions, extensions or values that the tls package won't send.
+type clientHello struct {
+	vers         uint16
+	random       [32]byte
+	sessionID    []byte
+	cipherSuites []uint16
+	extensions   []helloExtension
+}
+
+// newClientHello returns a TLS 1.2 ClientHello for host offering
+// helloCipherSuites, helloGroups and helloSignatureSchemes.
+func newClientHello(host string) *clientHello {
+	hello := &clientHello{
+		vers:         versionTLS12,
+		sessionID:    make([]byte, 32),
+		cipherSuites: helloCipherSuites,
+	}
+	rand.Read(hello.random[:])
+	rand.Read(hello.sessionID)
+
+	if name := defaultTLSConfig(host).ServerName; net.ParseIP(name) == nil {
+		hello.setExtension(serverNameExtension(name))
+	}
+	hello.setExtension(supportedGroupsExtension(helloGroups...))
+	hello.setExtension(helloExtension{extensionECPointFormats, []byte{1, 0}})
+	hello.setExtension(signatureAlgorithmsExtension(helloSignatureSchemes...))
+	return hello
+}
+
+// joinSuites returns the cipher suites of lists in a new slice, in order.
+func joinSuites(lists ...[]uint16) []uint16 {
+	var suites []uint16
+	for _, list := range lists {
+		suites = append(suites, list...)
+	}
+	return suites
+}
+
+// offerTLS13 adds the TLS 1.3 cipher suites and the extensions needed to
+// negotiate TLS 1.3 to the ClientHello, sharing a key for X25519.
+func (h *clientHello) offerTLS13() {
+	h.cipherSuites = append(append([]uint16{}, tls13CipherSuites...), h.cipherSuites...)
+	h.setExtension(supportedVersionsExtension(versionTLS13, versionTLS12))
+	key := make([]byte, 32)
+	rand.Read(key)
+	h.setExtension(keyShareExtension(groupX25519, key))
+}
+
+// setExtension adds ext to the ClientHello, replacing any extension of the same type.
+func (h *clientHello) setExtension(ext helloExtension) {
+	for i := range h.extensions {
+		if h.extensions[i].typ == ext.typ {
+			h.extensions[i] = ext
+			return
+		}
+	}
+	h.extensions = append(h.extensions, ext)
+}
+
+func writeUint16(b *bytes.Buffer, v uint16) {
+	b.WriteByte(byte(v >> 8))
+	b.WriteByte(byte(v))
+}
+
+func uint16List(values []uint16) []byte {
+	b := new(bytes.Buffer)
+	for _, v := range values {
+		writeUint16(b, v)
+	}
+	return b.Bytes()
+}
+
+func serverNameExtension(name string) helloExtension {
+	b := new(bytes.Buffer)
+	writeUint16(b, uint16(len(name)+3))
+	b.WriteByte(0) // host_name
+	writeUint16(b, uint16(len(name)))
+	b.WriteString(name)
+	return helloExtension{extensionServerName, b.Bytes()}
+}
+
+func maxFragmentLengthExtension(code uint8) helloExtension {
+	return helloExtension{extensionMaxFragmentLength, []byte{code}}
+}
+
+// heartbeatPeerAllowedToSend is the heartbeat extension mode letting the peer
+// send heartbeat requests, per RFC 6520.
+const heartbeatPeerAllowedToSend uint8 = 1
+
+func heartbeatExtension(mode uint8) helloExtension {
+	return helloExtension{extensionHeartbeat, []byte{mode}}
+}
+
+func supportedGroupsExtension(groups ...uint16) helloExtension {
+	b := new(bytes.Buffer)
+	writeUint16(b, uint16(2*len(groups)))
+	b.Write(uint16List(groups))
+	return helloExtension{extensionSupportedGroups, b.Bytes()}
+}
+
+func signatureAlgorithmsExtension(schemes ...uint16) helloExtension {
+	b := new(bytes.Buffer)
+	writeUint16(b, uint16(2*len(schemes)))
+	b.Write(uint16List(schemes))
+	return helloExtension{extensionSignatureAlgorithms, b.Bytes()}
+}
+
+func supportedVersionsExtension(versions ...uint16) helloExtension {
+	b := new(bytes.Buffer)
+	b.WriteByte(byte(2 * len(versions)))
+	b.Write(uint16List(versions))
+	return helloExtension{extensionSupportedVersions, b.Bytes()}
+}
+
+func keyShareExtension(group uint16, key []byte) helloExtension {
+	return keySharesExtension(map[uint16][]byte{group: key}, group)
+}
+
+// keySharesExtension shares keys[group] for each of groups, in order.
+func keySharesExtension(keys map[uint16][]byte, groups ...uint16) helloExtension {
+	shares := new(bytes.Buffer)
+	for _, group := range groups {
+		writeUint16(shares, group)
+		writeUint16(shares, uint16(len(keys[group])))
+		shares.Write(keys[group])
+	}
+	b := new(bytes.Buffer)
+	writeUint16(b, uint16(shares.Len()))
+	b.Write(shares.Bytes())
+	return helloExtension{extensionKeyShare, b.Bytes()}
+}
+
+// marshal returns the ClientHello as a single handshake record.
+func (h *clientHello) marshal() []byte {
+	body := new(bytes.Buffer)
+	writeUint16(body, h.vers)
+	body.Write(h.random[:])
+	body.WriteByte(byte(len(h.sessionID)))
+	body.Write(h.sessionID)
+	writeUint16(body, uint16(2*len(h.cipherSuites)))
+	body.Write(uint16List(h.cipherSuites))
+	body.Write([]byte{1, 0}) // null compression only
+
+	extensions := new(bytes.Buffer)
+	for _, ext := range h.extensions {
+		writeUint16(extensions, ext.typ)
+		writeUint16(extensions, uint16(len(ext.data)))
+		extensions.Write(ext.data)
+	}
+	writeUint16(body, uint16(extensions.Len()))
+	body.Write(extensions.Bytes())
+
+	record := new(bytes.Buffer)
+	record.WriteByte(recordTypeHandshake)
+	writeUint16(record, versionTLS10)
+	writeUint16(record, uint16(body.Len()+4))
+	record.WriteByte(typeClientHello)
+	record.Write([]byte{byte(body.Len() >> 16), byte(body.Len() >> 8), byte(body.Len())})
+	record.Write(body.Bytes())
+	return record.Bytes()
+}
+
+// serverHello is the parsed response to a hand-built ClientHello.
+type serverHello struct {
+	vers              uint16
+	random            []byte
+	sessionID         []byte
+	cipherSuite       uint16
+	compressionMethod uint8
+	extensions        map[uint16][]byte
+}
+
+// version returns the protocol version selected by the server.
+func (h *serverHello) version() uint16 {
+	if v := h.extensions[extensionSupportedVersions]; len(v) == 2 {
+		return binary.BigEndian.Uint16(v)
+	}
+	return h.vers
+}
+
+// keyShareGroup returns the group of the key shared by the server, or the
+// group a HelloRetryRequest asks the client to share a key for.
+func (h *serverHello) keyShareGroup() (uint16, bool) {
+	if ext := h.extensions[extensionKeyShare]; len(ext) >= 2 {
+		return binary.BigEndian.Uint16(ext), true
+	}
+	return 0, false
+}
+
+// isHelloRetryRequest reports whether the server asked the client to retry
+// with a key share for another group rather than completing the ServerHello.
+func (h *serverHello) isHelloRetryRequest() bool {
+	return bytes.Equal(h.random, helloRetryRequestRandom)
+}
+
+var errMalformedServerHello = errors.New("malformed ServerHello")
+
+// helloReader consumes a handshake message, failing once it runs out of data.
+type helloReader []byte
+
+func (r *helloReader) next(n int) ([]byte, error) {
+	if len(*r) < n {
+		return nil, errMalformedServerHello
+	}
+	b := (*r)[:n]
+	*r = (*r)[n:]
+	return b, nil
+}
+
+func (r *helloReader) uint8() (uint8, error) {
+	b, err := r.next(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (r *helloReader) uint16() (uint16, error) {
+	b, err := r.next(2)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint16(b), nil
+}
+
+func parseServerHello(data []byte) (hello *serverHello, err error) {
+	r := helloReader(data)
+	hello = &serverHello{extensions: make(map[uint16][]byte)}
+	if hello.vers, err = r.uint16(); err != nil {
+		return nil, err
+	}
+	if hello.random, err = r.next(32); err != nil {
+		return nil, err
+	}
+	sessionIDLen, err := r.uint8()
+	if err != nil {
+		return nil, err
+	}
+	if hello.sessionID, err = r.next(int(sessionIDLen)); err != nil {
+		return nil, err
+	}
+	if hello.cipherSuite, err = r.uint16(); err != nil {
+		return nil, err
+	}
+	if hello.compressionMethod, err = r.uint8(); err != nil {
+		return nil, err
+	}
+	if len(r) == 0 {
+		return hello, nil
+	}
+
+	extensionsLen, err := r.uint16()
+	if err != nil {
+		return nil, err
+	}
+	extensions, err := r.next(int(extensionsLen))
+	if err != nil {
+		return nil, err
+	}
+	er := helloReader(extensions)
+	for len(er) > 0 {
+		typ, err := er.uint16()
+		if err != nil {
+			return nil, err
+		}
+		length, err := er.uint16()
+		if err != nil {
+			return nil, err
+		}
+		if hello.extensions[typ], err = er.next(int(length)); err != nil {
+			return nil, err
+		}
+	}
+	return hello, nil
+}
+
+// alert is a TLS alert received in place of a handshake message.
+type alert uint8
+
+var alertNames = map[alert]string{
+	0:   "close notify",
+	10:  "unexpected message",
+	40:  "handshake failure",
+	47:  "illegal parameter",
+	50:  "decode error",
+	70:  "protocol version",
+	71:  "insufficient security",
+	80:  "internal error",
+	86:  "inappropriate fallback",
+	109: "missing extension",
+	110: "unsupported extension",
+	112: "unrecognized name",
+}
+
+func (a alert) Error() string {
+	if name, ok := alertNames[a]; ok {
+		return "tls: received alert: " + name
+	}
+	return fmt.Sprintf("tls: received alert %d", uint8(a))
+}
+
+// handshakeReader reads successive plaintext handshake messages from the
+// records received on conn.
+type handshakeReader struct {
+	conn      io.Reader
+	handshake []byte
+	// alertLevel is the level of the last alert received.
+	alertLevel uint8
+}
+
+// next returns the type and body of the next handshake message, reading
+// records until it is complete.
+func (r *handshakeReader) next() (typ uint8, body []byte, err error) {
+	header := make([]byte, 5)
+	for {
+		if len(r.handshake) >= 4 {
+			length := int(r.handshake[1])<<16 | int(r.handshake[2])<<8 | int(r.handshake[3])
+			if len(r.handshake) >= 4+length {
+				typ, body = r.handshake[0], r.handshake[4:4+length]
+				r.handshake = r.handshake[4+length:]
+				return typ, body, nil
+			}
+		}
+
+		if _, err = io.ReadFull(r.conn, header); err != nil {
+			return
+		}
+		payload := make([]byte, binary.BigEndian.Uint16(header[3:]))
+		if _, err = io.ReadFull(r.conn, payload); err != nil {
+			return
+		}
+
+		switch header[0] {
+		case recordTypeAlert:
+			if len(payload) < 2 {
+				return 0, nil, errors.New("malformed alert")
+			}
+			r.alertLevel = payload[0]
+			return 0, nil, alert(payload[1])
+		case recordTypeHandshake:
+			r.handshake = append(r.handshake, payload...)
+		default:
+			return 0, nil, fmt.Errorf("expected handshake record, got record type %d", header[0])
+		}
+	}
+}
+
+// serverHello reads the next handshake message, which must be a ServerHello.
+func (r *handshakeReader) serverHello() (*serverHello, error) {
+	typ, body, err := r.next()
+	if err != nil {
+		return nil, err
+	}
+	if typ != typeServerHello {
+		return nil, fmt.Errorf("expected ServerHello, got handshake message type %d", typ)
+	}
+	return parseServerHello(body)
+}
+
+// readServerHello reads handshake records from conn until it has received a
+// complete ServerHello.
+func readServerHello(conn io.Reader) (*serverHello, error) {
+	return (&handshakeReader{conn: conn}).serverHello()
+}
+
+// sendClientHello sends hello to host and returns the ServerHello it responds with.
+func sendClientHello(host string, hello *clientHello) (*serverHello, error) {
+	conn, err := dial(Network, host)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(helloTimeout))
+
+	if _, err = conn.Write(hello.marshal()); err != nil {
+		return nil, err
+	}
+	return readServerHello(conn)
+}
@@ -0,0 +1,236 @@
+package scan
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// HTTP contains scanners to test application layer HTTP(S) features
+var HTTP = &Family{
+	Description: "Scans for the host's HTTP(S) configuration",
+	Scanners: map[string]*Scanner{
+		"HTTPSRedirect": {
+			Description: "Host redirects plaintext HTTP requests to HTTPS",
+			scan:        httpsRedirectScan,
+		},
+		"TimeToFirstByte": {
+			Description: "Host starts responding to HTTPS requests promptly",
+			scan:        ttfbScan,
+		},
+	},
+}
+
+var (
+	// MaxRedirects is the maximum number of HTTPS redirects followed by
+	// RunScansFollowingRedirects.
+	MaxRedirects = 10
+	// httpPort is the port on which the host is expected to serve plaintext HTTP.
+	httpPort = "80"
+
+	// TTFBWarning and TTFBBad are the times to first byte from which the
+	// host's response is graded Warning and Bad respectively.
+	TTFBWarning = 500 * time.Millisecond
+	TTFBBad     = 2 * time.Second
+	// ttfbTimeout bounds how long ttfbScan waits for the host to respond.
+	ttfbTimeout = 10 * time.Second
+)
+
+// httpRedirect describes a host's response to a plaintext HTTP request.
+type httpRedirect struct {
+	StatusCode int    `json:"status_code"`
+	Location   string `json:"location,omitempty"`
+}
+
+func (r httpRedirect) String() string {
+	if r.Location == "" {
+		return fmt.Sprintf("HTTP %d without redirect", r.StatusCode)
+	}
+	return fmt.Sprintf("HTTP %d redirect to %s", r.StatusCode, r.Location)
+}
+
+// httpsRedirectScan tests that a plaintext HTTP request to the host is
+// permanently redirected to HTTPS.
+func httpsRedirectScan(host string) (grade Grade, output Output, err error) {
+	hostname, _, err := net.SplitHostPort(host)
+	if err != nil {
+		return
+	}
+
+	// The transport is used directly so that redirects are reported rather than followed.
+	transport := &http.Transport{Dial: dial, DisableKeepAlives: true}
+	req, err := http.NewRequest("GET", "http://"+net.JoinHostPort(hostname, httpPort)+"/", nil)
+	if err != nil {
+		return
+	}
+	req.Host = hostname
+
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		// A host that doesn't serve plaintext HTTP has nothing to redirect.
+		return Skipped, nil, nil
+	}
+	resp.Body.Close()
+
+	redirect := httpRedirect{StatusCode: resp.StatusCode}
+	output = redirect
+	location := resp.Header.Get("Location")
+	if location == "" {
+		grade = Warning
+		return
+	}
+
+	target, err := req.URL.Parse(location)
+	if err != nil {
+		return
+	}
+	redirect.Location = target.String()
+	output = redirect
+
+	switch {
+	case target.Scheme != "https":
+		grade = Warning
+	case resp.StatusCode == http.StatusMovedPermanently || resp.StatusCode == 308:
+		grade = Good
+	default:
+		// Temporary redirects to HTTPS aren't cached by clients.
+		grade = Warning
+	}
+	return
+}
+
+// timeToFirstByte is how long the host took to send the first byte of its
+// response to an HTTPS request.
+type timeToFirstByte time.Duration
+
+func (t timeToFirstByte) String() string {
+	return fmt.Sprintf("first response byte after %s", time.Duration(t))
+}
+
+// noFirstByte is the output of ttfbScan for hosts that don't start responding
+// within ttfbTimeout.
+type noFirstByte time.Duration
+
+func (t noFirstByte) String() string {
+	return fmt.Sprintf("no response byte within %s", time.Duration(t))
+}
+
+// ttfbScan tests how long the host takes to start responding to a minimal
+// HTTPS request, measured from the moment the request is sent over an
+// established TLS connection. Hosts that send nothing within ttfbTimeout are
+// Bad, while hosts that don't answer the request with HTTP are Skipped.
+func ttfbScan(host string) (grade Grade, output Output, err error) {
+	config := defaultTLSConfig(host)
+	conn, err := tlsDial(host, config)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(ttfbTimeout))
+
+	start := time.Now()
+	if _, err = fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: %s\r\nConnection: close\r\n\r\n", config.ServerName); err != nil {
+		return
+	}
+	r := bufio.NewReader(conn)
+	if _, readErr := r.Peek(1); readErr != nil {
+		if netErr, ok := readErr.(net.Error); ok && netErr.Timeout() {
+			return Bad, noFirstByte(ttfbTimeout), nil
+		}
+		return Skipped, nil, nil
+	}
+	ttfb := time.Since(start)
+	if prefix, readErr := r.Peek(5); readErr != nil || !bytes.Equal(prefix, []byte("HTTP/")) {
+		return Skipped, nil, nil
+	}
+
+	output = timeToFirstByte(ttfb)
+	switch {
+	case ttfb >= TTFBBad:
+		grade = Bad
+	case ttfb >= TTFBWarning:
+		grade = Warning
+	default:
+		grade = Good
+	}
+	return
+}
+
+// httpsRedirectTarget returns the URL that an HTTPS request for u is
+// redirected to, resolved against u, or nil if it isn't redirected to HTTPS.
+func httpsRedirectTarget(u *url.URL) (*url.URL, error) {
+	host := urlHost(u)
+	transport := &http.Transport{
+		DialTLS: func(network, addr string) (net.Conn, error) {
+			return tlsDial(addr, defaultTLSConfig(host))
+		},
+		DisableKeepAlives: true,
+	}
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	location := resp.Header.Get("Location")
+	if resp.StatusCode/100 != 3 || location == "" {
+		return nil, nil
+	}
+	target, err := req.URL.Parse(location)
+	if err != nil || target.Scheme != "https" {
+		return nil, err
+	}
+	return target, nil
+}
+
+// urlHost returns the host and port u refers to, the port defaulting to 443.
+func urlHost(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	return net.JoinHostPort(u.Hostname(), "443")
+}
+
+// RunScansFollowingRedirects runs the scans matching the family and scanner
+// regular expressions against host and against each host it is redirected to
+// over HTTPS, up to MaxRedirects hops. Each redirect is requested with the
+// path and port of its Location, so redirects within a host are followed to
+// wherever they lead. A report is returned for each host reached, in the
+// order the redirects were followed.
+func (fs FamilySet) RunScansFollowingRedirects(host, family, scanner string) ([]HostReport, error) {
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, "443")
+	}
+
+	var reports []HostReport
+	scanned := make(map[string]bool)
+	visited := make(map[string]bool)
+	target := &url.URL{Scheme: "https", Host: host, Path: "/"}
+	for hops := 0; target != nil && !visited[target.String()] && hops <= MaxRedirects; hops++ {
+		visited[target.String()] = true
+		if host = urlHost(target); !scanned[host] {
+			scanned[host] = true
+			results, err := fs.RunScans(host, family, scanner)
+			if err != nil {
+				return nil, err
+			}
+			reports = append(reports, HostReport{Host: host, Families: results})
+		}
+
+		var err error
+		if target, err = httpsRedirectTarget(target); err != nil {
+			// The hop's own scans report why HTTPS requests to it fail.
+			break
+		}
+	}
+	return reports, nil
+}
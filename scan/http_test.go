@@ -0,0 +1,273 @@
+package scan
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// withHTTPServer starts a plaintext HTTP server running handler and points
+// httpPort at it for the duration of f.
+func withHTTPServer(t *testing.T, handler http.HandlerFunc, f func()) {
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(p string) { httpPort = p }(httpPort)
+	httpPort = port
+	f()
+}
+
+func TestHTTPSRedirectScan(t *testing.T) {
+	redirect := func(code int) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "https://"+r.Host+r.URL.Path, code)
+		}
+	}
+
+	cases := []struct {
+		handler http.HandlerFunc
+		grade   Grade
+	}{
+		{redirect(http.StatusMovedPermanently), Good},
+		{redirect(308), Good},
+		{redirect(http.StatusFound), Warning},
+		{func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "http://elsewhere.example.com/", http.StatusMovedPermanently)
+		}, Warning},
+		{func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "hello over plaintext")
+		}, Warning},
+	}
+
+	for i, c := range cases {
+		withHTTPServer(t, c.handler, func() {
+			grade, output, err := httpsRedirectScan("127.0.0.1:443")
+			if err != nil {
+				t.Fatalf("case %d: %v", i, err)
+			}
+			if grade != c.grade {
+				t.Fatalf("case %d: expected grade %s, got %s (%s)", i, c.grade, grade, output)
+			}
+		})
+	}
+}
+
+func TestHTTPSRedirectScanOutput(t *testing.T) {
+	withHTTPServer(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "https://127.0.0.1/", http.StatusMovedPermanently)
+	}, func() {
+		_, output, err := httpsRedirectScan("127.0.0.1:443")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if output.String() != "HTTP 301 redirect to https://127.0.0.1/" {
+			t.Fatalf("unexpected output: %s", output)
+		}
+	})
+
+	withHTTPServer(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "hello over plaintext")
+	}, func() {
+		_, output, err := httpsRedirectScan("127.0.0.1:443")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if output.String() != "HTTP 200 without redirect" {
+			t.Fatalf("unexpected output: %s", output)
+		}
+	})
+}
+
+func TestHTTPSRedirectScanNoHTTP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	l.Close()
+
+	defer func(p string) { httpPort = p }(httpPort)
+	httpPort = port
+	grade, _, err := httpsRedirectScan("127.0.0.1:443")
+	if err != nil || grade != Skipped {
+		t.Fatalf("expected host without plaintext HTTP to be skipped, got %s: %v", grade, err)
+	}
+}
+
+func TestRunScansFollowingRedirects(t *testing.T) {
+	final := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "final destination")
+	}))
+	defer final.Close()
+
+	first := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, final.URL+"/landing", http.StatusMovedPermanently)
+	}))
+	defer first.Close()
+
+	fs := FamilySet{
+		"Hops": &Family{
+			Description: "Records each scanned hop",
+			Scanners: map[string]*Scanner{
+				"Host": {
+					Description: "Returns the scanned host",
+					scan: func(host string) (Grade, Output, error) {
+						return Good, OutputString(host), nil
+					},
+				},
+			},
+		},
+	}
+
+	firstHost := first.Listener.Addr().String()
+	finalHost := final.Listener.Addr().String()
+	reports, err := fs.RunScansFollowingRedirects(firstHost, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 2 {
+		t.Fatalf("expected 2 hops, got %d", len(reports))
+	}
+	for i, host := range []string{firstHost, finalHost} {
+		if reports[i].Host != host {
+			t.Fatalf("hop %d: expected host %s, got %s", i, host, reports[i].Host)
+		}
+		result := reports[i].Families["Hops"]["Host"]
+		if result.Output.String() != host {
+			t.Fatalf("hop %d: scanner ran against %s rather than %s", i, result.Output, host)
+		}
+	}
+}
+
+func TestRunScansFollowingRedirectsPath(t *testing.T) {
+	paths := make(chan string, 1)
+	final := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths <- r.URL.Path
+	}))
+	defer final.Close()
+
+	// The first host only redirects elsewhere once its own login page is
+	// requested, so the relative redirect's path has to be kept.
+	first := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			http.Redirect(w, r, final.URL+"/landing", http.StatusFound)
+			return
+		}
+		http.Redirect(w, r, "/login", http.StatusFound)
+	}))
+	defer first.Close()
+
+	reports, err := FamilySet{}.RunScansFollowingRedirects(first.Listener.Addr().String(), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 2 || reports[1].Host != final.Listener.Addr().String() {
+		t.Fatalf("expected the redirects to lead to %s, got %v", final.Listener.Addr(), reports)
+	}
+	if path := <-paths; path != "/landing" {
+		t.Fatalf("expected the final host to be asked for /landing, got %s", path)
+	}
+}
+
+func TestRunScansFollowingRedirectsLoop(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, server.URL+"/again", http.StatusMovedPermanently)
+	}))
+	defer server.Close()
+
+	reports, err := FamilySet{}.RunScansFollowingRedirects(server.Listener.Addr().String(), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 1 {
+		t.Fatalf("expected redirect loop to be scanned once, got %d hops", len(reports))
+	}
+}
+
+func TestTTFBScan(t *testing.T) {
+	defer func(w, b time.Duration) { TTFBWarning, TTFBBad = w, b }(TTFBWarning, TTFBBad)
+	TTFBWarning, TTFBBad = 100*time.Millisecond, 300*time.Millisecond
+
+	cases := []struct {
+		delay time.Duration
+		grade Grade
+	}{
+		{0, Good},
+		{150 * time.Millisecond, Warning},
+		{400 * time.Millisecond, Bad},
+	}
+
+	for _, c := range cases {
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(c.delay)
+			fmt.Fprint(w, "hello")
+		}))
+		grade, output, err := ttfbScan(server.Listener.Addr().String())
+		server.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if grade != c.grade {
+			t.Fatalf("response delayed %s: expected %s, got %s (%s)", c.delay, c.grade, grade, output)
+		}
+		if ttfb := time.Duration(output.(timeToFirstByte)); ttfb < c.delay {
+			t.Fatalf("response delayed %s: measured %s", c.delay, ttfb)
+		}
+	}
+}
+
+func TestTTFBScanNoResponse(t *testing.T) {
+	defer func(timeout time.Duration) { ttfbTimeout = timeout }(ttfbTimeout)
+	ttfbTimeout = 200 * time.Millisecond
+	done := make(chan struct{})
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+
+	grade, output, err := ttfbScan(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grade != Bad || output.String() != "no response byte within 200ms" {
+		t.Fatalf("expected host never responding to be Bad, got %s (%v)", grade, output)
+	}
+}
+
+func TestTTFBScanNonHTTP(t *testing.T) {
+	// Borrow the certificate of a test server for a TLS service speaking
+	// another protocol.
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", server.TLS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		fmt.Fprint(conn, "SSH-2.0-OpenSSH_9.0\r\n")
+	}()
+
+	grade, output, err := ttfbScan(ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grade != Skipped {
+		t.Fatalf("expected %s, got %s (%v)", Skipped, grade, output)
+	}
+}
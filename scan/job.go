@@ -0,0 +1,103 @@
+package scan
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// Job describes a batch of scans in JSON, so that they can be driven by tools
+// other than Go programs through RunJob. For example:
+//
+//	{
+//	  "hosts": ["example.com", "example.net:8443"],
+//	  "targets": [{"host": "api.example.com", "tags": {"env": "prod"}}],
+//	  "family": "PKI|TLSHandshake",
+//	  "scanner": "",
+//	  "policy": {"PKI/CertExpiration": {"reclassify": {"Warning": "Bad"}}},
+//	  "host_concurrency": 4,
+//	  "scanner_concurrency": 2
+//	}
+type Job struct {
+	// Hosts are scanned as untagged targets, after Targets.
+	Hosts   []string `json:"hosts,omitempty"`
+	Targets []Target `json:"targets,omitempty"`
+	// Family and Scanner are regular expressions selecting the scans to run,
+	// as given to RunScans.
+	Family  string `json:"family,omitempty"`
+	Scanner string `json:"scanner,omitempty"`
+	// Policy overrides the grades given by scanners, named as in GradePolicy.
+	Policy map[string]PolicyRule `json:"policy,omitempty"`
+	// HostConcurrency and ScannerConcurrency replace the package defaults
+	// when set.
+	HostConcurrency    int `json:"host_concurrency,omitempty"`
+	ScannerConcurrency int `json:"scanner_concurrency,omitempty"`
+}
+
+// PolicyRule is a GradeOverride in JSON. Grades are first reclassified, then
+// clamped to between Min and Max when they are set.
+type PolicyRule struct {
+	Reclassify map[string]Grade `json:"reclassify,omitempty"`
+	Min        *Grade           `json:"min,omitempty"`
+	Max        *Grade           `json:"max,omitempty"`
+}
+
+// override returns the GradeOverride described by the rule.
+func (r PolicyRule) override() (GradeOverride, error) {
+	remap := make(map[Grade]Grade)
+	for name, to := range r.Reclassify {
+		from, err := parseGrade(name)
+		if err != nil {
+			return nil, err
+		}
+		remap[from] = to
+	}
+	min, max := Bad, Good
+	if r.Min != nil {
+		min = *r.Min
+	}
+	if r.Max != nil {
+		max = *r.Max
+	}
+	reclassify, clamp := Reclassify(remap), Clamp(min, max)
+	return func(g Grade) Grade {
+		return clamp(reclassify(g))
+	}, nil
+}
+
+// RunJob runs the scans described by jobJSON, a Job, against the Default
+// families and returns the resulting reports as JSON, one for each target in
+// order. The job's policy replaces Policy, and its concurrency limits
+// HostConcurrency and ScannerConcurrency, for its own scans only.
+func RunJob(jobJSON []byte) (reportJSON []byte, err error) {
+	var job Job
+	if err = json.Unmarshal(jobJSON, &job); err != nil {
+		return nil, err
+	}
+	targets := job.Targets
+	for _, host := range job.Hosts {
+		targets = append(targets, Target{Host: host})
+	}
+	if len(targets) == 0 {
+		return nil, errors.New("job has no hosts to scan")
+	}
+
+	opts := defaultScanOptions()
+	opts.policy = GradePolicy{}
+	for name, rule := range job.Policy {
+		if opts.policy[name], err = rule.override(); err != nil {
+			return nil, err
+		}
+	}
+	if job.HostConcurrency > 0 {
+		opts.hostConcurrency = job.HostConcurrency
+	}
+	if job.ScannerConcurrency > 0 {
+		opts.scannerConcurrency = job.ScannerConcurrency
+	}
+
+	reports, err := Default.scanTargets(targets, job.Family, job.Scanner, opts)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(reports)
+}
@@ -0,0 +1,83 @@
+package scan
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRunJob(t *testing.T) {
+	defer func(fs FamilySet) { Default = fs }(Default)
+	Default = FamilySet{
+		"Hosts": hostGradeFamily(map[string]Grade{
+			"a.example.com:443": Good,
+			"b.example.com:443": Warning,
+		}),
+		"Fixed":  gradeFamily(Warning),
+		"Sleeps": sleepingFamily(3, 0),
+		"Globals": {
+			Scanners: map[string]*Scanner{
+				"Observe": {scan: func(host string) (Grade, Output, error) {
+					if len(Policy) != 0 || HostConcurrency != 1 || ScannerConcurrency != 1 {
+						t.Error("job settings replaced the package defaults")
+					}
+					return Good, nil, nil
+				}},
+			},
+		},
+	}
+
+	job := []byte(`{
+		"targets": [{"host": "a.example.com:443", "tags": {"env": "prod"}}],
+		"hosts": ["b.example.com:443"],
+		"family": "Hosts|Fixed|Sleeps|Globals",
+		"policy": {
+			"Fixed": {"reclassify": {"Warning": "Bad"}},
+			"Hosts/ByHost": {"min": "Warning", "max": "Legacy"}
+		},
+		"host_concurrency": 2,
+		"scanner_concurrency": 3
+	}`)
+	reportJSON, err := RunJob(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var reports []struct {
+		Host     string
+		Tags     map[string]string
+		Families map[string]map[string]struct{ Grade Grade }
+	}
+	if err = json.Unmarshal(reportJSON, &reports); err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 2 || reports[0].Host != "a.example.com:443" || reports[1].Host != "b.example.com:443" {
+		t.Fatalf("unexpected reports %s", reportJSON)
+	}
+	if reports[0].Tags["env"] != "prod" || reports[1].Tags != nil {
+		t.Fatalf("unexpected tags %s", reportJSON)
+	}
+	for i, expected := range []Grade{Legacy, Warning} {
+		families := reports[i].Families
+		if families["Hosts"]["ByHost"].Grade != expected || families["Fixed"]["Fixed"].Grade != Bad || len(families["Sleeps"]) != 3 {
+			t.Fatalf("unexpected report for %s: %+v", reports[i].Host, families)
+		}
+	}
+
+	if len(Policy) != 0 || HostConcurrency != 1 || ScannerConcurrency != 1 {
+		t.Fatal("job settings outlived the job")
+	}
+}
+
+func TestRunJobErrors(t *testing.T) {
+	for _, job := range []string{
+		`not json`,
+		`{}`,
+		`{"hosts": ["example.com"], "policy": {"Fixed": {"reclassify": {"Awful": "Bad"}}}}`,
+		`{"hosts": ["example.com"], "policy": {"Fixed": {"min": "Awful"}}}`,
+		`{"hosts": ["example.com"], "family": "("}`,
+	} {
+		if _, err := RunJob([]byte(job)); err == nil {
+			t.Fatalf("expected job %s to fail", job)
+		}
+	}
+}
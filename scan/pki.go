@@ -1,13 +1,52 @@
 package scan
 
 import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"mime"
 	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/cloudflare/cf-tls/tls"
 	"github.com/cloudflare/cfssl/bundler"
+	"github.com/cloudflare/cfssl/crypto/pkcs7"
+	"github.com/cloudflare/cfssl/helpers"
+	"golang.org/x/crypto/ocsp"
+	"golang.org/x/net/idna"
+	"golang.org/x/net/publicsuffix"
+)
+
+const (
+	// pkiFamily names PKI in Default.
+	pkiFamily = "PKI"
+	// issuerScanner names the PKI scanner whose results IssuerOutliers reads.
+	issuerScanner = "Issuer"
+	// compromisedKeyScanner names the PKI scanner whose results ReusedKeys reads.
+	compromisedKeyScanner = "CompromisedKey"
 )
 
 // PKI contains scanners to test application layer HTTP(S) features
@@ -15,8 +54,334 @@ var PKI = &Family{
 	Description: "Scans for the Public Key Infrastructure",
 	Scanners: map[string]*Scanner{
 		"IntermediateCAs": {
-			"Scans a CIDR IP range for unknown Intermediate CAs",
-			intermediateCAScan,
+			Description: "Scans a CIDR IP range for unknown Intermediate CAs",
+			Category:    "Chain",
+			Remediation: "Submit the unknown intermediate CAs to the CFSSL bundle so that clients can build chains through them.",
+			scan:        intermediateCAScan,
+		},
+		"CertExpiration": certExpirationScanner(defaultExpiryBuckets),
+		"BroadWildcard": {
+			Description: "Host's certificate has no wildcard names covering an entire public suffix",
+			Category:    "Certificate",
+			Remediation: "Replace the certificate with one whose wildcards are beneath a domain you control, and report any mis-issued certificate to its CA.",
+			Reference:   "https://publicsuffix.org/",
+			scanState:   broadWildcardScan,
+		},
+		"ChainSize": {
+			Description: "Host's certificate chain is small enough not to slow down the handshake",
+			Category:    "Chain",
+			Remediation: "Stop sending certificates clients don't need, such as the root, and prefer ECDSA keys, whose certificates are smaller.",
+			scanState:   chainSizeScan,
+		},
+		"ChainValidity": {
+			Description: "Host's intermediate certificates are valid for as long as the certificates they issued",
+			Category:    "Chain",
+			Remediation: "Replace the flagged intermediates with ones valid for the whole lifetime of the certificates they issued, or reissue those certificates to expire sooner.",
+			scanState:   chainValidityScan,
+		},
+		"ExpiredRoot": {
+			Description: "Host doesn't include an expired root certificate in its chain",
+			Category:    "Chain",
+			Remediation: "Stop serving the expired root. Clients that trust the root already have it, and some fail when it is included after it expires.",
+			scanState:   expiredRootScan,
+		},
+		"ChainVerification": {
+			Description: "Host's certificate chain verifies against the system roots as a browser would build it",
+			Category:    "Chain",
+			Remediation: "Serve every intermediate certificate needed to reach a publicly trusted root, and make sure the leaf covers the host name.",
+			Reference:   "https://tools.ietf.org/html/rfc5280#section-6",
+			scanState:   chainVerificationScan,
+		},
+		"AIAFormat": {
+			Description: "Host's caIssuers URLs each serve a single DER certificate",
+			Category:    "Chain",
+			Remediation: "Serve the issuer as a single DER certificate with the application/pkix-cert content type at each caIssuers URL.",
+			Reference:   "https://tools.ietf.org/html/rfc5280#section-4.2.2.1",
+			scanState:   aiaFormatScan,
+		},
+		"BackendCertificates": {
+			Description: "Every address of host serves the same certificate",
+			Category:    "Certificate",
+			Remediation: "Deploy the renewed certificate to every backend, and retire the old one wherever it lingers.",
+			scan:        backendCertsScan,
+		},
+		"ChainCompletion": {
+			Description: "Host's certificate chain verifies without fetching missing intermediates through AIA",
+			Category:    "Chain",
+			Remediation: "Serve the intermediate certificates fetched through AIA, since many clients can't fetch them.",
+			Reference:   "https://tools.ietf.org/html/rfc5280#section-4.2.2.1",
+			scanState:   chainCompletionScan,
+		},
+		compromisedKeyScanner: {
+			Description: "Host's certificate key is not known to be compromised",
+			Category:    "Key",
+			Remediation: "Generate a new key pair, reissue the certificate for it and revoke the certificate for the compromised key.",
+			scanState:   compromisedKeyScan,
+		},
+		"DNSCert": {
+			Description: "Host's certificate matches the DNS CERT records published for it",
+			Category:    "Certificate",
+			Remediation: "Publish CERT records holding the certificate currently served, or remove stale ones.",
+			Reference:   "https://tools.ietf.org/html/rfc4398",
+			scanState:   dnsCertScan,
+		},
+		"DanglingSANs": {
+			Description: "Every DNS name in host's certificate resolves",
+			Category:    "Certificate",
+			Remediation: "Remove names that no longer resolve from the certificate, and delete any DNS records still delegating them to resources you no longer control.",
+			scanState:   danglingSANScan,
+		},
+		"DANE": {
+			Description: "Host's certificate matches the TLSA records published for it",
+			Category:    "Chain",
+			Remediation: "Publish TLSA records matching the certificate or key currently served, before rotating either.",
+			Reference:   "https://tools.ietf.org/html/rfc6698",
+			scanState:   daneScan,
+		},
+		"DeprecatedExtensions": {
+			Description: "Host's certificate carries no deprecated or unrecognized critical extensions",
+			Category:    "Certificate",
+			Remediation: "Reissue the certificate from a profile that omits the flagged extensions.",
+			Reference:   "https://tools.ietf.org/html/rfc5280#section-4.2",
+			scanState:   deprecatedExtensionScan,
+		},
+		"SignedCertificateTimestamps": {
+			Description: "Host provides Certificate Transparency SCTs in its certificate, TLS handshake or stapled OCSP response",
+			Category:    "Transparency",
+			Remediation: "Use a CA that embeds SCTs from several logs, or deliver SCTs in the TLS extension or a stapled OCSP response.",
+			Reference:   "https://tools.ietf.org/html/rfc6962#section-3.3",
+			scanState:   sctScan,
+		},
+		"CTPolicies": {
+			Description: "Host's certificate carries enough SCTs for its lifetime to satisfy each of CTPolicies",
+			Category:    "Transparency",
+			Remediation: "Ask the CA to embed SCTs from more logs, shorten the certificate's lifetime, or deliver SCTs in the TLS extension or a stapled OCSP response.",
+			Reference:   "https://support.apple.com/en-us/103214",
+			scanState:   ctPolicyScan,
+		},
+		"QualifiedCTLogs": {
+			Description: "Host's certificate SCTs come from enough logs in QualifiedCTLogs",
+			Category:    "Transparency",
+			Remediation: "Ask the CA to obtain SCTs from currently qualified logs, since SCTs from retired or distrusted logs don't count towards CT policies.",
+			Reference:   "https://googlechrome.github.io/CertificateTransparency/log_states.html",
+			scanState:   qualifiedLogScan,
+		},
+		"CTIssuerPolicy": {
+			Description: "Host's certificate carries enough SCTs if its issuer is subject to CT enforcement",
+			Category:    "Transparency",
+			Remediation: "Ask the CA to embed SCTs in the certificate, or deliver SCTs in the TLS extension or a stapled OCSP response.",
+			Reference:   "https://tools.ietf.org/html/rfc6962#section-3.3",
+			scanState:   ctIssuerPolicyScan,
+		},
+		"ExtendedKeyUsage": {
+			Description: "Host's certificate is scoped to a narrow set of extended key usages",
+			Category:    "Certificate",
+			Remediation: "Reissue the certificate with only the serverAuth extended key usage, and clientAuth if it is also used as a client certificate.",
+			Reference:   "https://tools.ietf.org/html/rfc5280#section-4.2.1.12",
+			scanState:   extKeyUsageScan,
+		},
+		"EKUChaining": {
+			Description: "Host's CAs that constrain extended key usage allow serverAuth",
+			Category:    "Chain",
+			Remediation: "Issue the certificate from a CA whose extended key usage includes serverAuth, or none at all.",
+			Reference:   "https://tools.ietf.org/html/rfc5280#section-4.2.1.12",
+			scanState:   ekuChainingScan,
+		},
+		"CTMergeDelay": {
+			Description: "Host's certificate SCTs are older than the logs' Maximum Merge Delay",
+			Category:    "Transparency",
+			Remediation: "Allow the Maximum Merge Delay to pass after issuance before deploying a certificate, so that it can be found in the logs.",
+			Reference:   "https://tools.ietf.org/html/rfc6962#section-3",
+			scanState:   ctMergeDelayScan,
+		},
+		"SCTTimestamps": {
+			Description: "Host's certificate SCTs were issued within the certificate's validity period and not in the future",
+			Category:    "Transparency",
+			Remediation: "Report SCTs with anomalous timestamps to the CA and the operator of the log that issued them.",
+			Reference:   "https://tools.ietf.org/html/rfc6962#section-3.2",
+			scanState:   sctTimestampScan,
+		},
+		"Backdating": {
+			Description: "Host's certificate isn't backdated far before its earliest SCT",
+			Category:    "Transparency",
+			Remediation: "Ask the CA to limit how far it backdates notBefore, which shouldn't precede issuance by more than a small allowance for clock skew.",
+			scanState:   backdatingScan,
+		},
+		"IDNEncoding": {
+			Description: "Host's certificate names are properly encoded A-labels covering the normalized host name",
+			Category:    "Certificate",
+			Remediation: "Reissue the certificate with internationalized names encoded as valid IDNA A-labels.",
+			Reference:   "https://tools.ietf.org/html/rfc5891",
+			scanState:   idnScan,
+		},
+		issuerScanner: {
+			Description: "Reports the issuer of the host's certificate",
+			Category:    "Inventory",
+			Remediation: "Confirm that the issuing CA is approved for the host, and reissue the certificate from an approved CA if not.",
+			scanState:   issuerScan,
+		},
+		"ChainAlgorithms": {
+			Description: "Host's chain uses the same key algorithm throughout",
+			Category:    "Chain",
+			Remediation: "Serve a chain issued under CAs using the leaf's key algorithm if old clients must connect, such as an ECDSA leaf under an ECDSA intermediate.",
+			scanState:   chainAlgorithmsScan,
+		},
+		"CertParsing": {
+			Description: "Host's certificates parse strictly without failures or anomalies",
+			Category:    "Certificate",
+			Remediation: "Reissue the certificate from a CA that encodes certificates as RFC 5280 requires.",
+			Reference:   "https://tools.ietf.org/html/rfc5280#section-4.1",
+			scanState:   certParseScan,
+		},
+		"LifetimeEdge": {
+			Description: "Host's certificate isn't issued for the full MaxCertLifetime browsers accept",
+			Category:    "Certificate",
+			Remediation: "Issue certificates with shorter lifetimes, such as 90 days, and automate their renewal.",
+			scanState:   lifetimeEdgeScan,
+		},
+		"CTIssuance": {
+			Description: "Certificates for host's name logged to CT were issued at an expected rate by ExpectedIssuers",
+			Category:    "Transparency",
+			Remediation: "Investigate the unexpected certificates for mis-issuance or issuance outside the usual process, and revoke any that aren't wanted.",
+			scan:        ctIssuanceScan,
+		},
+		"Interception": {
+			Description: "Host's certificate chains to one of the roots in ExpectedRoots",
+			Category:    "Chain",
+			Remediation: "Check the network path to the host for a TLS-intercepting proxy, or add the root's SPKI hash to ExpectedRoots if it is legitimate.",
+			scanState:   interceptionScan,
+		},
+		"IssuerCountry": {
+			Description: "Host's certificate was issued by a CA in a country allowed by AllowedIssuerCountries",
+			Category:    "Inventory",
+			Remediation: "Reissue the certificate from a CA located in one of the allowed countries.",
+			scanState:   issuerCountryScan,
+		},
+		"KeyAlgorithm": {
+			Description: "Host's certificate key uses an algorithm supported by its clients",
+			Category:    "Key",
+			Remediation: "Reissue the certificate for an RSA or ECDSA key, or add the algorithm to AllowedKeyAlgorithms if all clients support it.",
+			scanState:   keyAlgorithmScan,
+		},
+		"RSAExponent": {
+			Description: "Host's RSA certificate key uses the standard public exponent 65537",
+			Category:    "Key",
+			Remediation: "Reissue the certificate for a new RSA key generated with the public exponent 65537, which key generation tools use by default.",
+			Reference:   "https://csrc.nist.gov/publications/detail/fips/186/4/final",
+			scanState:   rsaExponentScan,
+		},
+		"KeyUsageCipher": {
+			Description: "Host's certificate keyUsage permits the key exchange of the negotiated cipher suite",
+			Category:    "Key",
+			Remediation: "Reissue the certificate with the digitalSignature keyUsage for ECDHE and DHE cipher suites, and keyEncipherment for static RSA ones, or stop negotiating suites the certificate doesn't permit.",
+			Reference:   "https://tools.ietf.org/html/rfc5246#section-7.4.2",
+			scanState:   keyUsageCipherScan,
+		},
+		"CAKeySize": {
+			Description: "Host's intermediate and root certificates don't use RSA keys of 1024 bits or fewer",
+			Category:    "Key",
+			Remediation: "Replace the flagged CA certificates with ones from the CA's current hierarchy, which uses keys of at least 2048 bits.",
+			Reference:   "https://csrc.nist.gov/publications/detail/sp/800-131a/rev-2/final",
+			scanState:   caKeySizeScan,
+		},
+		"ECDSACurve": {
+			Description: "Host's ECDSA certificate key uses a curve trusted by its clients",
+			Category:    "Key",
+			Remediation: "Reissue the certificate for a key on P-256, P-384 or P-521.",
+			scanState:   ecdsaCurveScan,
+		},
+		"KeyIdentifiers": {
+			Description: "Host's certificate chain includes the key identifiers used to build it",
+			Category:    "Chain",
+			Remediation: "Reissue the flagged certificates with Subject and Authority Key Identifier extensions.",
+			Reference:   "https://tools.ietf.org/html/rfc5280#section-4.2.1.1",
+			scanState:   keyIdentifierScan,
+		},
+		"SubjectKeyIdentifier": {
+			Description: "Reports how the Subject Key Identifier of host's certificate was derived from its key",
+			Category:    "Certificate",
+			Remediation: "Check with the CA that the non-standard Subject Key Identifier derivation is expected, and have it follow the SHA-1 convention of RFC 5280 otherwise.",
+			Reference:   "https://tools.ietf.org/html/rfc5280#section-4.2.1.2",
+			scanState:   skiDerivationScan,
+		},
+		"ApexCoverage": {
+			Description: "Host's certificate covers both the apex domain and its www variant",
+			Category:    "Certificate",
+			Remediation: "Reissue the certificate with both the apex domain and its www variant as subject alternative names, since a wildcard doesn't cover the apex.",
+			scanState:   apexCoverageScan,
+		},
+		"RegistrableDomains": {
+			Description: "Host's certificate isn't shared between many unrelated domains",
+			Category:    "Certificate",
+			Remediation: "Split the certificate into separate certificates for each unrelated domain.",
+			scanState:   registrableDomainScan,
+		},
+		"RenewalWindow": {
+			Description: "Host's certificate has at least RenewalLeadTime left before it expires",
+			Category:    "Certificate",
+			Remediation: "Renew the certificate now, and schedule renewals to start at least RenewalLeadTime before expiry.",
+			scanState:   renewalWindowScan,
+		},
+		"OCSPStaple": {
+			Description: "Host's stapled OCSP response says when it will next be updated",
+			Category:    "Revocation",
+			Remediation: "Have the CA's OCSP responder set nextUpdate, so that clients know when a cached response is stale.",
+			Reference:   "https://tools.ietf.org/html/rfc6960#section-4.2.2.1",
+			scanState:   ocspStapleScan,
+		},
+		"OCSPStatus": {
+			Description: "Host's stapled OCSP response explicitly reports its certificate as good",
+			Category:    "Revocation",
+			Remediation: "Replace a revoked certificate with a newly issued one. For an unknown status, have the CA's OCSP responder serve a response for the certificate, and make sure the host staples the response for the certificate it presents.",
+			Reference:   "https://tools.ietf.org/html/rfc6960#section-2.2",
+			scanState:   ocspStatusScan,
+		},
+		"OCSPStapleTTL": {
+			Description: "Host's stapled OCSP response is valid for between OCSPStapleMinTTL and OCSPStapleMaxTTL",
+			Category:    "Revocation",
+			Remediation: "Have the CA's OCSP responder issue responses valid for a reasonable interval, such as several days.",
+			scanState:   ocspStapleTTLScan,
+		},
+		"OCSPResponder": {
+			Description: "Host's stapled OCSP response is signed by the issuer or a responder it authorized",
+			Category:    "Revocation",
+			Remediation: "Have the CA sign OCSP responses with the issuing key, or with a responder certificate it issued with the OCSP signing extended key usage.",
+			Reference:   "https://tools.ietf.org/html/rfc6960#section-4.2.2.2",
+			scanState:   ocspResponderScan,
+		},
+		"SelfSigned": {
+			Description: "Host's leaf certificate is issued by a CA rather than signed by its own key",
+			Category:    "Certificate",
+			Remediation: "Replace the self-signed certificate, often a development or internal one exposed by mistake, with one issued by a trusted CA.",
+			scanState:   selfSignedScan,
+		},
+		"RevocationInfo": {
+			Description: "Host's certificate advertises an OCSP responder or CRL distribution point",
+			Category:    "Revocation",
+			Remediation: "Use a CA that includes an OCSP responder or CRL distribution point in the certificates it issues.",
+			Reference:   "https://tools.ietf.org/html/rfc5280#section-4.2.1.13",
+			scanState:   revocationInfoScan,
+		},
+		"CRLFreshness": {
+			Description: "Host's certificate's CRLs are reachable, signed by its issuer and not stale",
+			Category:    "Revocation",
+			Remediation: "Have the CA serve each CRL distribution point from a reachable URL, and reissue its CRLs before their nextUpdate.",
+			Reference:   "https://tools.ietf.org/html/rfc5280#section-5",
+			scanState:   crlFreshnessScan,
+		},
+		"SubjectName": {
+			Description: "Host's certificate names its subject in its subject DN or subject alternative names",
+			Category:    "Certificate",
+			Remediation: "Reissue the certificate with the host's names as subject alternative names and a subject of single-valued RDNs.",
+			Reference:   "https://tools.ietf.org/html/rfc5280#section-4.1.2.6",
+			scanState:   subjectNameScan,
+		},
+		"CommonNameOnly": {
+			Description: "Host's certificate doesn't name the host in its common name alone",
+			Category:    "Certificate",
+			Remediation: "Reissue the certificate with the host's names as subject alternative names, which clients require since they stopped checking the common name.",
+			Reference:   "https://tools.ietf.org/html/rfc6125#section-6.4.4",
+			scanState:   commonNameOnlyScan,
 		},
 	},
 }
@@ -36,6 +401,33 @@ var (
 	timeout       = time.Second
 )
 
+// MaxRegistrableDomains is the number of distinct registrable domains a
+// certificate's names can span before it is considered heavily shared.
+var MaxRegistrableDomains = 3
+
+// DeprecatedExtensions names the certificate extensions, keyed by OID, that
+// are obsolete or non-compliant and shouldn't appear in a leaf certificate.
+var DeprecatedExtensions = map[string]string{
+	"2.5.29.1":               "Authority Key Identifier (obsolete)",
+	"2.5.29.3":               "Certificate Policies (obsolete)",
+	"2.5.29.7":               "Subject Alternative Name (obsolete)",
+	"2.5.29.8":               "Issuer Alternative Name (obsolete)",
+	"2.5.29.10":              "Basic Constraints (obsolete)",
+	"2.16.840.1.113730.1.1":  "Netscape Certificate Type",
+	"2.16.840.1.113730.1.2":  "Netscape Base URL",
+	"2.16.840.1.113730.1.3":  "Netscape Revocation URL",
+	"2.16.840.1.113730.1.4":  "Netscape CA Revocation URL",
+	"2.16.840.1.113730.1.7":  "Netscape Certificate Renewal URL",
+	"2.16.840.1.113730.1.8":  "Netscape CA Policy URL",
+	"2.16.840.1.113730.1.12": "Netscape SSL Server Name",
+	"2.16.840.1.113730.1.13": "Netscape Comment",
+}
+
+// CompromisedKeys is the set of known-compromised public keys, such as Debian
+// weak keys or leaked private keys, keyed by the hex-encoded SHA-256 hash of
+// their SubjectPublicKeyInfo.
+var CompromisedKeys = map[string]bool{}
+
 // intermediateCAScan scans for new intermediate CAs not in the trust store.
 func intermediateCAScan(host string) (grade Grade, output Output, err error) {
 	cidr, port, _ := net.SplitHostPort(host)
@@ -82,3 +474,3389 @@ func intermediateCAScan(host string) (grade Grade, output Output, err error) {
 	grade = Good
 	return
 }
+
+// expiration is the time at which a certificate chain expires, and the label
+// of the ExpiryBucket it falls in.
+type expiration struct {
+	Time   time.Time
+	Bucket string
+}
+
+func (e expiration) String() string {
+	s := e.Time.Format("Jan 2 15:04:05 2006 MST")
+	if e.Bucket != "" {
+		s += " (" + e.Bucket + ")"
+	}
+	return s
+}
+
+// ExpiresIn returns the time remaining until expiration, which is negative
+// once it has passed.
+func (e expiration) ExpiresIn() time.Duration {
+	return e.Time.Sub(time.Now())
+}
+
+// MarshalJSON encodes the expiration as an RFC 3339 timestamp alongside the
+// whole number of days remaining until it and its bucket.
+func (e expiration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		ExpiresAt     string `json:"expires_at"`
+		DaysRemaining int    `json:"days_remaining"`
+		Bucket        string `json:"bucket,omitempty"`
+	}{
+		ExpiresAt:     e.Time.Format(time.RFC3339),
+		DaysRemaining: int(e.ExpiresIn() / (24 * time.Hour)),
+		Bucket:        e.Bucket,
+	})
+}
+
+// ExpiryBucket classes certificate chains expiring within a given time.
+type ExpiryBucket struct {
+	Label  string
+	Within time.Duration
+	Grade  Grade
+}
+
+// defaultExpiryBuckets classify certificate chains for the CertExpiration
+// scanner by the time left before they expire, from the shortest Within to
+// the longest.
+var defaultExpiryBuckets = []ExpiryBucket{
+	{Label: "critical", Within: 7 * 24 * time.Hour, Grade: Bad},
+	{Label: "warning", Within: 30 * 24 * time.Hour, Grade: Warning},
+	{Label: "notice", Within: 90 * 24 * time.Hour, Grade: Notice},
+}
+
+// NewCertExpirationScanner returns a CertExpiration scanner classifying
+// chains by buckets rather than the defaults, to replace the one in PKI. The
+// buckets may be given in any order. It fails if a bucket has no label, a
+// Within that isn't positive or a grade outside Bad to Good, or if two
+// buckets have the same Within.
+func NewCertExpirationScanner(buckets ...ExpiryBucket) (*Scanner, error) {
+	sorted := append([]ExpiryBucket{}, buckets...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Within < sorted[j].Within })
+	for i, bucket := range sorted {
+		switch {
+		case bucket.Label == "":
+			return nil, errors.New("expiry bucket has no label")
+		case bucket.Within <= 0:
+			return nil, fmt.Errorf("expiry bucket %s isn't within a positive time", bucket.Label)
+		case bucket.Grade < Bad || bucket.Grade > Good:
+			return nil, fmt.Errorf("expiry bucket %s has invalid grade %s", bucket.Label, bucket.Grade)
+		case i > 0 && bucket.Within == sorted[i-1].Within:
+			return nil, fmt.Errorf("expiry buckets %s and %s are within the same time", sorted[i-1].Label, bucket.Label)
+		}
+	}
+	return certExpirationScanner(sorted), nil
+}
+
+// certExpirationScanner returns a CertExpiration scanner classifying chains
+// by buckets, which are sorted from the shortest Within to the longest.
+func certExpirationScanner(buckets []ExpiryBucket) *Scanner {
+	return &Scanner{
+		Description: "Host's certificate chain is not expired or about to expire",
+		Category:    "Certificate",
+		Remediation: "Renew the expiring certificates in the chain, ideally automating renewal well ahead of expiry.",
+		Reference:   "https://tools.ietf.org/html/rfc5280#section-4.1.2.5",
+		scanState: func(host string, state *tls.ConnectionState) (Grade, Output, error) {
+			return certExpiration(host, state, buckets)
+		},
+	}
+}
+
+// certExpiration tests that the host's certificate chain isn't expired, and
+// grades it by the first of buckets it expires within. A chain expiring
+// within none is labeled "good" and graded Good. Expired chains are labeled
+// "expired" and graded Bad.
+func certExpiration(host string, state *tls.ConnectionState, buckets []ExpiryBucket) (grade Grade, output Output, err error) {
+	certs := state.PeerCertificates
+	expiresAt := *helpers.ExpiryTime(certs)
+	e := expiration{Time: expiresAt}
+	ScanLogger.Debugf("scan: certificate chain of %s expires at %s", host, e)
+
+	if remaining := e.ExpiresIn(); remaining < 0 {
+		e.Bucket, grade = "expired", Bad
+	} else {
+		e.Bucket, grade = "good", Good
+		for _, bucket := range buckets {
+			if remaining < bucket.Within {
+				e.Bucket, grade = bucket.Label, bucket.Grade
+				break
+			}
+		}
+	}
+	output = e
+	return
+}
+
+// RenewalLeadTime is how long before its certificate expires a host is
+// expected to have rotated it, which may be longer than the window in which
+// CertExpiration begins to warn.
+var RenewalLeadTime = 14 * 24 * time.Hour
+
+// renewalWindow compares the time left on a certificate with RenewalLeadTime.
+type renewalWindow struct {
+	Remaining time.Duration
+	LeadTime  time.Duration
+}
+
+func (w renewalWindow) String() string {
+	return fmt.Sprintf("%.1f days remaining, renewal lead time %.1f days",
+		w.Remaining.Hours()/24, w.LeadTime.Hours()/24)
+}
+
+// MarshalJSON encodes the durations as strings, such as "336h0m0s".
+func (w renewalWindow) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]string{
+		"remaining": w.Remaining.String(),
+		"lead_time": w.LeadTime.String(),
+	})
+}
+
+// renewalWindowScan tests that the host's leaf certificate has at least
+// RenewalLeadTime remaining, which it wouldn't if a scheduled rotation had
+// been missed.
+func renewalWindowScan(host string, state *tls.ConnectionState) (grade Grade, output Output, err error) {
+	window := renewalWindow{
+		Remaining: state.PeerCertificates[0].NotAfter.Sub(time.Now()),
+		LeadTime:  RenewalLeadTime,
+	}
+	output = window
+	if window.Remaining < window.LeadTime {
+		grade = Warning
+		return
+	}
+	grade = Good
+	return
+}
+
+// MaxCertLifetime is the longest lifetime browsers accept for a leaf
+// certificate.
+var MaxCertLifetime = 398 * 24 * time.Hour
+
+// certLifetime is the validity period of a certificate.
+type certLifetime time.Duration
+
+func (l certLifetime) String() string {
+	return fmt.Sprintf("%.1f days", time.Duration(l).Hours()/24)
+}
+
+// MarshalJSON encodes the lifetime as a string, such as "9552h0m0s".
+func (l certLifetime) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(l).String())
+}
+
+// lifetimeEdgeScan flags leaf certificates issued for within a day of
+// MaxCertLifetime as Notice: they are valid today, but leave no room as
+// browsers shorten the maximum, and renewals issued the same way will start
+// to be rejected. Lifetimes beyond MaxCertLifetime are Bad.
+func lifetimeEdgeScan(host string, state *tls.ConnectionState) (grade Grade, output Output, err error) {
+	leaf := state.PeerCertificates[0]
+	// Validity periods include their last second.
+	lifetime := leaf.NotAfter.Sub(leaf.NotBefore) + time.Second
+	output = certLifetime(lifetime)
+	switch {
+	case lifetime > MaxCertLifetime+time.Second:
+		grade = Bad
+	case lifetime > MaxCertLifetime-24*time.Hour:
+		grade = Notice
+	default:
+		grade = Good
+	}
+	return
+}
+
+// AllowedKeyAlgorithms are the public key algorithms leaf certificates may
+// use. Ed25519 isn't allowed by default, since few TLS clients support it.
+var AllowedKeyAlgorithms = map[x509.PublicKeyAlgorithm]bool{
+	x509.RSA:   true,
+	x509.ECDSA: true,
+}
+
+// keyAlgorithm is the public key algorithm of a certificate.
+type keyAlgorithm x509.PublicKeyAlgorithm
+
+func (alg keyAlgorithm) String() string {
+	return x509.PublicKeyAlgorithm(alg).String()
+}
+
+// MarshalJSON encodes the algorithm as its name.
+func (alg keyAlgorithm) MarshalJSON() ([]byte, error) {
+	return json.Marshal(alg.String())
+}
+
+// keyAlgorithmScan tests that the host's leaf certificate key uses one of the
+// AllowedKeyAlgorithms.
+func keyAlgorithmScan(host string, state *tls.ConnectionState) (grade Grade, output Output, err error) {
+	alg := state.PeerCertificates[0].PublicKeyAlgorithm
+	output = keyAlgorithm(alg)
+	if !AllowedKeyAlgorithms[alg] {
+		grade = Warning
+		return
+	}
+	grade = Good
+	return
+}
+
+// standardRSAExponent is the RSA public exponent nearly all keys use.
+const standardRSAExponent = 65537
+
+// rsaExponent is the public exponent of an RSA key.
+type rsaExponent int
+
+func (e rsaExponent) String() string {
+	return fmt.Sprintf("public exponent %d", int(e))
+}
+
+// rsaExponentScan tests that the host's leaf certificate RSA key uses the
+// standard public exponent. Small exponents such as 3 make padding and
+// implementation flaws exploitable, and unusual ones are a sign of a key
+// generated by nonstandard, possibly flawed, tools. Hosts with other kinds of
+// keys are Skipped.
+func rsaExponentScan(host string, state *tls.ConnectionState) (grade Grade, output Output, err error) {
+	pub, ok := state.PeerCertificates[0].PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return Skipped, nil, nil
+	}
+	output = rsaExponent(pub.E)
+	if pub.E != standardRSAExponent {
+		grade = Warning
+		return
+	}
+	grade = Good
+	return
+}
+
+// suiteKeyExchange returns the key exchange of a TLS 1.2 or earlier cipher
+// suite as named in it, such as "ECDHE_RSA" for
+// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, or "" if the suite is unknown.
+func suiteKeyExchange(suite uint16) string {
+	name := strings.TrimPrefix(tls.CipherSuites[suite].String(), "TLS_")
+	if i := strings.Index(name, "_WITH_"); i > 0 {
+		return name[:i]
+	}
+	return ""
+}
+
+// keyUsageCipher compares the keyUsage of a certificate with the key exchange
+// of the cipher suite negotiated for it.
+type keyUsageCipher struct {
+	CipherSuite string `json:"cipher_suite"`
+	KeyExchange string `json:"key_exchange"`
+	Missing     string `json:"missing,omitempty"`
+}
+
+func (k keyUsageCipher) String() string {
+	if k.Missing != "" {
+		return fmt.Sprintf("%s uses %s key exchange, which needs the %s keyUsage the certificate lacks", k.CipherSuite, k.KeyExchange, k.Missing)
+	}
+	return fmt.Sprintf("%s uses %s key exchange, which the certificate's keyUsage permits", k.CipherSuite, k.KeyExchange)
+}
+
+// keyUsageCipherScan tests that the keyUsage of the host's leaf certificate
+// permits the key exchange of the negotiated cipher suite: keyEncipherment
+// for static RSA, where the client encrypts the premaster secret to the key,
+// and digitalSignature for ECDHE and DHE, where the server signs its key
+// share. Strict clients reject the mismatch. Certificates without the
+// keyUsage extension may be used for either and are Skipped, as are suites
+// whose key exchange isn't recognized.
+func keyUsageCipherScan(host string, state *tls.ConnectionState) (grade Grade, output Output, err error) {
+	usage := state.PeerCertificates[0].KeyUsage
+	if usage == 0 {
+		return Skipped, nil, nil
+	}
+
+	check := keyUsageCipher{CipherSuite: tls.CipherSuites[state.CipherSuite].String()}
+	switch kx := suiteKeyExchange(state.CipherSuite); {
+	case state.Version == versionTLS13:
+		check.KeyExchange = "(EC)DHE"
+		if usage&x509.KeyUsageDigitalSignature == 0 {
+			check.Missing = "digitalSignature"
+		}
+	case kx == "RSA":
+		check.KeyExchange = kx
+		if usage&x509.KeyUsageKeyEncipherment == 0 {
+			check.Missing = "keyEncipherment"
+		}
+	case strings.HasPrefix(kx, "ECDHE_") || strings.HasPrefix(kx, "DHE_"):
+		check.KeyExchange = kx
+		if usage&x509.KeyUsageDigitalSignature == 0 {
+			check.Missing = "digitalSignature"
+		}
+	default:
+		return Skipped, nil, nil
+	}
+
+	output = check
+	if check.Missing != "" {
+		grade = Warning
+		return
+	}
+	grade = Good
+	return
+}
+
+// certAlgorithms records the key and signature algorithms of a certificate.
+type certAlgorithms struct {
+	Certificate string       `json:"certificate"`
+	Key         keyAlgorithm `json:"key"`
+	Signature   string       `json:"signature"`
+}
+
+// chainAlgorithms lists the algorithms of each certificate in a chain.
+type chainAlgorithms []certAlgorithms
+
+func (chain chainAlgorithms) String() string {
+	lines := make([]string, len(chain))
+	for i, c := range chain {
+		lines[i] = fmt.Sprintf("%s: %s key, signed with %s", c.Certificate, c.Key, c.Signature)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// chainAlgorithmsScan reports the key and signature algorithms of each
+// certificate the host presents. A chain mixing key algorithms, such as an
+// ECDSA leaf beneath an RSA intermediate, is valid but mishandled by some old
+// clients, so it is graded Warning.
+func chainAlgorithmsScan(host string, state *tls.ConnectionState) (grade Grade, output Output, err error) {
+	chain := make(chainAlgorithms, len(state.PeerCertificates))
+	grade = Good
+	for i, cert := range state.PeerCertificates {
+		chain[i] = certAlgorithms{
+			Certificate: certName(cert),
+			Key:         keyAlgorithm(cert.PublicKeyAlgorithm),
+			Signature:   cert.SignatureAlgorithm.String(),
+		}
+		if chain[i].Key != chain[0].Key {
+			grade = Warning
+		}
+	}
+	return grade, chain, nil
+}
+
+// weakCAKeyBits is the size of RSA keys at or below which a CA key is flagged.
+// Forging a signature with a weak CA key compromises every certificate
+// beneath it, so CA keys are held to a stricter standard than leaf keys.
+var weakCAKeyBits = 1024
+
+// caKeySizeScan tests that none of the CA certificates presented by the host
+// has an RSA key of weakCAKeyBits or fewer, listing those that do. Hosts
+// presenting no CA certificates are Skipped.
+func caKeySizeScan(host string, state *tls.ConnectionState) (grade Grade, output Output, err error) {
+	cas := state.PeerCertificates[1:]
+	if len(cas) == 0 {
+		return Skipped, nil, nil
+	}
+	var weak issueList
+	for _, ca := range cas {
+		if pub, ok := ca.PublicKey.(*rsa.PublicKey); ok && pub.N.BitLen() <= weakCAKeyBits {
+			weak = append(weak, fmt.Sprintf("%s has a %d bit RSA key", certName(ca), pub.N.BitLen()))
+		}
+	}
+	if len(weak) > 0 {
+		return Bad, weak, nil
+	}
+	return Good, nil, nil
+}
+
+// ecdsaCurve is the name of the curve of an ECDSA key.
+type ecdsaCurve string
+
+func (c ecdsaCurve) String() string {
+	return string(c)
+}
+
+// ecdsaCurveGrade grades a curve by its name: standard NIST curves of at
+// least 256 bits are Good, smaller ones such as P-224 are rejected by some
+// clients, and other curves by most of them.
+func ecdsaCurveGrade(curve elliptic.Curve) Grade {
+	switch curve.Params().Name {
+	case "P-256", "P-384", "P-521":
+		return Good
+	case "P-224":
+		return Warning
+	default:
+		return Bad
+	}
+}
+
+// ecdsaCurveScan tests that an ECDSA leaf certificate key uses a curve its
+// clients trust. Hosts with other key types are Skipped.
+func ecdsaCurveScan(host string, state *tls.ConnectionState) (grade Grade, output Output, err error) {
+	pub, ok := state.PeerCertificates[0].PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return Skipped, nil, nil
+	}
+	return ecdsaCurveGrade(pub.Curve), ecdsaCurve(pub.Curve.Params().Name), nil
+}
+
+// backendCert describes the certificate served from one of a host's
+// addresses, identified by the hex-encoded SHA-256 hash of its leaf.
+type backendCert struct {
+	Address     string    `json:"address"`
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	NotAfter    time.Time `json:"not_after,omitempty"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// backendCerts lists the certificates served from each of a host's addresses.
+type backendCerts []backendCert
+
+func (certs backendCerts) String() string {
+	lines := make([]string, len(certs))
+	for i, c := range certs {
+		if c.Error != "" {
+			lines[i] = c.Address + ": " + c.Error
+		} else {
+			lines[i] = fmt.Sprintf("%s: %s (expires %s)", c.Address, c.Fingerprint, c.NotAfter.Format("2006-01-02"))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// backendCertsScan connects to each address the host's name resolves to,
+// and tests that they all serve the same leaf certificate. A backend still
+// serving a certificate that was renewed elsewhere gives its clients one that
+// expires sooner than expected, so differing certificates are graded Warning.
+// Hosts with fewer than two addresses, or fewer than two backends completing
+// a handshake, are Skipped, the latter with each backend's failure as output.
+func backendCertsScan(host string) (grade Grade, output Output, err error) {
+	hostname, port, err := net.SplitHostPort(host)
+	if err != nil {
+		return
+	}
+	if net.ParseIP(hostname) != nil {
+		return Skipped, nil, nil
+	}
+	addrs, err := lookupHost(hostname)
+	if err != nil {
+		return
+	}
+	if len(addrs) < 2 {
+		return Skipped, nil, nil
+	}
+
+	certs := make(backendCerts, len(addrs))
+	fingerprints := make(map[string]bool)
+	answered := 0
+	for i, addr := range addrs {
+		certs[i].Address = addr
+		conn, dialErr := tlsDial(net.JoinHostPort(addr, port), defaultTLSConfig(host))
+		if dialErr != nil {
+			certs[i].Error = dialErr.Error()
+			continue
+		}
+		state := conn.ConnectionState()
+		conn.Close()
+		if len(state.PeerCertificates) == 0 {
+			certs[i].Error = errNoCertificates.Error()
+			continue
+		}
+		leaf := state.PeerCertificates[0]
+		sum := sha256.Sum256(leaf.Raw)
+		certs[i].Fingerprint = hex.EncodeToString(sum[:])
+		certs[i].NotAfter = leaf.NotAfter
+		fingerprints[certs[i].Fingerprint] = true
+		answered++
+	}
+
+	output = certs
+	if answered < 2 {
+		// There is nothing to compare, but the failures are worth reporting.
+		grade = Skipped
+		return
+	}
+	if len(fingerprints) > 1 {
+		grade = Warning
+		return
+	}
+	grade = Good
+	return
+}
+
+// spkiHash is the hex-encoded SHA-256 hash of a certificate's SubjectPublicKeyInfo.
+type spkiHash string
+
+func (h spkiHash) String() string {
+	return string(h)
+}
+
+func certSPKIHash(cert *x509.Certificate) spkiHash {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return spkiHash(hex.EncodeToString(sum[:]))
+}
+
+// compromisedKeyScan tests that the host's certificate key isn't among CompromisedKeys.
+func compromisedKeyScan(host string, state *tls.ConnectionState) (grade Grade, output Output, err error) {
+	certs := state.PeerCertificates
+	hash := certSPKIHash(certs[0])
+	output = hash
+	if CompromisedKeys[string(hash)] {
+		grade = Bad
+		return
+	}
+	grade = Good
+	return
+}
+
+// revocationInfo lists the revocation mechanisms advertised by a certificate.
+type revocationInfo struct {
+	OCSPServers           []string `json:"ocsp_servers,omitempty"`
+	CRLDistributionPoints []string `json:"crl_distribution_points,omitempty"`
+}
+
+func (r revocationInfo) String() string {
+	var mechanisms []string
+	if len(r.OCSPServers) > 0 {
+		mechanisms = append(mechanisms, "OCSP: "+strings.Join(r.OCSPServers, ", "))
+	}
+	if len(r.CRLDistributionPoints) > 0 {
+		mechanisms = append(mechanisms, "CRL: "+strings.Join(r.CRLDistributionPoints, ", "))
+	}
+	if len(mechanisms) == 0 {
+		return "no OCSP responder or CRL distribution point advertised"
+	}
+	return strings.Join(mechanisms, "\n")
+}
+
+// revocationInfoScan tests that the host's certificate advertises at least one
+// way for clients to check whether it has been revoked.
+func revocationInfoScan(host string, state *tls.ConnectionState) (grade Grade, output Output, err error) {
+	certs := state.PeerCertificates
+	info := revocationInfo{
+		OCSPServers:           certs[0].OCSPServer,
+		CRLDistributionPoints: certs[0].CRLDistributionPoints,
+	}
+	output = info
+	if len(info.OCSPServers) == 0 && len(info.CRLDistributionPoints) == 0 {
+		grade = Warning
+		return
+	}
+	grade = Good
+	return
+}
+
+var (
+	// crlFetchTimeout bounds each download of a CRL.
+	crlFetchTimeout = 10 * time.Second
+	// maxCRLSize bounds the size of a downloaded CRL. Larger CRLs are treated
+	// as unreachable, as many clients will give up on them too.
+	maxCRLSize int64 = 10 << 20
+)
+
+// fetchCRL downloads up to maxCRLSize bytes of the CRL at url.
+func fetchCRL(url string) ([]byte, error) {
+	client := &http.Client{Timeout: crlFetchTimeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", url, resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxCRLSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxCRLSize {
+		return nil, fmt.Errorf("CRL exceeds %d bytes", maxCRLSize)
+	}
+	return data, nil
+}
+
+// crlFreshness describes the CRL served at a distribution point.
+type crlFreshness struct {
+	URL        string     `json:"url"`
+	ThisUpdate *time.Time `json:"this_update,omitempty"`
+	NextUpdate *time.Time `json:"next_update,omitempty"`
+	Problem    string     `json:"problem,omitempty"`
+}
+
+type crlFreshnesses []crlFreshness
+
+func (crls crlFreshnesses) String() string {
+	lines := make([]string, len(crls))
+	for i, crl := range crls {
+		var details []string
+		if crl.ThisUpdate != nil {
+			details = append(details, "this update "+crl.ThisUpdate.Format(time.RFC3339))
+		}
+		if crl.NextUpdate != nil {
+			details = append(details, "next update "+crl.NextUpdate.Format(time.RFC3339))
+		}
+		if crl.Problem != "" {
+			details = append(details, crl.Problem)
+		}
+		lines[i] = crl.URL + ": " + strings.Join(details, ", ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// crlFreshnessScan downloads the CRL from each HTTP distribution point of the
+// host's certificate, and tests that it parses, is signed by the certificate's
+// issuer when the host presents it, and has a nextUpdate in the future. CRLs
+// that can't be downloaded within crlFetchTimeout, exceed maxCRLSize or fail
+// any of these checks are graded Warning. Unlike a revocation check, the
+// certificate's own status isn't considered. Hosts whose certificates give no
+// HTTP distribution point are Skipped.
+func crlFreshnessScan(host string, state *tls.ConnectionState) (grade Grade, output Output, err error) {
+	certs := state.PeerCertificates
+	var crls crlFreshnesses
+	for _, url := range certs[0].CRLDistributionPoints {
+		if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+			continue
+		}
+		freshness := crlFreshness{URL: url}
+		data, fetchErr := fetchCRL(url)
+		if fetchErr != nil {
+			freshness.Problem = "unreachable: " + fetchErr.Error()
+			crls = append(crls, freshness)
+			continue
+		}
+		if block, _ := pem.Decode(data); block != nil && block.Type == "X509 CRL" {
+			data = block.Bytes
+		}
+		crl, parseErr := x509.ParseRevocationList(data)
+		if parseErr != nil {
+			freshness.Problem = "unparseable: " + parseErr.Error()
+			crls = append(crls, freshness)
+			continue
+		}
+
+		freshness.ThisUpdate = &crl.ThisUpdate
+		if !crl.NextUpdate.IsZero() {
+			freshness.NextUpdate = &crl.NextUpdate
+		}
+		switch {
+		case len(certs) > 1 && crl.CheckSignatureFrom(certs[1]) != nil:
+			freshness.Problem = "not signed by " + certName(certs[1])
+		case freshness.NextUpdate == nil:
+			freshness.Problem = "no nextUpdate"
+		case time.Now().After(crl.NextUpdate):
+			freshness.Problem = "stale"
+		}
+		crls = append(crls, freshness)
+	}
+	if len(crls) == 0 {
+		return Skipped, nil, nil
+	}
+
+	output = crls
+	for _, crl := range crls {
+		if crl.Problem != "" {
+			grade = Warning
+			return
+		}
+	}
+	grade = Good
+	return
+}
+
+// ocspStatuses names the certificate statuses of OCSP responses.
+var ocspStatuses = map[int]string{
+	ocsp.Good:    "good",
+	ocsp.Revoked: "revoked",
+	ocsp.Unknown: "unknown",
+}
+
+// ocspStaple describes the OCSP response stapled by a host.
+type ocspStaple struct {
+	Status     string     `json:"status"`
+	ThisUpdate time.Time  `json:"this_update"`
+	NextUpdate *time.Time `json:"next_update,omitempty"`
+}
+
+func (s ocspStaple) String() string {
+	next := "no nextUpdate"
+	if s.NextUpdate != nil {
+		next = "next update " + s.NextUpdate.Format(time.RFC3339)
+	}
+	return fmt.Sprintf("status %s, this update %s, %s", s.Status, s.ThisUpdate.Format(time.RFC3339), next)
+}
+
+// ocspStapleScan tests that the OCSP response stapled by the host has a
+// nextUpdate. Without one, the responder promises newer information is always
+// available, so clients can't tell when a cached response has gone stale.
+// Hosts that don't staple a response are Skipped.
+func ocspStapleScan(host string, state *tls.ConnectionState) (grade Grade, output Output, err error) {
+	if len(state.OCSPResponse) == 0 {
+		return Skipped, nil, nil
+	}
+	resp, err := ocsp.ParseResponse(state.OCSPResponse, nil)
+	if err != nil {
+		return
+	}
+
+	staple := ocspStaple{Status: ocspStatuses[resp.Status], ThisUpdate: resp.ThisUpdate}
+	if !resp.NextUpdate.IsZero() {
+		staple.NextUpdate = &resp.NextUpdate
+	}
+	output = staple
+	if staple.NextUpdate == nil {
+		grade = Warning
+		return
+	}
+	grade = Good
+	return
+}
+
+// ocspCertStatus is the certificate status reported by an OCSP response.
+type ocspCertStatus struct {
+	Status    string     `json:"status"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+func (s ocspCertStatus) String() string {
+	if s.RevokedAt != nil {
+		return "status " + s.Status + " at " + s.RevokedAt.Format(time.RFC3339)
+	}
+	return "status " + s.Status
+}
+
+// ocspStapleMismatch describes a stapled OCSP response that isn't for the
+// host's certificate.
+type ocspStapleMismatch string
+
+func (m ocspStapleMismatch) String() string {
+	return string(m)
+}
+
+// errOCSPNoMatch is the error ocsp.ParseResponseForCert returns for a response
+// about a certificate other than the one given.
+var errOCSPNoMatch = ocsp.ParseError("no response matching the supplied certificate")
+
+// ocspStatusScan tests that the OCSP response stapled by the host is for its
+// certificate, signed by the certificate's issuer, and explicitly reports it
+// as good. Revocation checks commonly accept an unknown status, which only
+// means the responder doesn't know the certificate, so for high assurance it
+// is graded Warning, while revoked is Bad, as is a response about another
+// certificate. Hosts that don't staple a response, or don't present the
+// issuer of a certificate that isn't self-signed, are Skipped.
+func ocspStatusScan(host string, state *tls.ConnectionState) (grade Grade, output Output, err error) {
+	if len(state.OCSPResponse) == 0 {
+		return Skipped, nil, nil
+	}
+	leaf := state.PeerCertificates[0]
+	issuer := leaf
+	if len(state.PeerCertificates) > 1 {
+		issuer = state.PeerCertificates[1]
+	} else if !isSelfSigned(leaf) {
+		return Skipped, nil, nil
+	}
+	resp, err := ocsp.ParseResponseForCert(state.OCSPResponse, leaf, issuer)
+	if err == errOCSPNoMatch {
+		return Bad, ocspStapleMismatch("response isn't for certificate " + certName(leaf)), nil
+	}
+	if err != nil {
+		return
+	}
+
+	status := ocspCertStatus{Status: ocspStatuses[resp.Status]}
+	if resp.Status == ocsp.Revoked {
+		status.RevokedAt = &resp.RevokedAt
+	}
+	output = status
+	switch resp.Status {
+	case ocsp.Good:
+		grade = Good
+	case ocsp.Revoked:
+		grade = Bad
+	default:
+		grade = Warning
+	}
+	return
+}
+
+var (
+	// OCSPStapleMinTTL is the shortest validity interval, from thisUpdate to
+	// nextUpdate, expected of a stapled OCSP response. Shorter ones have the
+	// host refetching responses constantly.
+	OCSPStapleMinTTL = 8 * time.Hour
+	// OCSPStapleMaxTTL is the longest validity interval expected of a stapled
+	// OCSP response, beyond which a revocation goes unnoticed for too long.
+	OCSPStapleMaxTTL = 10 * 24 * time.Hour
+)
+
+// ocspStapleTTL is the validity interval of a stapled OCSP response.
+type ocspStapleTTL time.Duration
+
+func (ttl ocspStapleTTL) String() string {
+	return time.Duration(ttl).String()
+}
+
+// MarshalJSON encodes the interval in seconds.
+func (ttl ocspStapleTTL) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(ttl).Seconds())
+}
+
+// ocspStapleTTLScan tests that the validity interval of the OCSP response
+// stapled by the host is between OCSPStapleMinTTL and OCSPStapleMaxTTL, and
+// grades it Warning otherwise. Hosts that don't staple a response, or staple
+// one without a nextUpdate, are Skipped.
+func ocspStapleTTLScan(host string, state *tls.ConnectionState) (grade Grade, output Output, err error) {
+	if len(state.OCSPResponse) == 0 {
+		return Skipped, nil, nil
+	}
+	resp, err := ocsp.ParseResponse(state.OCSPResponse, nil)
+	if err != nil {
+		return
+	}
+	if resp.NextUpdate.IsZero() {
+		return Skipped, nil, nil
+	}
+
+	ttl := resp.NextUpdate.Sub(resp.ThisUpdate)
+	output = ocspStapleTTL(ttl)
+	if ttl < OCSPStapleMinTTL || ttl > OCSPStapleMaxTTL {
+		grade = Warning
+		return
+	}
+	grade = Good
+	return
+}
+
+// ocspResponder describes who signed the OCSP response stapled by a host, and
+// why they aren't authorized to if they aren't.
+type ocspResponder struct {
+	Responder string   `json:"responder"`
+	Delegated bool     `json:"delegated"`
+	Problems  []string `json:"problems,omitempty"`
+}
+
+func (r ocspResponder) String() string {
+	signer := "issuer"
+	if r.Delegated {
+		signer = "delegated responder"
+	}
+	if len(r.Problems) > 0 {
+		return fmt.Sprintf("%s %s isn't authorized: %s", signer, r.Responder, strings.Join(r.Problems, "; "))
+	}
+	return fmt.Sprintf("%s %s is authorized", signer, r.Responder)
+}
+
+// ocspResponderScan tests that the OCSP response stapled by the host is signed
+// by the leaf's issuer, or by a delegated responder whose certificate the
+// issuer signed with the OCSP signing extended key usage. Clients reject
+// responses from anyone else. Hosts that don't staple a response or present
+// the leaf's issuer are Skipped.
+func ocspResponderScan(host string, state *tls.ConnectionState) (grade Grade, output Output, err error) {
+	if len(state.OCSPResponse) == 0 || len(state.PeerCertificates) < 2 {
+		return Skipped, nil, nil
+	}
+	issuer := state.PeerCertificates[1]
+	resp, err := ocsp.ParseResponse(state.OCSPResponse, nil)
+	if err != nil {
+		return
+	}
+
+	responder := ocspResponder{Responder: certName(issuer)}
+	if resp.Certificate == nil {
+		if _, verifyErr := ocsp.ParseResponse(state.OCSPResponse, issuer); verifyErr != nil {
+			responder.Problems = append(responder.Problems, "response isn't signed by the issuer")
+		}
+	} else {
+		responder.Responder = certName(resp.Certificate)
+		responder.Delegated = true
+		if resp.Certificate.CheckSignatureFrom(issuer) != nil {
+			responder.Problems = append(responder.Problems, "certificate isn't signed by issuer "+certName(issuer))
+		}
+		var ocspSigning bool
+		for _, usage := range resp.Certificate.ExtKeyUsage {
+			ocspSigning = ocspSigning || usage == x509.ExtKeyUsageOCSPSigning
+		}
+		if !ocspSigning {
+			responder.Problems = append(responder.Problems, "certificate lacks the OCSP signing extended key usage")
+		}
+	}
+
+	output = responder
+	if len(responder.Problems) > 0 {
+		grade = Bad
+		return
+	}
+	grade = Good
+	return
+}
+
+// issueList is a list of problems found with a host's configuration.
+type issueList []string
+
+func (issues issueList) String() string {
+	return strings.Join(issues, "\n")
+}
+
+// certName returns a short human readable name for cert.
+func certName(cert *x509.Certificate) string {
+	if cert.Subject.CommonName != "" {
+		return cert.Subject.CommonName
+	}
+	return "serial " + cert.SerialNumber.String()
+}
+
+// isSelfSigned reports whether cert is signed by its own key, as a root is.
+// Unlike CheckSignatureFrom, the signature is checked whether or not cert is
+// a CA, so that self-signed leaves are recognized too.
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawSubject, cert.RawIssuer) &&
+		cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) == nil
+}
+
+// selfSigned reports whether a leaf certificate is signed by its own key.
+type selfSigned struct {
+	Subject    string `json:"subject"`
+	SelfSigned bool   `json:"self_signed"`
+}
+
+func (s selfSigned) String() string {
+	if s.SelfSigned {
+		return s.Subject + " is self-signed"
+	}
+	return s.Subject + " is issued by a CA"
+}
+
+// selfSignedScan tests that the host's leaf certificate isn't self-signed,
+// as development and internal certificates exposed by mistake often are.
+func selfSignedScan(host string, state *tls.ConnectionState) (grade Grade, output Output, err error) {
+	leaf := state.PeerCertificates[0]
+	result := selfSigned{Subject: leaf.Subject.String(), SelfSigned: isSelfSigned(leaf)}
+	output = result
+	if result.SelfSigned {
+		grade = Warning
+		return
+	}
+	grade = Good
+	return
+}
+
+// keyIdentifierScan tests that the leaf of the host's certificate chain has a
+// Subject Key Identifier and that each non-root certificate has an Authority
+// Key Identifier, which clients use to find the issuer of each certificate.
+func keyIdentifierScan(host string, state *tls.ConnectionState) (grade Grade, output Output, err error) {
+	certs := state.PeerCertificates
+
+	var issues issueList
+	if len(certs[0].SubjectKeyId) == 0 {
+		issues = append(issues, certName(certs[0])+": missing SubjectKeyId")
+	}
+	for _, cert := range certs {
+		if len(cert.AuthorityKeyId) == 0 && !isSelfSigned(cert) {
+			issues = append(issues, certName(cert)+": missing AuthorityKeyId")
+		}
+	}
+
+	output = issues
+	if len(issues) > 0 {
+		grade = Warning
+		return
+	}
+	grade = Good
+	return
+}
+
+// skiDerivation is the Subject Key Identifier of a certificate, hashes of its
+// public key that it may have been computed from, and the method it matches.
+type skiDerivation struct {
+	SKI    string `json:"ski"`
+	SHA1   string `json:"sha1"`
+	SHA256 string `json:"sha256"`
+	Method string `json:"method"`
+}
+
+func (d skiDerivation) String() string {
+	return fmt.Sprintf("SKI %s derived by %s (SHA-1 %s, SHA-256 %s)", d.SKI, d.Method, d.SHA1, d.SHA256)
+}
+
+// skiMethodSHA1 is the conventional Subject Key Identifier derivation.
+const skiMethodSHA1 = "SHA-1 of the public key"
+
+// skiMethod names the method that derived ski from the subjectPublicKey bits
+// of a certificate, among those of RFC 5280 and RFC 7093.
+func skiMethod(ski, sha1Sum, sha256Sum []byte) string {
+	method2 := append([]byte{}, sha1Sum[12:]...)
+	method2[0] = 0x40 | method2[0]&0x0f
+	switch {
+	case bytes.Equal(ski, sha1Sum):
+		return skiMethodSHA1
+	case bytes.Equal(ski, method2):
+		return "truncated SHA-1 of the public key"
+	case bytes.Equal(ski, sha256Sum[:20]):
+		return "truncated SHA-256 of the public key"
+	case bytes.Equal(ski, sha256Sum):
+		return "SHA-256 of the public key"
+	}
+	return "an unknown method"
+}
+
+// skiDerivationScan reports how the Subject Key Identifier of the host's leaf
+// certificate appears to have been derived, grading Warning any derivation
+// other than the conventional SHA-1 hash of the subjectPublicKey bits, which
+// may indicate non-standard issuance. Certificates without a Subject Key
+// Identifier are Skipped.
+func skiDerivationScan(host string, state *tls.ConnectionState) (grade Grade, output Output, err error) {
+	leaf := state.PeerCertificates[0]
+	if len(leaf.SubjectKeyId) == 0 {
+		return Skipped, nil, nil
+	}
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err = asn1.Unmarshal(leaf.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return
+	}
+	sha1Sum := sha1.Sum(spki.PublicKey.Bytes)
+	sha256Sum := sha256.Sum256(spki.PublicKey.Bytes)
+	derivation := skiDerivation{
+		SKI:    hex.EncodeToString(leaf.SubjectKeyId),
+		SHA1:   hex.EncodeToString(sha1Sum[:]),
+		SHA256: hex.EncodeToString(sha256Sum[:]),
+		Method: skiMethod(leaf.SubjectKeyId, sha1Sum[:], sha256Sum[:]),
+	}
+	output = derivation
+	if derivation.Method != skiMethodSHA1 {
+		grade = Warning
+		return
+	}
+	grade = Good
+	return
+}
+
+// chainValidityScan tests that the validity window of each certificate in the
+// host's chain contains that of the certificate it issued. An issuer expiring
+// first breaks the chain before its child expires, and is graded Bad; one
+// becoming valid after its child is graded Warning.
+func chainValidityScan(host string, state *tls.ConnectionState) (grade Grade, output Output, err error) {
+	certs := state.PeerCertificates
+
+	grade = Good
+	var issues issueList
+	for i := 0; i+1 < len(certs); i++ {
+		child, parent := certs[i], certs[i+1]
+		if parent.NotAfter.Before(child.NotAfter) {
+			issues = append(issues, fmt.Sprintf("%s expires at %s, before %s it issued expires at %s",
+				certName(parent), parent.NotAfter.Format(time.RFC3339), certName(child), child.NotAfter.Format(time.RFC3339)))
+			grade = Bad
+		}
+		if parent.NotBefore.After(child.NotBefore) {
+			issues = append(issues, fmt.Sprintf("%s becomes valid at %s, after %s it issued at %s",
+				certName(parent), parent.NotBefore.Format(time.RFC3339), certName(child), child.NotBefore.Format(time.RFC3339)))
+			if grade > Warning {
+				grade = Warning
+			}
+		}
+	}
+	output = issues
+	return
+}
+
+// expiredRootScan tests that no self-signed certificate the host includes
+// after its leaf has expired. Clients only need a root in their own store,
+// but some of them fail to build a chain through an included root once it
+// has expired, even when their own copy is still trusted. Hosts that include
+// no root are Skipped.
+func expiredRootScan(host string, state *tls.ConnectionState) (grade Grade, output Output, err error) {
+	now := time.Now()
+	included := false
+	var expired issueList
+	for _, cert := range state.PeerCertificates[1:] {
+		if !isSelfSigned(cert) {
+			continue
+		}
+		included = true
+		if now.After(cert.NotAfter) {
+			expired = append(expired, fmt.Sprintf("%s expired at %s", cert.Subject, cert.NotAfter.Format(time.RFC3339)))
+		}
+	}
+	switch {
+	case !included:
+		return Skipped, nil, nil
+	case len(expired) > 0:
+		return Warning, expired, nil
+	}
+	return Good, nil, nil
+}
+
+// oidCommonName is the attribute type of a common name.
+var oidCommonName = asn1.ObjectIdentifier{2, 5, 4, 3}
+
+// subjectName is the subject DN of a certificate, with any problems found in it.
+type subjectName struct {
+	Subject string    `json:"subject"`
+	Issues  issueList `json:"issues,omitempty"`
+}
+
+func (s subjectName) String() string {
+	subject := s.Subject
+	if subject == "" {
+		subject = "empty subject"
+	}
+	if len(s.Issues) > 0 {
+		subject += "\n" + s.Issues.String()
+	}
+	return subject
+}
+
+// hasSANs reports whether cert has any subject alternative names.
+func hasSANs(cert *x509.Certificate) bool {
+	return len(cert.DNSNames) > 0 || len(cert.IPAddresses) > 0 || len(cert.EmailAddresses) > 0 || len(cert.URIs) > 0
+}
+
+// subjectNameScan tests that the host's leaf certificate identifies its
+// subject. A certificate with neither a subject DN nor subject alternative
+// names identifies nothing, and is graded Bad. A subject DN with multi-valued
+// RDNs, several common names or empty values is graded Warning, since
+// clients disagree on how to interpret it.
+func subjectNameScan(host string, state *tls.ConnectionState) (grade Grade, output Output, err error) {
+	leaf := state.PeerCertificates[0]
+	var rdns pkix.RDNSequence
+	if rest, err := asn1.Unmarshal(leaf.RawSubject, &rdns); err != nil || len(rest) > 0 {
+		return Bad, subjectName{Issues: issueList{"malformed subject DN"}}, nil
+	}
+	name := subjectName{Subject: rdns.String()}
+	if len(rdns) == 0 {
+		output = name
+		if !hasSANs(leaf) {
+			grade = Bad
+			return
+		}
+		grade = Good
+		return
+	}
+
+	commonNames := 0
+	for _, rdn := range rdns {
+		if len(rdn) > 1 {
+			name.Issues = append(name.Issues, "multi-valued RDN "+pkix.RDNSequence{rdn}.String())
+		}
+		for _, atv := range rdn {
+			if atv.Type.Equal(oidCommonName) {
+				commonNames++
+			}
+			if value, ok := atv.Value.(string); ok && value == "" {
+				name.Issues = append(name.Issues, "empty value for attribute "+atv.Type.String())
+			}
+		}
+	}
+	if commonNames > 1 {
+		name.Issues = append(name.Issues, fmt.Sprintf("%d common names", commonNames))
+	}
+
+	output = name
+	if len(name.Issues) > 0 {
+		grade = Warning
+		return
+	}
+	grade = Good
+	return
+}
+
+// commonNameCoverage describes whether a certificate names the host only in
+// its common name.
+type commonNameCoverage struct {
+	CommonName string `json:"common_name"`
+	// SANs is whether the certificate has DNS or IP subject alternative
+	// names, the only ones clients match hosts against.
+	SANs bool `json:"sans"`
+}
+
+func (c commonNameCoverage) String() string {
+	if c.SANs {
+		return "certificate has DNS or IP subject alternative names"
+	}
+	return fmt.Sprintf("host named only by CN=%s in a certificate without DNS or IP subject alternative names", c.CommonName)
+}
+
+// matchesCommonName reports whether hostname matches the common name cn,
+// which may be a wildcard covering a single leftmost label.
+func matchesCommonName(cn, hostname string) bool {
+	cn, hostname = strings.TrimSuffix(cn, "."), strings.TrimSuffix(hostname, ".")
+	if strings.HasPrefix(cn, "*.") {
+		i := strings.Index(hostname, ".")
+		return i > 0 && strings.EqualFold(cn[1:], hostname[i:])
+	}
+	return strings.EqualFold(cn, hostname)
+}
+
+// commonNameOnlyScan tests that the host's leaf certificate doesn't name the
+// host in its common name alone. Modern clients ignore the common name and
+// reject certificates without DNS or IP subject alternative names, whatever
+// email or URI names they have, so such legacy certificates are graded Bad.
+// Certificates whose common name doesn't match the host are Skipped, since
+// they don't rely on it.
+func commonNameOnlyScan(host string, state *tls.ConnectionState) (grade Grade, output Output, err error) {
+	hostname, _, err := net.SplitHostPort(host)
+	if err != nil {
+		return
+	}
+	leaf := state.PeerCertificates[0]
+	coverage := commonNameCoverage{
+		CommonName: leaf.Subject.CommonName,
+		SANs:       len(leaf.DNSNames)+len(leaf.IPAddresses) > 0,
+	}
+	if coverage.SANs {
+		return Good, coverage, nil
+	}
+	if !matchesCommonName(coverage.CommonName, hostname) {
+		return Skipped, nil, nil
+	}
+	return Bad, coverage, nil
+}
+
+// idnComparison compares a host name with a certificate's names after IDNA normalization.
+type idnComparison struct {
+	Host      string    `json:"host"`
+	HostASCII string    `json:"host_ascii"`
+	Matches   bool      `json:"matches"`
+	Issues    issueList `json:"issues,omitempty"`
+}
+
+func (c idnComparison) String() string {
+	covered := "is covered by the certificate"
+	if !c.Matches {
+		covered = "is not covered by the certificate"
+	}
+	s := fmt.Sprintf("%s normalizes to %s, which %s", c.Host, c.HostASCII, covered)
+	if len(c.Issues) > 0 {
+		s += "\n" + c.Issues.String()
+	}
+	return s
+}
+
+// isIDN reports whether name contains a U-label or an A-label.
+func isIDN(name string) bool {
+	for _, r := range name {
+		if r >= utf8.RuneSelf {
+			return true
+		}
+	}
+	return strings.Contains(strings.ToLower(name), "xn--")
+}
+
+// checkIDN compares hostname with the names in leaf after converting both to
+// A-labels, noting any of leaf's names that aren't properly encoded A-labels.
+// Unless hostname or one of leaf's names is internationalized, IDN encoding
+// doesn't apply and the comparison is Skipped.
+func checkIDN(hostname string, leaf *x509.Certificate) (grade Grade, comparison idnComparison) {
+	comparison.Host, comparison.HostASCII = hostname, hostname
+	idn := isIDN(hostname)
+	for _, name := range leaf.DNSNames {
+		label := strings.TrimPrefix(name, "*.")
+		if !isIDN(label) {
+			continue
+		}
+		idn = true
+		if ascii, err := idna.Lookup.ToASCII(label); err != nil {
+			comparison.Issues = append(comparison.Issues, fmt.Sprintf("%s: invalid IDN: %v", name, err))
+		} else if ascii != label {
+			comparison.Issues = append(comparison.Issues, fmt.Sprintf("%s: not in A-label form (%s)", name, ascii))
+		}
+	}
+
+	if !idn {
+		return Skipped, comparison
+	}
+
+	var err error
+	if comparison.HostASCII, err = idna.Lookup.ToASCII(hostname); err != nil {
+		comparison.HostASCII = hostname
+		if isIDN(hostname) {
+			comparison.Issues = append(comparison.Issues, fmt.Sprintf("%s: invalid IDN: %v", hostname, err))
+		}
+	}
+	comparison.Matches = leaf.VerifyHostname(comparison.HostASCII) == nil
+
+	if !comparison.Matches || len(comparison.Issues) > 0 {
+		grade = Warning
+		return
+	}
+	grade = Good
+	return
+}
+
+// idnScan tests that the host's certificate names are properly encoded and
+// match the host name once it is normalized to A-labels. Hosts whose name and
+// certificate names are all plain ASCII are Skipped.
+func idnScan(host string, state *tls.ConnectionState) (grade Grade, output Output, err error) {
+	hostname, _, err := net.SplitHostPort(host)
+	if err != nil {
+		return
+	}
+	grade, comparison := checkIDN(hostname, state.PeerCertificates[0])
+	if grade != Skipped {
+		output = comparison
+	}
+	return
+}
+
+// domainList is a list of domain names.
+type domainList []string
+
+func (domains domainList) String() string {
+	return strings.Join(domains, "\n")
+}
+
+// registrableDomains returns the distinct registrable domains, one label
+// beneath a public suffix, covered by names.
+func registrableDomains(names []string) domainList {
+	seen := make(map[string]bool)
+	var domains domainList
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimPrefix(name, "*."))
+		domain, err := publicsuffix.EffectiveTLDPlusOne(name)
+		if err != nil {
+			// Names such as "localhost" are their own domain.
+			domain = name
+		}
+		if !seen[domain] {
+			seen[domain] = true
+			domains = append(domains, domain)
+		}
+	}
+	sort.Strings(domains)
+	return domains
+}
+
+// registrableDomainScan tests that the host's certificate names don't span
+// more than MaxRegistrableDomains registrable domains, which would indicate a
+// certificate shared between unrelated sites.
+func registrableDomainScan(host string, state *tls.ConnectionState) (grade Grade, output Output, err error) {
+	domains := registrableDomains(state.PeerCertificates[0].DNSNames)
+	output = domains
+	if len(domains) > MaxRegistrableDomains {
+		grade = Warning
+		return
+	}
+	grade = Good
+	return
+}
+
+// apexCoverage lists which of the apex and www names of a domain a
+// certificate covers.
+type apexCoverage struct {
+	Covered []string `json:"covered"`
+	Gaps    []string `json:"gaps,omitempty"`
+}
+
+func (c apexCoverage) String() string {
+	coverage := "covers " + strings.Join(c.Covered, ", ")
+	if len(c.Covered) == 0 {
+		coverage = "covers neither name"
+	}
+	if len(c.Gaps) > 0 {
+		coverage += "; missing " + strings.Join(c.Gaps, ", ")
+	}
+	return coverage
+}
+
+// apexCoverageScan tests that the host's certificate covers both the apex
+// domain and its www variant when the host is one of them, so that visitors
+// using the other name don't hit a certificate error. A certificate for
+// "*.example.com" alone covers "www.example.com" but not "example.com". Hosts
+// that are IP addresses or other subdomains are Skipped.
+func apexCoverageScan(host string, state *tls.ConnectionState) (grade Grade, output Output, err error) {
+	hostname, _, err := net.SplitHostPort(host)
+	if err != nil {
+		return
+	}
+	hostname = strings.ToLower(strings.TrimSuffix(hostname, "."))
+	apex, suffixErr := publicsuffix.EffectiveTLDPlusOne(hostname)
+	if net.ParseIP(hostname) != nil || suffixErr != nil || hostname != apex && hostname != "www."+apex {
+		return Skipped, nil, nil
+	}
+
+	var coverage apexCoverage
+	for _, name := range []string{apex, "www." + apex} {
+		if state.PeerCertificates[0].VerifyHostname(name) == nil {
+			coverage.Covered = append(coverage.Covered, name)
+		} else {
+			coverage.Gaps = append(coverage.Gaps, name)
+		}
+	}
+	output = coverage
+	if len(coverage.Gaps) > 0 {
+		grade = Warning
+		return
+	}
+	grade = Good
+	return
+}
+
+// MaxChainSize is the total size in bytes of the DER encoded certificates
+// presented by a host beyond which its chain is flagged.
+var MaxChainSize = 5120
+
+// certSize is the DER encoded size of a certificate.
+type certSize struct {
+	Name string `json:"name"`
+	Size int    `json:"size"`
+}
+
+// chainSize breaks down the size of a certificate chain.
+type chainSize struct {
+	Total        int        `json:"total"`
+	Certificates []certSize `json:"certificates"`
+}
+
+func (c chainSize) String() string {
+	lines := []string{fmt.Sprintf("%d bytes in total", c.Total)}
+	for _, cert := range c.Certificates {
+		lines = append(lines, fmt.Sprintf("%s: %d bytes", cert.Name, cert.Size))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// chainSizeScan tests that the certificates presented by the host total no
+// more than MaxChainSize bytes, since large chains take extra round trips to
+// deliver, especially over mobile networks.
+func chainSizeScan(host string, state *tls.ConnectionState) (grade Grade, output Output, err error) {
+	var size chainSize
+	for _, cert := range state.PeerCertificates {
+		size.Total += len(cert.Raw)
+		size.Certificates = append(size.Certificates, certSize{Name: certName(cert), Size: len(cert.Raw)})
+	}
+	output = size
+	if size.Total > MaxChainSize {
+		grade = Warning
+		return
+	}
+	grade = Good
+	return
+}
+
+// tlsaRecords lists TLSA records, one per line.
+type tlsaRecords []TLSARecord
+
+func (records tlsaRecords) String() string {
+	lines := make([]string, len(records))
+	for i, record := range records {
+		lines[i] = record.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// tlsaMatches reports whether the TLSA record matches one of the certificates
+// presented by a host. Records with usages, selectors or matching types not
+// defined by RFC 6698 never match.
+func tlsaMatches(record TLSARecord, certs []*x509.Certificate) bool {
+	var candidates []*x509.Certificate
+	switch record.Usage {
+	case 0, 2:
+		// CA constraints and trust anchor assertions name an issuer.
+		candidates = certs[1:]
+	case 1, 3:
+		// Service certificate constraints and domain-issued certificates
+		// name the leaf.
+		candidates = certs[:1]
+	}
+
+	for _, cert := range candidates {
+		var data []byte
+		switch record.Selector {
+		case 0:
+			data = cert.Raw
+		case 1:
+			data = cert.RawSubjectPublicKeyInfo
+		default:
+			return false
+		}
+
+		switch record.MatchingType {
+		case 0:
+		case 1:
+			sum := sha256.Sum256(data)
+			data = sum[:]
+		case 2:
+			sum := sha512.Sum512(data)
+			data = sum[:]
+		default:
+			return false
+		}
+		if bytes.Equal(data, record.Data) {
+			return true
+		}
+	}
+	return false
+}
+
+// unauthenticatedTLSA is the output of DANE scans of hosts whose TLSA records
+// weren't authenticated with DNSSEC.
+type unauthenticatedTLSA struct{}
+
+func (unauthenticatedTLSA) String() string {
+	return "TLSA records aren't authenticated with DNSSEC"
+}
+
+// MarshalJSON encodes the output as its description.
+func (u unauthenticatedTLSA) MarshalJSON() ([]byte, error) {
+	return json.Marshal(u.String())
+}
+
+// daneScan tests that the certificates presented by the host match one of
+// the TLSA records published for it. Records with PKIX usages 0 and 1 only
+// match a chain that also verifies against verifyRoots, and a usage 0 record
+// must name a CA in the verified chain. DANE is only meaningful when the
+// records are authenticated, so only those the resolver validated with
+// DNSSEC are considered. Hosts without authenticated TLSA records are
+// Skipped.
+func daneScan(host string, state *tls.ConnectionState) (grade Grade, output Output, err error) {
+	hostname, port, err := net.SplitHostPort(host)
+	if err != nil {
+		return
+	}
+	if net.ParseIP(hostname) != nil {
+		// TLSA records are only published for names.
+		return Skipped, nil, nil
+	}
+	published, err := DNSResolver.LookupTLSA("_" + port + "._tcp." + hostname)
+	if err != nil {
+		return
+	}
+	if len(published) == 0 {
+		return Skipped, nil, nil
+	}
+	var records []TLSARecord
+	for _, record := range published {
+		if record.Authenticated {
+			records = append(records, record)
+		}
+	}
+	if len(records) == 0 {
+		return Skipped, unauthenticatedTLSA{}, nil
+	}
+
+	var chains [][]*x509.Certificate
+	var verified bool
+	for _, record := range records {
+		switch record.Usage {
+		case 0, 1:
+			if !verified {
+				intermediates := x509.NewCertPool()
+				for _, cert := range state.PeerCertificates[1:] {
+					intermediates.AddCert(cert)
+				}
+				chains, _ = state.PeerCertificates[0].Verify(verifyOptions(hostname, intermediates))
+				verified = true
+			}
+			for _, chain := range chains {
+				if tlsaMatches(record, chain) {
+					return Good, tlsaRecords{record}, nil
+				}
+			}
+		default:
+			if tlsaMatches(record, state.PeerCertificates) {
+				return Good, tlsaRecords{record}, nil
+			}
+		}
+	}
+	return Bad, tlsaRecords(records), nil
+}
+
+// NewRequiredNamesScanner returns a scanner verifying that the host's leaf
+// certificate covers each of names through its subject alternative names,
+// wildcards included, and grading it Bad otherwise with the missing names as
+// output. It is meant to be added to a family, or passed to ScanConn, to check
+// the deployment of a particular service.
+func NewRequiredNamesScanner(names ...string) *Scanner {
+	required := append([]string{}, names...)
+	return &Scanner{
+		Description: "Host's certificate covers every required name",
+		Category:    "Certificate",
+		Remediation: "Reissue the certificate with each missing name as a subject alternative name.",
+		scanState: func(host string, state *tls.ConnectionState) (grade Grade, output Output, err error) {
+			if missing := uncoveredNames(state.PeerCertificates[0], required); len(missing) > 0 {
+				return Bad, missing, nil
+			}
+			return Good, nil, nil
+		},
+	}
+}
+
+// NewServiceNamesScanner returns a scanner verifying that the single leaf
+// certificate the host presents is valid for the host it was reached at as
+// well as for each of names, grading it Bad otherwise with the failing names
+// as output. It checks deployments where one endpoint serves several
+// hostnames, which clients will only accept if one certificate covers them
+// all.
+func NewServiceNamesScanner(names ...string) *Scanner {
+	expected := append([]string{}, names...)
+	return &Scanner{
+		Description: "Host's certificate is valid for every hostname the service serves",
+		Category:    "Certificate",
+		Remediation: "Reissue the certificate with every hostname the service serves as a subject alternative name, or serve each hostname its own certificate through SNI.",
+		scanState: func(host string, state *tls.ConnectionState) (grade Grade, output Output, err error) {
+			hostname, _, err := net.SplitHostPort(host)
+			if err != nil {
+				return
+			}
+			names := append([]string{hostname}, expected...)
+			if failing := uncoveredNames(state.PeerCertificates[0], names); len(failing) > 0 {
+				return Bad, failing, nil
+			}
+			return Good, nil, nil
+		},
+	}
+}
+
+// uncoveredNames returns those of names for which leaf doesn't validate.
+func uncoveredNames(leaf *x509.Certificate, names []string) (uncovered domainList) {
+	for _, name := range names {
+		if leaf.VerifyHostname(name) != nil {
+			uncovered = append(uncovered, name)
+		}
+	}
+	return
+}
+
+// danglingSANScan tests that every DNS name in the host's leaf certificate
+// resolves. A name that no longer resolves may be claimed by someone else,
+// who could then serve it with the certificate's blessing. Wildcard names are
+// ignored, since they can't be resolved.
+func danglingSANScan(host string, state *tls.ConnectionState) (grade Grade, output Output, err error) {
+	var dangling domainList
+	for _, name := range state.PeerCertificates[0].DNSNames {
+		if strings.HasPrefix(name, "*.") {
+			continue
+		}
+		addrs, lookupErr := lookupHost(name)
+		if dnsErr, ok := lookupErr.(*net.DNSError); ok && dnsErr.IsNotFound {
+			lookupErr = nil
+		}
+		if lookupErr != nil {
+			err = lookupErr
+			return
+		}
+		if len(addrs) == 0 {
+			dangling = append(dangling, name)
+		}
+	}
+
+	output = dangling
+	if len(dangling) > 0 {
+		grade = Warning
+		return
+	}
+	grade = Good
+	return
+}
+
+// certRecordMatch reports whether the leaf matched the CERT records published for a host.
+type certRecordMatch struct {
+	Records int  `json:"records"`
+	Matches bool `json:"matches"`
+}
+
+func (m certRecordMatch) String() string {
+	if m.Matches {
+		return fmt.Sprintf("certificate matches one of %d CERT records", m.Records)
+	}
+	return fmt.Sprintf("certificate matches none of %d CERT records", m.Records)
+}
+
+// dnsCertScan compares the host's leaf certificate with the X.509
+// certificates published in DNS CERT records for its name. Hosts without
+// such records are Skipped.
+func dnsCertScan(host string, state *tls.ConnectionState) (grade Grade, output Output, err error) {
+	hostname, _, err := net.SplitHostPort(host)
+	if err != nil {
+		return
+	}
+	if net.ParseIP(hostname) != nil {
+		// CERT records are only published for names.
+		return Skipped, nil, nil
+	}
+	records, err := DNSResolver.LookupCERT(hostname)
+	if err != nil {
+		return
+	}
+
+	var match certRecordMatch
+	for _, record := range records {
+		if record.Type != certTypePKIX {
+			continue
+		}
+		match.Records++
+		if bytes.Equal(record.Data, state.PeerCertificates[0].Raw) {
+			match.Matches = true
+		}
+	}
+	if match.Records == 0 {
+		return Skipped, nil, nil
+	}
+	output = match
+	if !match.Matches {
+		grade = Warning
+		return
+	}
+	grade = Good
+	return
+}
+
+// verifyRoots are the roots chains are verified against, or nil for the
+// system roots.
+var verifyRoots *x509.CertPool
+
+// chainCert identifies a certificate of a chain.
+type chainCert struct {
+	Subject string `json:"subject"`
+	Issuer  string `json:"issuer"`
+	Serial  string `json:"serial"`
+}
+
+// certChain lists the certificates of a chain, from leaf towards the root.
+type certChain []chainCert
+
+func newCertChain(certs []*x509.Certificate) certChain {
+	chain := make(certChain, len(certs))
+	for i, cert := range certs {
+		chain[i] = chainCert{
+			Subject: cert.Subject.String(),
+			Issuer:  cert.Issuer.String(),
+			Serial:  cert.SerialNumber.String(),
+		}
+	}
+	return chain
+}
+
+func (chain certChain) String() string {
+	subjects := make([]string, len(chain))
+	for i, cert := range chain {
+		subjects[i] = cert.Subject
+	}
+	return strings.Join(subjects, " -> ")
+}
+
+// partialChain follows the certificates presented by a host from the leaf
+// through each one's issuer, for as long as the issuer was presented.
+func partialChain(certs []*x509.Certificate) []*x509.Certificate {
+	chain := []*x509.Certificate{certs[0]}
+	for {
+		child := chain[len(chain)-1]
+		if isSelfSigned(child) {
+			return chain
+		}
+		var parent *x509.Certificate
+		for _, cert := range certs[1:] {
+			if child.CheckSignatureFrom(cert) == nil {
+				parent = cert
+				break
+			}
+		}
+		if parent == nil || len(chain) == len(certs) {
+			return chain
+		}
+		chain = append(chain, parent)
+	}
+}
+
+// verifyOptions returns the options to verify a certificate for hostname
+// against verifyRoots, through intermediates.
+func verifyOptions(hostname string, intermediates *x509.CertPool) x509.VerifyOptions {
+	return x509.VerifyOptions{
+		DNSName:       hostname,
+		Intermediates: intermediates,
+		Roots:         verifyRoots,
+	}
+}
+
+// chainVerificationScan verifies the host's leaf certificate the way a browser
+// does: every other certificate it presents is an unordered candidate
+// intermediate, from which any path to a trusted root is accepted. The chain
+// verified is output, or when verification fails, as much of the chain as
+// could be built from the certificates presented.
+func chainVerificationScan(host string, state *tls.ConnectionState) (grade Grade, output Output, err error) {
+	hostname, _, err := net.SplitHostPort(host)
+	if err != nil {
+		return
+	}
+
+	certs := state.PeerCertificates
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	chains, err := certs[0].Verify(verifyOptions(hostname, intermediates))
+	if err != nil {
+		output = newCertChain(partialChain(certs))
+		return
+	}
+	return Good, newCertChain(chains[0]), nil
+}
+
+var (
+	// aiaFetchTimeout bounds each fetch of an issuer certificate from the
+	// URL in a certificate's Authority Information Access extension.
+	aiaFetchTimeout = 5 * time.Second
+	// maxAIAFetches bounds the issuer certificates fetched to complete a chain.
+	maxAIAFetches = 5
+	// maxAIACertSize bounds the size of a fetched issuer certificate.
+	maxAIACertSize int64 = 64 << 10
+)
+
+// fetchAIA fetches the data served at the http or https URL rawURL within
+// aiaFetchTimeout, along with its content type. Data larger than
+// maxAIACertSize is an error.
+func fetchAIA(rawURL string) (data []byte, contentType string, err error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		err = fmt.Errorf("fetching %s: unsupported scheme %q", rawURL, u.Scheme)
+		return
+	}
+	client := &http.Client{Timeout: aiaFetchTimeout}
+	resp, err := client.Get(rawURL)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("fetching %s: %s", rawURL, resp.Status)
+		return
+	}
+	if data, err = ioutil.ReadAll(io.LimitReader(resp.Body, maxAIACertSize+1)); err != nil {
+		return
+	}
+	if int64(len(data)) > maxAIACertSize {
+		return nil, "", fmt.Errorf("fetching %s: response exceeds %d bytes", rawURL, maxAIACertSize)
+	}
+	return data, resp.Header.Get("Content-Type"), nil
+}
+
+// fetchIssuer fetches the DER or PEM encoded certificate at url.
+func fetchIssuer(url string) (*x509.Certificate, error) {
+	data, _, err := fetchAIA(url)
+	if err != nil {
+		return nil, err
+	}
+	if cert, err := x509.ParseCertificate(data); err == nil {
+		return cert, nil
+	}
+	return helpers.ParseCertificatePEM(data)
+}
+
+// aiaCertContentType is the content type of a single DER certificate served
+// at a caIssuers URL.
+const aiaCertContentType = "application/pkix-cert"
+
+// aiaServing describes what a caIssuers URL served.
+type aiaServing struct {
+	URL         string `json:"url"`
+	ContentType string `json:"content_type,omitempty"`
+	Served      string `json:"served"`
+}
+
+// aiaServings lists what each caIssuers URL of a host's chain served.
+type aiaServings []aiaServing
+
+func (servings aiaServings) String() string {
+	lines := make([]string, len(servings))
+	for i, s := range servings {
+		lines[i] = s.URL + ": " + s.Served
+		if s.ContentType != "" {
+			lines[i] += " as " + s.ContentType
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// compliant reports whether s is a single DER certificate served with
+// aiaCertContentType.
+func (s aiaServing) compliant() bool {
+	mediaType, _, err := mime.ParseMediaType(s.ContentType)
+	return s.Served == "DER certificate" && err == nil && mediaType == aiaCertContentType
+}
+
+// describeAIA names the format of data served at a caIssuers URL.
+func describeAIA(data []byte) string {
+	if _, err := x509.ParseCertificate(data); err == nil {
+		return "DER certificate"
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		return "PEM " + block.Type
+	}
+	if _, err := pkcs7.ParsePKCS7(data); err == nil {
+		return "PKCS#7"
+	}
+	return "unrecognized data"
+}
+
+// aiaFormatScan fetches each HTTP caIssuers URL in the Authority Information
+// Access extensions of the host's chain, and tests that it serves a single
+// DER certificate with the application/pkix-cert content type. Clients that
+// fetch issuers may not understand PEM or PKCS#7. Servings that don't comply,
+// or can't be fetched within aiaFetchTimeout, are graded Warning. Hosts whose
+// chains give no such URLs are Skipped.
+func aiaFormatScan(host string, state *tls.ConnectionState) (grade Grade, output Output, err error) {
+	var servings aiaServings
+	seen := make(map[string]bool)
+	for _, cert := range state.PeerCertificates {
+		for _, url := range cert.IssuingCertificateURL {
+			if seen[url] || !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+				continue
+			}
+			seen[url] = true
+
+			serving := aiaServing{URL: url}
+			data, contentType, fetchErr := fetchAIA(url)
+			if fetchErr != nil {
+				serving.Served = "nothing (" + fetchErr.Error() + ")"
+			} else {
+				serving.ContentType, serving.Served = contentType, describeAIA(data)
+			}
+			servings = append(servings, serving)
+		}
+	}
+	if len(servings) == 0 {
+		return Skipped, nil, nil
+	}
+
+	output = servings
+	for _, serving := range servings {
+		if !serving.compliant() {
+			grade = Warning
+			return
+		}
+	}
+	grade = Good
+	return
+}
+
+// completedChain is the chain built for a host, with the URLs of any
+// intermediates that had to be fetched to build it.
+type completedChain struct {
+	Chain       certChain `json:"chain"`
+	FetchedFrom []string  `json:"fetched_from,omitempty"`
+}
+
+func (c completedChain) String() string {
+	if len(c.FetchedFrom) == 0 {
+		return c.Chain.String()
+	}
+	return c.Chain.String() + "\nfetched " + strings.Join(c.FetchedFrom, ", ")
+}
+
+// chainCompletionScan builds a verified chain for the host's leaf from the
+// certificates it presents, fetching missing issuers from the URLs in their
+// Authority Information Access extensions as some clients do. A chain that
+// verifies only with fetched intermediates is graded Warning, since clients
+// that don't fetch them will fail to verify it.
+func chainCompletionScan(host string, state *tls.ConnectionState) (grade Grade, output Output, err error) {
+	hostname, _, err := net.SplitHostPort(host)
+	if err != nil {
+		return
+	}
+
+	certs := state.PeerCertificates
+	intermediates := x509.NewCertPool()
+	seen := make(map[string]bool)
+	for _, cert := range certs {
+		intermediates.AddCert(cert)
+		seen[string(cert.Raw)] = true
+	}
+	chains, err := certs[0].Verify(verifyOptions(hostname, intermediates))
+	if err == nil {
+		return Good, completedChain{Chain: newCertChain(chains[0])}, nil
+	}
+
+	// Fetch issuers from the top of the chain presented, following AIA from
+	// each fetched certificate in turn. URLs and certificates are fetched at
+	// most once, so that AIA loops end.
+	built := partialChain(certs)
+	current := built[len(built)-1]
+	fetchedURLs := make(map[string]bool)
+	var fetchedFrom []string
+	for len(fetchedFrom) < maxAIAFetches && !isSelfSigned(current) {
+		var issuer *x509.Certificate
+		for _, url := range current.IssuingCertificateURL {
+			if fetchedURLs[url] {
+				continue
+			}
+			fetchedURLs[url] = true
+			cert, fetchErr := fetchIssuer(url)
+			if fetchErr != nil {
+				ScanLogger.Debugf("scan: fetching issuer of %s from %s failed: %v", certName(current), url, fetchErr)
+				continue
+			}
+			if seen[string(cert.Raw)] || current.CheckSignatureFrom(cert) != nil {
+				continue
+			}
+			seen[string(cert.Raw)] = true
+			issuer = cert
+			fetchedFrom = append(fetchedFrom, url)
+			break
+		}
+		if issuer == nil {
+			break
+		}
+		intermediates.AddCert(issuer)
+		built = append(built, issuer)
+		current = issuer
+
+		if chains, err = certs[0].Verify(verifyOptions(hostname, intermediates)); err == nil {
+			return Warning, completedChain{Chain: newCertChain(chains[0]), FetchedFrom: fetchedFrom}, nil
+		}
+	}
+	output = completedChain{Chain: newCertChain(built), FetchedFrom: fetchedFrom}
+	return
+}
+
+// issuerName is the name of the CA that issued a certificate.
+type issuerName string
+
+func (name issuerName) String() string {
+	return string(name)
+}
+
+// newIssuerName returns the common name of the issuer of cert, or its whole
+// DN if it has none.
+func newIssuerName(cert *x509.Certificate) issuerName {
+	if cert.Issuer.CommonName != "" {
+		return issuerName(cert.Issuer.CommonName)
+	}
+	return issuerName(cert.Issuer.String())
+}
+
+// issuerScan reports the issuer of the host's leaf certificate, for
+// comparison across hosts by IssuerOutliers.
+func issuerScan(host string, state *tls.ConnectionState) (grade Grade, output Output, err error) {
+	return Good, newIssuerName(state.PeerCertificates[0]), nil
+}
+
+// IssuerOutliers checks the issuers recorded by the PKI Issuer scanner across
+// a fleet of reports, returning a Warning result for each host whose
+// certificate was issued by a CA not named in approved. Hosts whose issuer
+// wasn't recorded are ignored.
+func IssuerOutliers(reports []HostReport, approved []string) map[string]ScannerResult {
+	allowed := make(map[string]bool)
+	for _, name := range approved {
+		allowed[name] = true
+	}
+
+	outliers := make(map[string]ScannerResult)
+	for _, report := range reports {
+		result, ok := report.Families[pkiFamily][issuerScanner]
+		if !ok || result.Error != nil || result.Output == nil {
+			continue
+		}
+		if issuer := result.Output.String(); !allowed[issuer] {
+			outliers[report.Host] = ScannerResult{Grade: Warning, Output: issuerName(issuer)}
+		}
+	}
+	return outliers
+}
+
+var (
+	// CTSearchURL is a crt.sh-style Certificate Transparency search API,
+	// queried with the host name as the q parameter for certificates covering
+	// it. Issuance isn't checked when it is empty.
+	CTSearchURL string
+	// CTIssuanceWindow is how far back CTIssuanceLimit applies.
+	CTIssuanceWindow = 30 * 24 * time.Hour
+	// CTIssuanceLimit is the number of certificates issued for a name within
+	// CTIssuanceWindow beyond which issuance is unexpectedly high.
+	CTIssuanceLimit = 10
+	// ExpectedIssuers names the CAs, as reported by the Issuer scanner,
+	// expected to issue certificates for hosts. Issuers aren't checked when
+	// it is empty.
+	ExpectedIssuers []string
+	// ctSearchTimeout bounds a query of CTSearchURL.
+	ctSearchTimeout = 10 * time.Second
+)
+
+// ctSearchEntry is a certificate found by a search of CTSearchURL.
+type ctSearchEntry struct {
+	IssuerName   string `json:"issuer_name"`
+	SerialNumber string `json:"serial_number"`
+	NotBefore    string `json:"not_before"`
+}
+
+// ctSearch queries CTSearchURL for the certificates logged for name.
+func ctSearch(name string) ([]ctSearchEntry, error) {
+	search, err := url.Parse(CTSearchURL)
+	if err != nil {
+		return nil, err
+	}
+	query := search.Query()
+	query.Set("q", name)
+	query.Set("output", "json")
+	search.RawQuery = query.Encode()
+
+	client := &http.Client{Timeout: ctSearchTimeout}
+	resp, err := client.Get(search.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("searching %s: %s", CTSearchURL, resp.Status)
+	}
+	var entries []ctSearchEntry
+	if err = json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// ctIssuances summarizes the certificates recently issued for a name.
+type ctIssuances struct {
+	Window     time.Duration  `json:"window"`
+	Total      int            `json:"total"`
+	ByIssuer   map[string]int `json:"by_issuer,omitempty"`
+	Unexpected []string       `json:"unexpected_issuers,omitempty"`
+}
+
+func (i ctIssuances) String() string {
+	issuers := make([]string, 0, len(i.ByIssuer))
+	for issuer, count := range i.ByIssuer {
+		issuers = append(issuers, fmt.Sprintf("%s (%d)", issuer, count))
+	}
+	sort.Strings(issuers)
+	summary := fmt.Sprintf("%d certificates issued in the last %d days", i.Total, int(i.Window.Hours()/24))
+	if len(issuers) > 0 {
+		summary += ": " + strings.Join(issuers, ", ")
+	}
+	if len(i.Unexpected) > 0 {
+		summary += "; unexpected issuers: " + strings.Join(i.Unexpected, ", ")
+	}
+	return summary
+}
+
+// dnCommonName returns the common name in a distinguished name formatted as
+// "C=US, O=Example, CN=Example CA", or the whole name if it has none.
+func dnCommonName(dn string) string {
+	for _, attr := range strings.Split(dn, ", ") {
+		if strings.HasPrefix(attr, "CN=") {
+			return strings.TrimPrefix(attr, "CN=")
+		}
+	}
+	return dn
+}
+
+// ctIssuanceScan searches CTSearchURL for the certificates logged for the
+// host's name, and tests that no more than CTIssuanceLimit were issued within
+// CTIssuanceWindow, all by ExpectedIssuers. A burst of issuance or a CA no one
+// expected can mean mis-issuance, or certificates obtained outside the usual
+// process. Hosts are Skipped when CTSearchURL isn't set or the host is an IP
+// address.
+func ctIssuanceScan(host string) (grade Grade, output Output, err error) {
+	hostname, _, err := net.SplitHostPort(host)
+	if err != nil {
+		return
+	}
+	if CTSearchURL == "" || net.ParseIP(hostname) != nil {
+		return Skipped, nil, nil
+	}
+
+	entries, err := ctSearch(hostname)
+	if err != nil {
+		return
+	}
+
+	expected := make(map[string]bool)
+	for _, name := range ExpectedIssuers {
+		expected[name] = true
+	}
+	issuances := ctIssuances{Window: CTIssuanceWindow, ByIssuer: make(map[string]int)}
+	since := time.Now().Add(-CTIssuanceWindow)
+	// Searches list a precertificate and its certificate separately.
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		notBefore, parseErr := time.Parse("2006-01-02T15:04:05", entry.NotBefore)
+		if parseErr != nil || notBefore.Before(since) || seen[entry.IssuerName+entry.SerialNumber] {
+			continue
+		}
+		seen[entry.IssuerName+entry.SerialNumber] = true
+		issuer := dnCommonName(entry.IssuerName)
+		if issuances.ByIssuer[issuer] == 0 && len(expected) > 0 && !expected[issuer] {
+			issuances.Unexpected = append(issuances.Unexpected, issuer)
+		}
+		issuances.ByIssuer[issuer]++
+		issuances.Total++
+	}
+	sort.Strings(issuances.Unexpected)
+
+	output = issuances
+	if issuances.Total > CTIssuanceLimit || len(issuances.Unexpected) > 0 {
+		grade = Warning
+		return
+	}
+	grade = Good
+	return
+}
+
+// ExpectedRoots are the hex-encoded SHA-256 hashes of the
+// SubjectPublicKeyInfo of the roots that host certificates are expected to
+// chain to. A chain to any other root suggests a TLS-intercepting proxy on
+// the network path. Chains aren't checked when it is empty.
+var ExpectedRoots []string
+
+// chainRoot records the root a host's chain was verified to, or why it
+// couldn't be verified.
+type chainRoot struct {
+	Root  string   `json:"root"`
+	SPKI  spkiHash `json:"spki_sha256,omitempty"`
+	Error string   `json:"error,omitempty"`
+}
+
+func (r chainRoot) String() string {
+	if r.Error != "" {
+		return r.Root + ": " + r.Error
+	}
+	return r.Root
+}
+
+// interceptionScan verifies the chain presented by the host against
+// verifyRoots and tests that it leads to a root whose key is one of
+// ExpectedRoots, reporting the root's name. Enterprise proxies that intercept
+// TLS replace the host's chain with one issued by their own root, so chains
+// verifying only to other roots are graded Warning. Chains that don't verify
+// at all are Skipped with the reason, which other scanners grade.
+func interceptionScan(host string, state *tls.ConnectionState) (grade Grade, output Output, err error) {
+	if len(ExpectedRoots) == 0 {
+		return Skipped, nil, nil
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	// The host's name is checked by other scanners; only the root matters here.
+	chains, verifyErr := state.PeerCertificates[0].Verify(verifyOptions("", intermediates))
+	if verifyErr != nil {
+		top := state.PeerCertificates[len(state.PeerCertificates)-1]
+		output = chainRoot{Root: string(newIssuerName(top)), Error: verifyErr.Error()}
+		grade = Skipped
+		return
+	}
+
+	var root chainRoot
+	for _, chain := range chains {
+		top := chain[len(chain)-1]
+		root = chainRoot{Root: certName(top), SPKI: certSPKIHash(top)}
+		for _, expected := range ExpectedRoots {
+			if string(root.SPKI) == expected {
+				output = root
+				grade = Good
+				return
+			}
+		}
+	}
+	output = root
+	grade = Warning
+	return
+}
+
+// AllowedIssuerCountries are the ISO 3166 country codes of the CAs allowed to
+// issue host certificates, for environments required to use CAs in particular
+// jurisdictions. Issuers aren't checked when it is empty.
+var AllowedIssuerCountries []string
+
+// issuerCountry records the country of the CA that issued a certificate.
+type issuerCountry struct {
+	Country string `json:"country"`
+	Subject string `json:"subject"`
+}
+
+func (c issuerCountry) String() string {
+	country := c.Country
+	if country == "" {
+		country = "no country"
+	}
+	return fmt.Sprintf("%s issued by a CA in %s", c.Subject, country)
+}
+
+// issuerCountryScan tests that the country attribute of the issuer of the
+// host's leaf certificate is one of AllowedIssuerCountries. Issuers without
+// a country are outside every allowed set. Hosts are Skipped when no
+// countries are configured.
+func issuerCountryScan(host string, state *tls.ConnectionState) (grade Grade, output Output, err error) {
+	if len(AllowedIssuerCountries) == 0 {
+		return Skipped, nil, nil
+	}
+	leaf := state.PeerCertificates[0]
+	country := strings.Join(leaf.Issuer.Country, ",")
+	output = issuerCountry{Country: country, Subject: leaf.Subject.String()}
+	for _, allowed := range AllowedIssuerCountries {
+		if len(leaf.Issuer.Country) == 1 && strings.EqualFold(leaf.Issuer.Country[0], allowed) {
+			grade = Good
+			return
+		}
+	}
+	grade = Warning
+	return
+}
+
+var (
+	// oidEmbeddedSCTList identifies the certificate extension carrying SCTs.
+	oidEmbeddedSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+	// oidOCSPSCTList identifies the OCSP single response extension carrying SCTs.
+	oidOCSPSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 5}
+)
+
+// minSCTs is the number of SCTs below which a certificate is flagged.
+var minSCTs = 2
+
+// CTLogOperators maps the base64 encoded IDs of Certificate Transparency logs
+// to the organizations operating them, against which the diversity of the
+// logs that issued a certificate's embedded SCTs is checked.
+var CTLogOperators = map[string]string{}
+
+// QualifiedCTLogs holds the base64 encoded IDs of the Certificate
+// Transparency logs currently qualified by client policies. SCTs from other
+// logs, such as retired or distrusted ones, don't count towards the policies.
+// Logs aren't checked when it is empty.
+var QualifiedCTLogs = map[string]bool{}
+
+// sctLogQualification splits the logs that issued a certificate's SCTs into
+// qualified and non-qualified ones.
+type sctLogQualification struct {
+	Qualified    []string `json:"qualified,omitempty"`
+	NonQualified []string `json:"non_qualified,omitempty"`
+}
+
+func (q sctLogQualification) String() string {
+	list := func(logs []string) string {
+		if len(logs) == 0 {
+			return "none"
+		}
+		return strings.Join(logs, ", ")
+	}
+	return fmt.Sprintf("qualified logs: %s\nretired or unknown logs: %s", list(q.Qualified), list(q.NonQualified))
+}
+
+// qualifiedLogScan tests that at least minSCTs of the SCTs for the host's
+// certificate come from logs in QualifiedCTLogs, grading Warning when SCTs
+// from other logs leave too few that count. Certificates without SCTs, and
+// every certificate when no logs are qualified, are Skipped.
+func qualifiedLogScan(host string, state *tls.ConnectionState) (grade Grade, output Output, err error) {
+	if len(QualifiedCTLogs) == 0 {
+		return Skipped, nil, nil
+	}
+	embedded, tlsExtension, ocspSCTs, _, err := collectSCTs(state)
+	if err != nil {
+		return
+	}
+	scts := append(append(append([][]byte{}, embedded...), tlsExtension...), ocspSCTs...)
+	if len(scts) == 0 {
+		return Skipped, nil, nil
+	}
+
+	var q sctLogQualification
+	for _, sct := range scts {
+		var logID string
+		if logID, err = sctLogID(sct); err != nil {
+			return
+		}
+		if QualifiedCTLogs[logID] {
+			q.Qualified = append(q.Qualified, logID)
+		} else {
+			q.NonQualified = append(q.NonQualified, logID)
+		}
+	}
+	output = q
+	if len(q.Qualified) < minSCTs {
+		grade = Warning
+		return
+	}
+	grade = Good
+	return
+}
+
+// CTEnforcedIssuers names the CAs, as reported by the Issuer scanner, whose
+// certificates clients reject without enough SCTs. No issuer is checked when
+// it is empty.
+var CTEnforcedIssuers []string
+
+// ctIssuerPolicy is the issuer of a certificate, whether it is subject to CT
+// enforcement, and the SCTs provided for the certificate.
+type ctIssuerPolicy struct {
+	Issuer   issuerName `json:"issuer"`
+	Enforced bool       `json:"enforced"`
+	SCTs     int        `json:"scts"`
+}
+
+func (p ctIssuerPolicy) String() string {
+	enforced := "not subject to CT enforcement"
+	if p.Enforced {
+		enforced = "subject to CT enforcement"
+	}
+	return fmt.Sprintf("issuer %s %s, %d SCTs", p.Issuer, enforced, p.SCTs)
+}
+
+// ctIssuerPolicyScan tests that the host provides at least minSCTs SCTs for
+// its certificate if its issuer is one of CTEnforcedIssuers. Certificates
+// from other issuers, and every certificate when no issuer is enforced, are
+// Skipped.
+func ctIssuerPolicyScan(host string, state *tls.ConnectionState) (grade Grade, output Output, err error) {
+	if len(CTEnforcedIssuers) == 0 {
+		return Skipped, nil, nil
+	}
+	embedded, tlsExtension, ocspSCTs, _, err := collectSCTs(state)
+	if err != nil {
+		return
+	}
+	policy := ctIssuerPolicy{
+		Issuer: newIssuerName(state.PeerCertificates[0]),
+		SCTs:   len(embedded) + len(tlsExtension) + len(ocspSCTs),
+	}
+	for _, issuer := range CTEnforcedIssuers {
+		if string(policy.Issuer) == issuer {
+			policy.Enforced = true
+		}
+	}
+
+	output = policy
+	switch {
+	case !policy.Enforced:
+		grade = Skipped
+	case policy.SCTs < minSCTs:
+		grade = Warning
+	default:
+		grade = Good
+	}
+	return
+}
+
+// sctSources counts the SCTs delivered by each of the methods defined by RFC
+// 6962, and lists the operators of the logs behind the embedded SCTs.
+type sctSources struct {
+	Embedded     int      `json:"embedded"`
+	TLSExtension int      `json:"tls_extension"`
+	OCSP         int      `json:"ocsp"`
+	Operators    []string `json:"operators,omitempty"`
+	// OCSPError says why SCTs couldn't be read from a stapled OCSP response.
+	OCSPError string `json:"ocsp_error,omitempty"`
+}
+
+// Total returns the number of SCTs delivered by any method.
+func (s sctSources) Total() int {
+	return s.Embedded + s.TLSExtension + s.OCSP
+}
+
+func (s sctSources) String() string {
+	var methods []string
+	if s.Embedded > 0 {
+		methods = append(methods, fmt.Sprintf("%d embedded in certificate", s.Embedded))
+	}
+	if s.TLSExtension > 0 {
+		methods = append(methods, fmt.Sprintf("%d in TLS extension", s.TLSExtension))
+	}
+	if s.OCSP > 0 {
+		methods = append(methods, fmt.Sprintf("%d in stapled OCSP response", s.OCSP))
+	}
+	description := "no SCTs"
+	if len(methods) > 0 {
+		description = strings.Join(methods, ", ")
+	}
+	if len(s.Operators) > 0 {
+		description += "; log operators: " + strings.Join(s.Operators, ", ")
+	}
+	if s.OCSPError != "" {
+		description += "; stapled OCSP response ignored: " + s.OCSPError
+	}
+	return description
+}
+
+// parseSCTs returns the serialized SCTs in the DER encoded extension value
+// wrapping a SignedCertificateTimestampList.
+func parseSCTs(value []byte) ([][]byte, error) {
+	var list []byte
+	if rest, err := asn1.Unmarshal(value, &list); err != nil {
+		return nil, err
+	} else if len(rest) > 0 {
+		return nil, errors.New("trailing data after SCT list")
+	}
+	if len(list) < 2 || int(list[0])<<8|int(list[1]) != len(list)-2 {
+		return nil, errors.New("malformed SCT list")
+	}
+
+	var scts [][]byte
+	for list = list[2:]; len(list) > 0; {
+		if len(list) < 2 {
+			return nil, errors.New("malformed SCT list")
+		}
+		length := int(list[0])<<8 | int(list[1])
+		if length == 0 || len(list) < 2+length {
+			return nil, errors.New("malformed SCT list")
+		}
+		scts = append(scts, list[2:2+length])
+		list = list[2+length:]
+	}
+	return scts, nil
+}
+
+// sctLogID returns the base64 encoded ID of the log that issued a v1 SCT.
+func sctLogID(sct []byte) (string, error) {
+	if len(sct) < 33 || sct[0] != 0 {
+		return "", errors.New("malformed or unsupported SCT version")
+	}
+	return base64.StdEncoding.EncodeToString(sct[1:33]), nil
+}
+
+// collectSCTs returns the serialized SCTs embedded in the host's leaf
+// certificate, sent in the TLS extension and carried in a stapled OCSP
+// response. A staple that can't be read contributes no SCTs rather than
+// failing the scan, so that the other sources are still graded; why it
+// couldn't be read is returned as ocspErr.
+func collectSCTs(state *tls.ConnectionState) (embedded, tlsExtension, ocspSCTs [][]byte, ocspErr, err error) {
+	for _, ext := range state.PeerCertificates[0].Extensions {
+		if ext.Id.Equal(oidEmbeddedSCTList) {
+			if embedded, err = parseSCTs(ext.Value); err != nil {
+				return
+			}
+		}
+	}
+	tlsExtension = state.SignedCertificateTimestamps
+	ocspSCTs, ocspErr = stapledSCTs(state)
+	return
+}
+
+// stapledSCTs returns the serialized SCTs carried in the OCSP response
+// stapled by the host, if any.
+func stapledSCTs(state *tls.ConnectionState) ([][]byte, error) {
+	if len(state.OCSPResponse) == 0 {
+		return nil, nil
+	}
+	resp, err := ocsp.ParseResponse(state.OCSPResponse, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, ext := range resp.Extensions {
+		if ext.Id.Equal(oidOCSPSCTList) {
+			return parseSCTs(ext.Value)
+		}
+	}
+	return nil, nil
+}
+
+// sctTimestamp returns the time at which a log issued a v1 SCT.
+func sctTimestamp(sct []byte) (time.Time, error) {
+	if len(sct) < 41 || sct[0] != 0 {
+		return time.Time{}, errors.New("malformed or unsupported SCT version")
+	}
+	ms := int64(binary.BigEndian.Uint64(sct[33:41]))
+	return time.Unix(ms/1000, ms%1000*int64(time.Millisecond)), nil
+}
+
+// CTMaxMergeDelay is the Maximum Merge Delay of the Certificate Transparency
+// logs, within which they promise to incorporate the certificates they issue
+// SCTs for.
+var CTMaxMergeDelay = 24 * time.Hour
+
+// ctFreshness compares the time since a certificate's oldest SCT was issued
+// with the Maximum Merge Delay, and records whether the certificate was found
+// in the logs once it is past it.
+type ctFreshness struct {
+	Age    time.Duration `json:"age"`
+	MMD    time.Duration `json:"mmd"`
+	Logged *bool         `json:"logged,omitempty"`
+}
+
+func (f ctFreshness) String() string {
+	if f.Age < f.MMD {
+		return fmt.Sprintf("oldest SCT issued %s ago, within the %s maximum merge delay", f.Age, f.MMD)
+	}
+	status := fmt.Sprintf("oldest SCT issued %s ago, past the %s maximum merge delay", f.Age, f.MMD)
+	if f.Logged == nil {
+		return status
+	}
+	if *f.Logged {
+		return status + ", and the certificate is logged"
+	}
+	return status + ", but the certificate isn't logged"
+}
+
+// ctMergeDelayScan tests that a certificate whose oldest SCT was issued longer
+// than CTMaxMergeDelay ago, so that the log should have incorporated it by
+// now, can be found by a search of CTSearchURL for its first DNS name.
+// Certificates within the MMD are Good, since the logs have until then to
+// incorporate them. Certificates without SCTs, and those past the MMD when
+// CTSearchURL isn't set or they have no DNS name, are Skipped.
+func ctMergeDelayScan(host string, state *tls.ConnectionState) (grade Grade, output Output, err error) {
+	embedded, tlsExtension, ocspSCTs, _, err := collectSCTs(state)
+	if err != nil {
+		return
+	}
+	scts := append(append(append([][]byte{}, embedded...), tlsExtension...), ocspSCTs...)
+	if len(scts) == 0 {
+		return Skipped, nil, nil
+	}
+
+	var oldest time.Time
+	for _, sct := range scts {
+		var issued time.Time
+		if issued, err = sctTimestamp(sct); err != nil {
+			return
+		}
+		if oldest.IsZero() || issued.Before(oldest) {
+			oldest = issued
+		}
+	}
+
+	freshness := ctFreshness{Age: time.Since(oldest).Truncate(time.Second), MMD: CTMaxMergeDelay}
+	output = freshness
+	if freshness.Age < freshness.MMD {
+		grade = Good
+		return
+	}
+	leaf := state.PeerCertificates[0]
+	if CTSearchURL == "" || len(leaf.DNSNames) == 0 {
+		grade = Skipped
+		return
+	}
+	entries, err := ctSearch(leaf.DNSNames[0])
+	if err != nil {
+		return
+	}
+	var logged bool
+	for _, entry := range entries {
+		if serial, ok := new(big.Int).SetString(entry.SerialNumber, 16); ok && serial.Cmp(leaf.SerialNumber) == 0 {
+			logged = true
+			break
+		}
+	}
+	freshness.Logged = &logged
+	output = freshness
+	if !logged {
+		grade = Warning
+		return
+	}
+	grade = Good
+	return
+}
+
+var (
+	// sctClockSkew is how far in the future an SCT may be timestamped before
+	// it is considered anomalous.
+	sctClockSkew = 5 * time.Minute
+	// sctMaxBackdate is how long before a certificate's NotBefore an SCT may
+	// be timestamped, allowing for CAs that backdate their certificates.
+	sctMaxBackdate = 48 * time.Hour
+)
+
+// sctTimestampCheck is the timestamp of an SCT, and any anomaly found in it.
+type sctTimestampCheck struct {
+	Source    string    `json:"source"`
+	Timestamp time.Time `json:"timestamp"`
+	Anomaly   string    `json:"anomaly,omitempty"`
+	grade     Grade
+}
+
+type sctTimestampChecks []sctTimestampCheck
+
+func (checks sctTimestampChecks) String() string {
+	lines := make([]string, len(checks))
+	for i, check := range checks {
+		lines[i] = check.Source + ": " + check.Timestamp.UTC().Format(time.RFC3339)
+		if check.Anomaly != "" {
+			lines[i] += " (" + check.Anomaly + ")"
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// checkSCTTimestamp checks an SCT timestamp against the validity period of the
+// certificate it was issued for.
+func checkSCTTimestamp(source string, issued time.Time, cert *x509.Certificate) sctTimestampCheck {
+	check := sctTimestampCheck{Source: source, Timestamp: issued, grade: Good}
+	switch {
+	case issued.After(time.Now().Add(sctClockSkew)):
+		check.Anomaly, check.grade = "in the future", Bad
+	case issued.After(cert.NotAfter):
+		check.Anomaly, check.grade = "after the certificate expired", Bad
+	case issued.Before(cert.NotBefore.Add(-sctMaxBackdate)):
+		check.Anomaly, check.grade = "long before the certificate became valid", Warning
+	}
+	return check
+}
+
+// sctTimestampScan tests that the timestamp of each SCT for the host's
+// certificate is neither in the future nor inconsistent with the certificate's
+// validity period, either of which indicates tampering or a broken log.
+// Certificates without SCTs are Skipped.
+func sctTimestampScan(host string, state *tls.ConnectionState) (grade Grade, output Output, err error) {
+	embedded, tlsExtension, ocspSCTs, _, err := collectSCTs(state)
+	if err != nil {
+		return
+	}
+	sources := []struct {
+		name string
+		scts [][]byte
+	}{
+		{"embedded", embedded},
+		{"TLS extension", tlsExtension},
+		{"OCSP", ocspSCTs},
+	}
+
+	grade = Good
+	var checks sctTimestampChecks
+	for _, source := range sources {
+		for _, sct := range source.scts {
+			var issued time.Time
+			if issued, err = sctTimestamp(sct); err != nil {
+				return
+			}
+			check := checkSCTTimestamp(source.name, issued, state.PeerCertificates[0])
+			if check.grade < grade {
+				grade = check.grade
+			}
+			checks = append(checks, check)
+		}
+	}
+	if len(checks) == 0 {
+		return Skipped, nil, nil
+	}
+	output = checks
+	return
+}
+
+// BackdateTolerance is how long before the earliest SCT for a certificate its
+// notBefore may be set before the certificate is considered backdated.
+var BackdateTolerance = 48 * time.Hour
+
+// backdating compares a certificate's notBefore with its earliest SCT, which
+// bounds when the certificate was actually issued.
+type backdating struct {
+	NotBefore   time.Time `json:"not_before"`
+	EarliestSCT time.Time `json:"earliest_sct"`
+}
+
+func (b backdating) String() string {
+	return fmt.Sprintf("notBefore %s, earliest SCT %s", b.NotBefore.UTC().Format(time.RFC3339),
+		b.EarliestSCT.UTC().Format(time.RFC3339))
+}
+
+// backdatingScan tests that the notBefore of the host's certificate precedes
+// its earliest SCT by no more than BackdateTolerance. Excessive backdating
+// can hide when a certificate was really issued, for instance to sidestep a
+// deprecation deadline. Certificates without SCTs are Skipped.
+func backdatingScan(host string, state *tls.ConnectionState) (grade Grade, output Output, err error) {
+	embedded, tlsExtension, ocspSCTs, _, err := collectSCTs(state)
+	if err != nil {
+		return
+	}
+	scts := append(append(append([][]byte{}, embedded...), tlsExtension...), ocspSCTs...)
+	if len(scts) == 0 {
+		return Skipped, nil, nil
+	}
+
+	var earliest time.Time
+	for _, sct := range scts {
+		var issued time.Time
+		if issued, err = sctTimestamp(sct); err != nil {
+			return
+		}
+		if earliest.IsZero() || issued.Before(earliest) {
+			earliest = issued
+		}
+	}
+
+	b := backdating{NotBefore: state.PeerCertificates[0].NotBefore, EarliestSCT: earliest}
+	output = b
+	if b.NotBefore.Before(b.EarliestSCT.Add(-BackdateTolerance)) {
+		grade = Warning
+		return
+	}
+	grade = Good
+	return
+}
+
+// CTLifetimeRule requires certificates with lifetimes up to MaxLifetime to
+// embed MinSCTs SCTs. A zero MaxLifetime covers every longer lifetime.
+type CTLifetimeRule struct {
+	MaxLifetime time.Duration `json:"max_lifetime"`
+	MinSCTs     int           `json:"min_scts"`
+}
+
+// CTPolicy is a client's Certificate Transparency policy. A certificate
+// satisfies it with enough embedded SCTs for its lifetime, under the first of
+// Lifetimes covering it, or with NonEmbedded SCTs delivered in the TLS
+// extension or a stapled OCSP response.
+type CTPolicy struct {
+	Name        string           `json:"name"`
+	Lifetimes   []CTLifetimeRule `json:"lifetimes"`
+	NonEmbedded int              `json:"non_embedded"`
+}
+
+// CTPolicies are the Certificate Transparency policies checked by the
+// CTPolicies scanner.
+var CTPolicies = []CTPolicy{
+	{
+		Name:        "Chrome",
+		Lifetimes:   []CTLifetimeRule{{180 * 24 * time.Hour, 2}, {0, 3}},
+		NonEmbedded: 2,
+	},
+	{
+		Name:        "Apple",
+		Lifetimes:   []CTLifetimeRule{{180 * 24 * time.Hour, 2}, {0, 3}},
+		NonEmbedded: 2,
+	},
+}
+
+// requiredSCTs returns the number of embedded SCTs the policy requires of a
+// certificate with the given lifetime.
+func (p CTPolicy) requiredSCTs(lifetime time.Duration) int {
+	for _, rule := range p.Lifetimes {
+		if rule.MaxLifetime == 0 || lifetime <= rule.MaxLifetime {
+			return rule.MinSCTs
+		}
+	}
+	return 0
+}
+
+// ctPolicyResult records whether a certificate satisfies a CTPolicy.
+type ctPolicyResult struct {
+	Policy      string `json:"policy"`
+	Embedded    int    `json:"embedded"`
+	Required    int    `json:"required"`
+	NonEmbedded int    `json:"non_embedded"`
+	Pass        bool   `json:"pass"`
+}
+
+type ctPolicyResults []ctPolicyResult
+
+func (results ctPolicyResults) String() string {
+	lines := make([]string, len(results))
+	for i, r := range results {
+		verdict := "fail"
+		if r.Pass {
+			verdict = "pass"
+		}
+		lines[i] = fmt.Sprintf("%s: %s (%d of %d embedded SCTs, %d delivered otherwise)",
+			r.Policy, verdict, r.Embedded, r.Required, r.NonEmbedded)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ctPolicyScan tests that the host provides enough SCTs for its certificate
+// to satisfy each of CTPolicies, whose requirements depend on the lifetime of
+// the certificate, grading Warning if any policy isn't satisfied.
+func ctPolicyScan(host string, state *tls.ConnectionState) (grade Grade, output Output, err error) {
+	embedded, tlsExtension, ocspSCTs, _, err := collectSCTs(state)
+	if err != nil {
+		return
+	}
+	leaf := state.PeerCertificates[0]
+	lifetime := leaf.NotAfter.Sub(leaf.NotBefore)
+
+	grade = Good
+	results := make(ctPolicyResults, len(CTPolicies))
+	for i, policy := range CTPolicies {
+		r := ctPolicyResult{
+			Policy:      policy.Name,
+			Embedded:    len(embedded),
+			Required:    policy.requiredSCTs(lifetime),
+			NonEmbedded: len(tlsExtension) + len(ocspSCTs),
+		}
+		r.Pass = r.Embedded >= r.Required || r.NonEmbedded >= policy.NonEmbedded
+		if !r.Pass {
+			grade = Warning
+		}
+		results[i] = r
+	}
+	output = results
+	return
+}
+
+// sctScan tests that the host provides at least minSCTs Signed Certificate
+// Timestamps for its certificate, counting those embedded in the certificate,
+// sent in the TLS extension and carried in a stapled OCSP response. A staple
+// that can't be read is noted in the output but doesn't fail the scan. Embedded
+// SCTs all issued by logs of a single operator in CTLogOperators are flagged,
+// since clients such as Chrome require SCTs from distinct operators.
+func sctScan(host string, state *tls.ConnectionState) (grade Grade, output Output, err error) {
+	embedded, tlsExtension, ocspSCTs, ocspErr, err := collectSCTs(state)
+	if err != nil {
+		return
+	}
+	sources := sctSources{Embedded: len(embedded), TLSExtension: len(tlsExtension), OCSP: len(ocspSCTs)}
+	if ocspErr != nil {
+		sources.OCSPError = ocspErr.Error()
+	}
+
+	operators := make(map[string]bool)
+	allKnown := true
+	for _, sct := range embedded {
+		var logID string
+		if logID, err = sctLogID(sct); err != nil {
+			return
+		}
+		if operator, ok := CTLogOperators[logID]; ok {
+			operators[operator] = true
+		} else {
+			allKnown = false
+		}
+	}
+	for operator := range operators {
+		sources.Operators = append(sources.Operators, operator)
+	}
+	sort.Strings(sources.Operators)
+
+	output = sources
+	switch {
+	case sources.Total() == 0:
+		grade = Bad
+	case sources.Total() < minSCTs:
+		grade = Warning
+	case len(embedded) > 0 && allKnown && len(operators) < 2:
+		grade = Warning
+	default:
+		grade = Good
+	}
+	return
+}
+
+// MaxExtKeyUsages is the number of extended key usages beyond which a
+// certificate is considered too broadly scoped.
+var MaxExtKeyUsages = 2
+
+// extKeyUsageNames names the extended key usages known to crypto/x509.
+var extKeyUsageNames = map[x509.ExtKeyUsage]string{
+	x509.ExtKeyUsageAny:                        "anyExtendedKeyUsage",
+	x509.ExtKeyUsageServerAuth:                 "serverAuth",
+	x509.ExtKeyUsageClientAuth:                 "clientAuth",
+	x509.ExtKeyUsageCodeSigning:                "codeSigning",
+	x509.ExtKeyUsageEmailProtection:            "emailProtection",
+	x509.ExtKeyUsageIPSECEndSystem:             "ipsecEndSystem",
+	x509.ExtKeyUsageIPSECTunnel:                "ipsecTunnel",
+	x509.ExtKeyUsageIPSECUser:                  "ipsecUser",
+	x509.ExtKeyUsageTimeStamping:               "timeStamping",
+	x509.ExtKeyUsageOCSPSigning:                "OCSPSigning",
+	x509.ExtKeyUsageMicrosoftServerGatedCrypto: "msSGC",
+	x509.ExtKeyUsageNetscapeServerGatedCrypto:  "nsSGC",
+}
+
+// extKeyUsages names the extended key usages cert asserts.
+func extKeyUsages(cert *x509.Certificate) []string {
+	var usages []string
+	for _, usage := range cert.ExtKeyUsage {
+		name, ok := extKeyUsageNames[usage]
+		if !ok {
+			name = fmt.Sprintf("unknown usage %d", usage)
+		}
+		usages = append(usages, name)
+	}
+	for _, oid := range cert.UnknownExtKeyUsage {
+		usages = append(usages, oid.String())
+	}
+	return usages
+}
+
+// hasExtKeyUsage reports whether cert asserts usage.
+func hasExtKeyUsage(cert *x509.Certificate, usage x509.ExtKeyUsage) bool {
+	for _, u := range cert.ExtKeyUsage {
+		if u == usage {
+			return true
+		}
+	}
+	return false
+}
+
+// ekuConstraint describes the extended key usages a CA in a host's chain
+// constrains its leaves to.
+type ekuConstraint struct {
+	CA         string   `json:"ca"`
+	Usages     []string `json:"usages"`
+	ServerAuth bool     `json:"server_auth"`
+}
+
+// ekuConstraints lists the CAs in a host's chain that constrain extended key
+// usage.
+type ekuConstraints []ekuConstraint
+
+func (constraints ekuConstraints) String() string {
+	lines := make([]string, len(constraints))
+	for i, c := range constraints {
+		lines[i] = c.CA + ": " + strings.Join(c.Usages, ", ")
+		if !c.ServerAuth {
+			lines[i] += " (lacks serverAuth)"
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ekuChainingScan tests that every CA the host presents that constrains
+// extended key usage allows serverAuth. Clients chaining EKU, as RFC 5280
+// permits and many do, reject a server leaf beneath a CA that doesn't.
+func ekuChainingScan(host string, state *tls.ConnectionState) (grade Grade, output Output, err error) {
+	var constraints ekuConstraints
+	grade = Good
+	for _, ca := range state.PeerCertificates[1:] {
+		usages := extKeyUsages(ca)
+		if len(usages) == 0 {
+			continue
+		}
+		serverAuth := hasExtKeyUsage(ca, x509.ExtKeyUsageServerAuth) || hasExtKeyUsage(ca, x509.ExtKeyUsageAny)
+		constraints = append(constraints, ekuConstraint{CA: certName(ca), Usages: usages, ServerAuth: serverAuth})
+		if !serverAuth {
+			grade = Bad
+		}
+	}
+	if len(constraints) > 0 {
+		output = constraints
+	}
+	return
+}
+
+// extKeyUsageScan tests that the host's leaf certificate doesn't assert
+// anyExtendedKeyUsage or more than MaxExtKeyUsages extended key usages, since
+// a server certificate should be narrowly scoped.
+func extKeyUsageScan(host string, state *tls.ConnectionState) (grade Grade, output Output, err error) {
+	leaf := state.PeerCertificates[0]
+	usages := issueList(extKeyUsages(leaf))
+	anyUsage := hasExtKeyUsage(leaf, x509.ExtKeyUsageAny)
+
+	output = usages
+	if anyUsage || len(usages) > MaxExtKeyUsages {
+		grade = Warning
+		return
+	}
+	grade = Good
+	return
+}
+
+// hostList lists hosts, one per line.
+type hostList []string
+
+func (hosts hostList) String() string {
+	return strings.Join(hosts, "\n")
+}
+
+// ReusedKeys checks the SPKI hashes recorded by the PKI CompromisedKey scanner
+// across a fleet of reports, returning a Warning result for each key found on
+// more than one host, keyed by its SPKI hash and listing the hosts using it.
+// Hosts whose key wasn't recorded are ignored.
+func ReusedKeys(reports []HostReport) map[string]ScannerResult {
+	keyHosts := make(map[string]hostList)
+	for _, report := range reports {
+		result, ok := report.Families[pkiFamily][compromisedKeyScanner]
+		if !ok || result.Error != nil || result.Output == nil {
+			continue
+		}
+		hash := result.Output.String()
+		keyHosts[hash] = append(keyHosts[hash], report.Host)
+	}
+
+	reused := make(map[string]ScannerResult)
+	for hash, hosts := range keyHosts {
+		if len(hosts) > 1 {
+			sort.Strings(hosts)
+			reused[hash] = ScannerResult{Grade: Warning, Output: hosts}
+		}
+	}
+	return reused
+}
+
+// internalSuffixes are name suffixes reserved or conventionally used for
+// private networks.
+var internalSuffixes = []string{".local", ".localdomain", ".internal", ".intranet", ".lan", ".corp", ".home.arpa"}
+
+// isInternalName reports whether name can only be resolved on a private
+// network: it is a single label, has one of internalSuffixes, or ends in a
+// top-level domain ICANN hasn't delegated.
+func isInternalName(name string) bool {
+	name = strings.TrimSuffix(strings.ToLower(strings.TrimPrefix(name, "*.")), ".")
+	if !strings.Contains(name, ".") {
+		return true
+	}
+	for _, suffix := range internalSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	_, icann := publicsuffix.PublicSuffix(name[strings.LastIndex(name, ".")+1:])
+	return !icann
+}
+
+// wildcardBreadth grades a wildcard name by the domain it covers: Bad if it is
+// directly over an ICANN public suffix such as "com" or "co.uk", and Warning if
+// it is over a privately registered suffix, covering every site hosted there.
+func wildcardBreadth(name string) Grade {
+	name = strings.ToLower(name)
+	if !strings.HasPrefix(name, "*.") {
+		return Good
+	}
+	base := strings.TrimSuffix(name[2:], ".")
+	suffix, icann := publicsuffix.PublicSuffix(base)
+	switch {
+	case suffix != base:
+		return Good
+	case icann:
+		return Bad
+	default:
+		return Warning
+	}
+}
+
+// isBroadWildcard reports whether name is a wildcard over a registrable
+// domain or public suffix, such as "*.example.com", rather than beneath a
+// particular service's subdomain.
+func isBroadWildcard(name string) bool {
+	name = strings.ToLower(name)
+	if !strings.HasPrefix(name, "*.") {
+		return false
+	}
+	base := strings.TrimSuffix(name[2:], ".")
+	registrable, err := publicsuffix.EffectiveTLDPlusOne(base)
+	return err != nil || registrable == base
+}
+
+// broadWildcardScan tests that none of the host's certificate names is a
+// wildcard directly over a public suffix.
+func broadWildcardScan(host string, state *tls.ConnectionState) (grade Grade, output Output, err error) {
+	grade = Good
+	var broad domainList
+	for _, name := range state.PeerCertificates[0].DNSNames {
+		if g := wildcardBreadth(name); g != Good {
+			broad = append(broad, name)
+			if g < grade {
+				grade = g
+			}
+		}
+	}
+	if len(broad) > 0 {
+		output = broad
+	}
+	return
+}
+
+// certParseStatus records the problems found parsing a certificate strictly.
+type certParseStatus struct {
+	Certificate string   `json:"certificate"`
+	Failures    []string `json:"failures,omitempty"`
+	Anomalies   []string `json:"anomalies,omitempty"`
+}
+
+// certParseStatuses lists the parse status of each certificate in a chain.
+type certParseStatuses []certParseStatus
+
+func (statuses certParseStatuses) String() string {
+	lines := make([]string, len(statuses))
+	for i, s := range statuses {
+		problems := append(append([]string{}, s.Failures...), s.Anomalies...)
+		if len(problems) == 0 {
+			problems = []string{"parses cleanly"}
+		}
+		lines[i] = s.Certificate + ": " + strings.Join(problems, "; ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// validDNSName reports whether name is a syntactically valid host name,
+// optionally with a leading wildcard label.
+func validDNSName(name string) bool {
+	name = strings.TrimPrefix(name, "*.")
+	if name == "" || len(name) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// tbsFailures walks the DER of a TBSCertificate, collecting encodings that
+// DER or RFC 5280 forbid but crypto/x509 accepts: default values encoded
+// explicitly, and validity times in the wrong type or form. It also returns
+// the encoded length of the serial number, sign octet included.
+func tbsFailures(tbs []byte) (failures []string, serialLength int) {
+	var seq asn1.RawValue
+	if _, err := asn1.Unmarshal(tbs, &seq); err != nil {
+		return []string{"TBSCertificate isn't DER: " + err.Error()}, 0
+	}
+	rest := seq.Bytes
+	next := func() (field asn1.RawValue, ok bool) {
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &field); err != nil {
+			return field, false
+		}
+		return field, true
+	}
+
+	field, ok := next()
+	if ok && field.Class == asn1.ClassContextSpecific && field.Tag == 0 {
+		var version int
+		if _, err := asn1.Unmarshal(field.Bytes, &version); err == nil && version == 0 {
+			failures = append(failures, "version 1 is encoded explicitly, though DER omits defaults")
+		}
+		field, ok = next()
+	}
+	if !ok || field.Tag != asn1.TagInteger {
+		return append(failures, "TBSCertificate has no serial number"), 0
+	}
+	serialLength = len(field.Bytes)
+
+	// Skip the signature algorithm and issuer.
+	next()
+	next()
+	if validity, ok := next(); ok {
+		times := validity.Bytes
+		for len(times) > 0 {
+			var t asn1.RawValue
+			var err error
+			if times, err = asn1.Unmarshal(times, &t); err != nil {
+				break
+			}
+			if problem := validityTimeProblem(t); problem != "" {
+				failures = append(failures, problem)
+			}
+		}
+	}
+	// Skip the subject and public key, then look for the extensions among
+	// the optional fields that remain.
+	next()
+	next()
+	for len(rest) > 0 {
+		if field, ok = next(); !ok {
+			break
+		}
+		if field.Class != asn1.ClassContextSpecific || field.Tag != 3 {
+			continue
+		}
+		var exts []asn1.RawValue
+		if _, err := asn1.Unmarshal(field.Bytes, &exts); err != nil {
+			break
+		}
+		for _, ext := range exts {
+			var id asn1.ObjectIdentifier
+			var critical asn1.RawValue
+			remaining, err := asn1.Unmarshal(ext.Bytes, &id)
+			if err != nil {
+				continue
+			}
+			if _, err = asn1.Unmarshal(remaining, &critical); err == nil &&
+				critical.Tag == asn1.TagBoolean && len(critical.Bytes) == 1 && critical.Bytes[0] == 0 {
+				failures = append(failures, "extension "+id.String()+" encodes critical FALSE, though DER omits defaults")
+			}
+		}
+	}
+	return failures, serialLength
+}
+
+// validityTimeProblem describes how t departs from RFC 5280 section 4.1.2.5,
+// which requires UTCTime in YYMMDDHHMMSSZ form through 2049 and
+// GeneralizedTime in YYYYMMDDHHMMSSZ form from 2050, or returns "".
+func validityTimeProblem(t asn1.RawValue) string {
+	switch t.Tag {
+	case asn1.TagUTCTime:
+		if len(t.Bytes) != 13 || t.Bytes[12] != 'Z' {
+			return fmt.Sprintf("validity time %q isn't in YYMMDDHHMMSSZ form", t.Bytes)
+		}
+	case asn1.TagGeneralizedTime:
+		if len(t.Bytes) != 15 || t.Bytes[14] != 'Z' {
+			return fmt.Sprintf("validity time %q isn't in YYYYMMDDHHMMSSZ form", t.Bytes)
+		}
+		if string(t.Bytes[:4]) < "2050" {
+			return fmt.Sprintf("validity time %q is GeneralizedTime before 2050", t.Bytes)
+		}
+	default:
+		return "validity time is neither UTCTime nor GeneralizedTime"
+	}
+	return ""
+}
+
+// parseStatus checks cert strictly, collecting failures that crypto/x509
+// tolerated, such as extension values it doesn't decode that aren't valid
+// DER, and anomalies that violate RFC 5280 without breaking parsing.
+func parseStatus(cert *x509.Certificate) certParseStatus {
+	status := certParseStatus{Certificate: certName(cert)}
+	failures, serialLength := tbsFailures(cert.RawTBSCertificate)
+	status.Failures = append(status.Failures, failures...)
+	for _, ext := range cert.Extensions {
+		if rest, err := asn1.Unmarshal(ext.Value, new(asn1.RawValue)); err != nil || len(rest) > 0 {
+			status.Failures = append(status.Failures, "extension "+ext.Id.String()+" isn't well-formed DER")
+		}
+	}
+
+	if cert.Version != 3 {
+		status.Anomalies = append(status.Anomalies, fmt.Sprintf("version %d", cert.Version))
+	}
+	if cert.SerialNumber.Sign() <= 0 {
+		status.Anomalies = append(status.Anomalies, "serial number isn't positive")
+	} else if serialLength > 20 {
+		status.Anomalies = append(status.Anomalies, "serial number is longer than 20 octets")
+	}
+	for _, name := range cert.DNSNames {
+		if !validDNSName(name) {
+			status.Anomalies = append(status.Anomalies, fmt.Sprintf("invalid DNS name %q", name))
+		}
+	}
+	return status
+}
+
+// certParseScan parses each certificate the host presents strictly, graded
+// Bad if any has a failure crypto/x509 tolerated, and Warning if any has an
+// anomaly. Clients stricter than Go may reject such certificates.
+func certParseScan(host string, state *tls.ConnectionState) (grade Grade, output Output, err error) {
+	statuses := make(certParseStatuses, len(state.PeerCertificates))
+	grade = Good
+	for i, cert := range state.PeerCertificates {
+		statuses[i] = parseStatus(cert)
+		if len(statuses[i].Failures) > 0 {
+			grade = Bad
+		} else if len(statuses[i].Anomalies) > 0 && grade == Good {
+			grade = Warning
+		}
+	}
+	return grade, statuses, nil
+}
+
+// flaggedExtension is a certificate extension that shouldn't be present.
+type flaggedExtension struct {
+	OID      string `json:"oid"`
+	Name     string `json:"name,omitempty"`
+	Critical bool   `json:"critical"`
+}
+
+type flaggedExtensions []flaggedExtension
+
+func (exts flaggedExtensions) String() string {
+	lines := make([]string, len(exts))
+	for i, ext := range exts {
+		lines[i] = ext.OID
+		if ext.Name != "" {
+			lines[i] += " (" + ext.Name + ")"
+		}
+		if ext.Critical {
+			lines[i] += ", critical"
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// deprecatedExtensionScan tests that the host's certificate doesn't carry any
+// of the DeprecatedExtensions, or critical extensions that clients can't
+// process. Critical extensions are graded more harshly, since clients must
+// reject certificates whose critical extensions they don't recognize.
+func deprecatedExtensionScan(host string, state *tls.ConnectionState) (grade Grade, output Output, err error) {
+	leaf := state.PeerCertificates[0]
+	unhandled := make(map[string]bool)
+	for _, oid := range leaf.UnhandledCriticalExtensions {
+		unhandled[oid.String()] = true
+	}
+
+	var flagged flaggedExtensions
+	grade = Good
+	for _, ext := range leaf.Extensions {
+		oid := ext.Id.String()
+		name, deprecated := DeprecatedExtensions[oid]
+		if !deprecated && !unhandled[oid] {
+			continue
+		}
+		flagged = append(flagged, flaggedExtension{OID: oid, Name: name, Critical: ext.Critical})
+		if ext.Critical {
+			grade = Bad
+		} else if grade == Good {
+			grade = Warning
+		}
+	}
+	output = flagged
+	return
+}
@@ -0,0 +1,58 @@
+package scan
+
+import (
+	"crypto/x509"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cf-tls/tls"
+	"golang.org/x/crypto/ocsp"
+)
+
+// FuzzPKIScanners feeds malformed DER to every PKI scanner that grades the
+// result of a handshake, as the host's leaf and issuer certificates when it
+// parses, and as its stapled OCSP response and SCT list regardless. Errors
+// are expected; panics aren't.
+func FuzzPKIScanners(f *testing.F) {
+	root := newTestCert(f, testCATemplate("Test Root"), testKey.Public(), nil, testKey)
+	leaf := newTestCert(f, testTemplate("localhost"), testKey.Public(), root, testKey)
+	staple, err := ocsp.CreateResponse(root, root, ocsp.Response{
+		Status:       ocsp.Good,
+		SerialNumber: leaf.SerialNumber,
+		ThisUpdate:   time.Now(),
+	}, testKey)
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(leaf.Raw)
+	f.Add(root.Raw)
+	f.Add(leaf.Raw[:len(leaf.Raw)/2])
+	f.Add(staple)
+	f.Add([]byte{0, 6, 0, 4, 0, 0xff, 0xff, 0xff})
+
+	defer func(timeout time.Duration) { aiaFetchTimeout = timeout }(aiaFetchTimeout)
+	aiaFetchTimeout = 10 * time.Millisecond
+
+	f.Fuzz(func(t *testing.T, der []byte) {
+		state := &tls.ConnectionState{
+			PeerCertificates:            []*x509.Certificate{leaf, root},
+			OCSPResponse:                der,
+			SignedCertificateTimestamps: [][]byte{der},
+		}
+		if cert, err := x509.ParseCertificate(der); err == nil {
+			state.PeerCertificates = []*x509.Certificate{cert, cert}
+		}
+
+		withResolver(stubResolver{}, func() {
+			for name, scanner := range PKI.Scanners {
+				if scanner.scanState == nil {
+					continue
+				}
+				_, _, err := scanner.run("localhost:1", func() (*tls.ConnectionState, error) { return state, nil })
+				if panicErr, ok := err.(*PanicError); ok {
+					t.Errorf("%s: %v", name, panicErr)
+				}
+			}
+		})
+	})
+}
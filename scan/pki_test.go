@@ -0,0 +1,2633 @@
+package scan
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cftls "github.com/cloudflare/cf-tls/tls"
+	"golang.org/x/crypto/ocsp"
+)
+
+// testKey is shared by test certificates that don't care about their key.
+var testKey, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+// testTemplate returns a certificate template for a server named cn, valid
+// from an hour ago for 90 days.
+func testTemplate(cn string) *x509.Certificate {
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     []string{cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+}
+
+// testCATemplate returns a certificate template for a CA named cn.
+func testCATemplate(cn string) *x509.Certificate {
+	template := testTemplate(cn)
+	template.DNSNames = nil
+	template.ExtKeyUsage = nil
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	template.NotAfter = time.Now().Add(5 * 365 * 24 * time.Hour)
+	return template
+}
+
+// newTestCert creates a certificate from template for pub, signed by parent
+// with parentKey, or self-signed with parentKey if parent is nil.
+func newTestCert(t testing.TB, template *x509.Certificate, pub interface{}, parent *x509.Certificate, parentKey crypto.Signer) *x509.Certificate {
+	if parent == nil {
+		parent = template
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, pub, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// newChainServer returns an unstarted HTTPS server presenting chain, whose
+// leaf belongs to key.
+func newChainServer(key crypto.Signer, chain ...*x509.Certificate) *httptest.Server {
+	cert := tls.Certificate{PrivateKey: key}
+	for _, c := range chain {
+		cert.Certificate = append(cert.Certificate, c.Raw)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	// Scanners hang up as soon as they have the server's certificates.
+	server.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	return server
+}
+
+// serveChain starts an HTTPS server presenting chain, whose leaf belongs to key.
+func serveChain(key crypto.Signer, chain ...*x509.Certificate) *httptest.Server {
+	server := newChainServer(key, chain...)
+	server.StartTLS()
+	return server
+}
+
+// scanChain runs the PKI scanner named scanner against a server presenting
+// chain, whose leaf belongs to key, and stapling staple if it isn't nil.
+func scanChain(scanner string, staple []byte, key crypto.Signer, chain ...*x509.Certificate) (Grade, Output, error) {
+	server := newChainServer(key, chain...)
+	server.TLS.Certificates[0].OCSPStaple = staple
+	server.StartTLS()
+	defer server.Close()
+	return PKI.Scanners[scanner].Scan(server.Listener.Addr().String())
+}
+
+// outputString returns the description of output, or "" if there is none.
+func outputString(output Output) string {
+	if output == nil {
+		return ""
+	}
+	return output.String()
+}
+
+func TestCompromisedKeyScan(t *testing.T) {
+	leaf := newTestCert(t, testTemplate("localhost"), testKey.Public(), nil, testKey)
+	server := serveChain(testKey, leaf)
+	defer server.Close()
+	host := server.Listener.Addr().String()
+
+	grade, output, err := PKI.Scanners["CompromisedKey"].Scan(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grade != Good {
+		t.Fatalf("expected uncompromised key to be Good, got %s", grade)
+	}
+	if output.String() != string(certSPKIHash(leaf)) {
+		t.Fatalf("unexpected SPKI hash %s", output)
+	}
+
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	other := newTestCert(t, testTemplate("localhost"), otherKey.Public(), nil, otherKey)
+	defer func(keys map[string]bool) { CompromisedKeys = keys }(CompromisedKeys)
+	CompromisedKeys = map[string]bool{string(certSPKIHash(other)): true}
+	if grade, _, err = PKI.Scanners["CompromisedKey"].Scan(host); err != nil || grade != Good {
+		t.Fatalf("expected non-matching key to be Good, got %s: %v", grade, err)
+	}
+
+	CompromisedKeys[string(certSPKIHash(leaf))] = true
+	if grade, _, err = PKI.Scanners["CompromisedKey"].Scan(host); err != nil || grade != Bad {
+		t.Fatalf("expected compromised key to be Bad, got %s: %v", grade, err)
+	}
+}
+
+// capturingLogger records each message logged to it, prefixed by its level.
+type capturingLogger []string
+
+func (l *capturingLogger) logf(level, format string, v []interface{}) {
+	*l = append(*l, level+" "+fmt.Sprintf(format, v...))
+}
+
+func (l *capturingLogger) Debugf(format string, v ...interface{})   { l.logf("DEBUG", format, v) }
+func (l *capturingLogger) Infof(format string, v ...interface{})    { l.logf("INFO", format, v) }
+func (l *capturingLogger) Warningf(format string, v ...interface{}) { l.logf("WARNING", format, v) }
+
+func TestCertExpiration(t *testing.T) {
+	day := 24 * time.Hour
+	cases := []struct {
+		expiresIn time.Duration
+		grade     Grade
+		bucket    string
+	}{
+		{90*day + time.Hour, Good, "good"},
+		{90*day - time.Hour, Notice, "notice"},
+		{30*day + time.Hour, Notice, "notice"},
+		{30*day - time.Hour, Warning, "warning"},
+		{7*day + time.Hour, Warning, "warning"},
+		{7*day - time.Hour, Bad, "critical"},
+		{time.Hour, Bad, "critical"},
+		{-time.Minute, Bad, "expired"},
+	}
+
+	for _, c := range cases {
+		template := testTemplate("localhost")
+		template.NotBefore = time.Now().Add(-24 * time.Hour)
+		template.NotAfter = time.Now().Add(c.expiresIn)
+		leaf := newTestCert(t, template, testKey.Public(), nil, testKey)
+		grade, output, err := scanChain("CertExpiration", nil, testKey, leaf)
+		if err != nil || grade != c.grade {
+			t.Fatalf("expected chain expiring in %s to be %s, got %s: %v", c.expiresIn, c.grade, grade, err)
+		}
+		if output.String() != (expiration{Time: leaf.NotAfter, Bucket: c.bucket}).String() {
+			t.Fatalf("expected chain expiring in %s in bucket %s, got %s", c.expiresIn, c.bucket, output)
+		}
+	}
+}
+
+func TestNewCertExpirationScanner(t *testing.T) {
+	day := 24 * time.Hour
+	scanner, err := NewCertExpirationScanner(
+		ExpiryBucket{Label: "soon", Within: 60 * day, Grade: Notice},
+		ExpiryBucket{Label: "imminent", Within: 14 * day, Grade: Bad},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		expiresIn time.Duration
+		grade     Grade
+		bucket    string
+	}{
+		{60*day + time.Hour, Good, "good"},
+		{30 * day, Notice, "soon"},
+		{10 * day, Bad, "imminent"},
+	}
+	for _, c := range cases {
+		template := testTemplate("localhost")
+		template.NotAfter = time.Now().Add(c.expiresIn)
+		leaf := newTestCert(t, template, testKey.Public(), nil, testKey)
+		server := serveChain(testKey, leaf)
+
+		grade, output, err := scanner.Scan(server.Listener.Addr().String())
+		server.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if grade != c.grade || output.(expiration).Bucket != c.bucket {
+			t.Fatalf("expected chain expiring in %s to be %s in bucket %s, got %s in %s", c.expiresIn, c.grade, c.bucket, grade, output)
+		}
+	}
+
+	invalid := [][]ExpiryBucket{
+		{{Within: day, Grade: Bad}},
+		{{Label: "never", Grade: Bad}},
+		{{Label: "graded", Within: day, Grade: Good + 1}},
+		{{Label: "one", Within: day, Grade: Bad}, {Label: "other", Within: day, Grade: Warning}},
+	}
+	for _, buckets := range invalid {
+		if _, err := NewCertExpirationScanner(buckets...); err == nil {
+			t.Fatalf("expected buckets %v to be rejected", buckets)
+		}
+	}
+}
+
+func TestRenewalWindowScan(t *testing.T) {
+	template := testTemplate("localhost")
+	template.NotAfter = time.Now().Add(10 * 24 * time.Hour)
+	leaf := newTestCert(t, template, testKey.Public(), nil, testKey)
+	server := serveChain(testKey, leaf)
+	defer server.Close()
+	host := server.Listener.Addr().String()
+
+	defer func(d time.Duration) { RenewalLeadTime = d }(RenewalLeadTime)
+	cases := []struct {
+		leadTime time.Duration
+		grade    Grade
+		output   string
+	}{
+		{7 * 24 * time.Hour, Good, "10.0 days remaining, renewal lead time 7.0 days"},
+		{14 * 24 * time.Hour, Warning, "10.0 days remaining, renewal lead time 14.0 days"},
+		{45 * 24 * time.Hour, Warning, "10.0 days remaining, renewal lead time 45.0 days"},
+	}
+	for _, c := range cases {
+		RenewalLeadTime = c.leadTime
+		grade, output, err := PKI.Scanners["RenewalWindow"].Scan(host)
+		if err != nil || grade != c.grade || outputString(output) != c.output {
+			t.Fatalf("lead time %s: expected %s (%s), got %s (%s): %v", c.leadTime, c.grade, c.output, grade, output, err)
+		}
+	}
+}
+
+func TestExpirationExpiresIn(t *testing.T) {
+	e := expiration{Time: time.Now().Add(10 * 24 * time.Hour)}
+	if d := e.ExpiresIn(); d > 10*24*time.Hour || d < 10*24*time.Hour-time.Minute {
+		t.Fatalf("expected about 10 days remaining, got %s", d)
+	}
+	if d := (expiration{Time: time.Now().Add(-time.Hour)}).ExpiresIn(); d >= 0 {
+		t.Fatalf("expected negative duration for passed expiration, got %s", d)
+	}
+}
+
+func TestExpirationJSON(t *testing.T) {
+	expiresAt := time.Now().Add(45*24*time.Hour + time.Hour).UTC().Truncate(time.Second)
+	b, err := json.Marshal(expiration{Time: expiresAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded struct {
+		ExpiresAt     time.Time `json:"expires_at"`
+		DaysRemaining int       `json:"days_remaining"`
+	}
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.ExpiresAt.Equal(expiresAt) || decoded.DaysRemaining != 45 {
+		t.Fatalf("unexpected encoding %s", b)
+	}
+}
+
+func TestCertExpirationOutput(t *testing.T) {
+	leaf := newTestCert(t, testTemplate("localhost"), testKey.Public(), nil, testKey)
+	server := serveChain(testKey, leaf)
+	defer server.Close()
+
+	_, output, err := PKI.Scanners["CertExpiration"].Scan(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, ok := output.(expiration)
+	if !ok {
+		t.Fatalf("unexpected output type %T", output)
+	}
+	if !e.Time.Equal(leaf.NotAfter) {
+		t.Fatalf("expected expiration at %s, got %s", leaf.NotAfter, e.Time)
+	}
+}
+
+func TestCertExpirationLogging(t *testing.T) {
+	leaf := newTestCert(t, testTemplate("localhost"), testKey.Public(), nil, testKey)
+	server := serveChain(testKey, leaf)
+	defer server.Close()
+	host := server.Listener.Addr().String()
+
+	logger := new(capturingLogger)
+	defer func(l Logger) { ScanLogger = l }(ScanLogger)
+	ScanLogger = logger
+
+	fs := FamilySet{"PKI": PKI}
+	if _, err := fs.RunScans(host, "PKI", "^CertExpiration$"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"INFO scan: running PKI/CertExpiration against " + host,
+		"DEBUG scan: dialing " + host,
+		"DEBUG scan: handshake with " + host + " complete",
+		"DEBUG scan: parsed 1 certificates from " + host,
+		"DEBUG scan: certificate chain of " + host + " expires at",
+		// The test certificate expires within the notice bucket.
+		"INFO scan: PKI/CertExpiration graded " + host + " as Notice",
+	}
+	if len(*logger) != len(expected) {
+		t.Fatalf("expected %d events, got %d: %q", len(expected), len(*logger), *logger)
+	}
+	for i, event := range *logger {
+		if !strings.HasPrefix(event, expected[i]) {
+			t.Fatalf("event %d: expected %q, got %q", i, expected[i], event)
+		}
+	}
+}
+
+func TestRevocationInfoScan(t *testing.T) {
+	ocspServer := []string{"http://ocsp.example.com"}
+	crlPoint := []string{"http://crl.example.com/ca.crl"}
+	cases := []struct {
+		ocsp, crl []string
+		grade     Grade
+		output    string
+	}{
+		{ocspServer, nil, Good, "OCSP: http://ocsp.example.com"},
+		{nil, crlPoint, Good, "CRL: http://crl.example.com/ca.crl"},
+		{ocspServer, crlPoint, Good, "OCSP: http://ocsp.example.com\nCRL: http://crl.example.com/ca.crl"},
+		{nil, nil, Warning, "no OCSP responder or CRL distribution point advertised"},
+	}
+
+	for _, c := range cases {
+		template := testTemplate("localhost")
+		template.OCSPServer = c.ocsp
+		template.CRLDistributionPoints = c.crl
+		leaf := newTestCert(t, template, testKey.Public(), nil, testKey)
+		grade, output, err := scanChain("RevocationInfo", nil, testKey, leaf)
+		if err != nil || grade != c.grade || outputString(output) != c.output {
+			t.Fatalf("expected %s (%q), got %s (%q): %v", c.grade, c.output, grade, output, err)
+		}
+	}
+}
+
+func TestCRLFreshnessScan(t *testing.T) {
+	defer func(size int64) { maxCRLSize = size }(maxCRLSize)
+	root := newTestCert(t, testCATemplate("Test Root"), testKey.Public(), nil, testKey)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	now := time.Now()
+	newCRL := func(key crypto.Signer, thisUpdate, nextUpdate time.Time) []byte {
+		crl, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+			Number:     big.NewInt(1),
+			ThisUpdate: thisUpdate,
+			NextUpdate: nextUpdate,
+		}, root, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return crl
+	}
+	crls := map[string][]byte{
+		"/fresh.crl": newCRL(testKey, now.Add(-time.Hour), now.Add(7*24*time.Hour)),
+		"/stale.crl": newCRL(testKey, now.Add(-14*24*time.Hour), now.Add(-7*24*time.Hour)),
+		"/rogue.crl": newCRL(otherKey, now.Add(-time.Hour), now.Add(7*24*time.Hour)),
+		"/junk.crl":  []byte("not a CRL"),
+	}
+	crlServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		crl, ok := crls[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(crl)
+	}))
+	defer crlServer.Close()
+
+	cases := []struct {
+		path    string
+		maxSize int64
+		grade   Grade
+		problem string
+	}{
+		{"", 1 << 20, Skipped, ""},
+		{"/fresh.crl", 1 << 20, Good, ""},
+		{"/stale.crl", 1 << 20, Warning, "stale"},
+		{"/rogue.crl", 1 << 20, Warning, "not signed by Test Root"},
+		{"/junk.crl", 1 << 20, Warning, "unparseable"},
+		{"/missing.crl", 1 << 20, Warning, "404 Not Found"},
+		{"/fresh.crl", 16, Warning, "CRL exceeds 16 bytes"},
+	}
+
+	for _, c := range cases {
+		maxCRLSize = c.maxSize
+		template := testTemplate("localhost")
+		if c.path != "" {
+			template.CRLDistributionPoints = []string{crlServer.URL + c.path}
+		}
+		leaf := newTestCert(t, template, testKey.Public(), root, testKey)
+		grade, output, err := scanChain("CRLFreshness", nil, testKey, leaf, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if grade != c.grade || (output == nil) != (c.grade == Skipped) || output != nil && !strings.Contains(output.String(), c.problem) {
+			t.Fatalf("%s: expected %s (%q), got %s (%v)", c.path, c.grade, c.problem, grade, output)
+		}
+		if c.path == "/fresh.crl" && c.grade == Good && !strings.Contains(output.String(), "next update") {
+			t.Fatalf("expected the CRL's update times, got %v", output)
+		}
+	}
+}
+
+func TestKeyIdentifierScan(t *testing.T) {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := testCATemplate("Test CA")
+	caTemplate.SubjectKeyId = []byte{1, 2, 3, 4}
+	ca := newTestCert(t, caTemplate, caKey.Public(), nil, caKey)
+
+	// Issuing from a copy of the CA without its SubjectKeyId leaves the leaf without an AuthorityKeyId.
+	anonymousCA := *ca
+	anonymousCA.SubjectKeyId = nil
+
+	cases := []struct {
+		parent *x509.Certificate
+		ski    []byte
+		grade  Grade
+		output string
+	}{
+		{ca, []byte{5, 6, 7, 8}, Good, ""},
+		{ca, nil, Warning, "localhost: missing SubjectKeyId"},
+		{&anonymousCA, []byte{5, 6, 7, 8}, Warning, "localhost: missing AuthorityKeyId"},
+	}
+
+	for _, c := range cases {
+		template := testTemplate("localhost")
+		template.SubjectKeyId = c.ski
+		leaf := newTestCert(t, template, testKey.Public(), c.parent, caKey)
+		grade, output, err := scanChain("KeyIdentifiers", nil, testKey, leaf, ca)
+		if err != nil || grade != c.grade || outputString(output) != c.output {
+			t.Fatalf("expected %s (%q), got %s (%q): %v", c.grade, c.output, grade, output, err)
+		}
+	}
+}
+
+func TestRunScansSharesHandshake(t *testing.T) {
+	leaf := newTestCert(t, testTemplate("localhost"), testKey.Public(), nil, testKey)
+	server := newChainServer(testKey, leaf)
+	var conns int32
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	server.StartTLS()
+	defer server.Close()
+	host := server.Listener.Addr().String()
+
+	results, err := FamilySet{"PKI": PKI}.RunScans(host, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&conns); n != 1 {
+		t.Fatalf("expected PKI scanners to share a single handshake, got %d connections", n)
+	}
+
+	for name, scanner := range PKI.Scanners {
+		grade, output, _ := scanner.Scan(host)
+		shared := results["PKI"][name]
+		if shared.Grade != grade || fmt.Sprint(shared.Output) != fmt.Sprint(output) {
+			t.Fatalf("%s: shared handshake gave %s (%v), own handshake gave %s (%v)",
+				name, shared.Grade, shared.Output, grade, output)
+		}
+	}
+}
+
+func benchmarkPKIScans(b *testing.B, scan func(host string)) {
+	leaf := newTestCert(b, testTemplate("localhost"), testKey.Public(), nil, testKey)
+	server := serveChain(testKey, leaf)
+	defer server.Close()
+	host := server.Listener.Addr().String()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		scan(host)
+	}
+}
+
+func BenchmarkRunScansSharedHandshake(b *testing.B) {
+	benchmarkPKIScans(b, func(host string) {
+		FamilySet{"PKI": PKI}.RunScans(host, "", "")
+	})
+}
+
+func BenchmarkScanEachScanner(b *testing.B) {
+	benchmarkPKIScans(b, func(host string) {
+		for _, scanner := range PKI.Scanners {
+			scanner.Scan(host)
+		}
+	})
+}
+
+func TestCheckIDN(t *testing.T) {
+	cases := []struct {
+		host      string
+		names     []string
+		grade     Grade
+		matches   bool
+		issues    int
+		hostASCII string
+	}{
+		// A U-label host matches the A-label SAN after normalization.
+		{"bücher.example", []string{"xn--bcher-kva.example"}, Good, true, 0, "xn--bcher-kva.example"},
+		{"xn--bcher-kva.example", []string{"*.example", "xn--bcher-kva.example"}, Good, true, 0, "xn--bcher-kva.example"},
+		// A U-label SAN is improperly encoded, even though it names the host.
+		{"xn--bcher-kva.example", []string{"bücher.example"}, Warning, false, 1, "xn--bcher-kva.example"},
+		{"bücher.example", []string{"buecher.example"}, Warning, false, 0, "xn--bcher-kva.example"},
+		{"Bücher.example", []string{"*.example"}, Good, true, 0, "xn--bcher-kva.example"},
+		{"example.com", []string{"example.com", "xn--zz.example.com"}, Warning, true, 1, "example.com"},
+		// Without internationalized names, a mismatch is none of this check's business.
+		{"www.example.com", []string{"other.example.net"}, Skipped, false, 0, "www.example.com"},
+	}
+
+	for _, c := range cases {
+		grade, comparison := checkIDN(c.host, &x509.Certificate{DNSNames: c.names})
+		if grade != c.grade || comparison.Matches != c.matches || len(comparison.Issues) != c.issues {
+			t.Fatalf("%s against %v: expected %s, matching %v, with %d issues, got %s", c.host, c.names, c.grade, c.matches, c.issues, comparison)
+		}
+		if comparison.HostASCII != c.hostASCII {
+			t.Fatalf("expected %s to normalize to %s, got %s", c.host, c.hostASCII, comparison.HostASCII)
+		}
+	}
+}
+
+func TestIDNScan(t *testing.T) {
+	template := testTemplate("localhost")
+	template.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	leaf := newTestCert(t, template, testKey.Public(), nil, testKey)
+	server := serveChain(testKey, leaf)
+	defer server.Close()
+
+	grade, output, err := PKI.Scanners["IDNEncoding"].Scan(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grade != Skipped || output != nil {
+		t.Fatalf("expected host without internationalized names to be Skipped, got %s (%v)", grade, output)
+	}
+}
+
+func TestRegistrableDomainScan(t *testing.T) {
+	cases := []struct {
+		names  []string
+		grade  Grade
+		output string
+	}{
+		{[]string{"example.com", "www.example.com", "*.api.example.com"}, Good, "example.com"},
+		{[]string{"example.com", "example.co.uk", "WWW.EXAMPLE.CO.UK"}, Good, "example.co.uk\nexample.com"},
+		{[]string{"a.example.com", "b.example.net", "c.example.org", "d.example.co.uk"}, Warning,
+			"example.co.uk\nexample.com\nexample.net\nexample.org"},
+	}
+
+	for _, c := range cases {
+		template := testTemplate("localhost")
+		template.DNSNames = c.names
+		leaf := newTestCert(t, template, testKey.Public(), nil, testKey)
+		grade, output, err := scanChain("RegistrableDomains", nil, testKey, leaf)
+		if err != nil || grade != c.grade || outputString(output) != c.output {
+			t.Fatalf("%v: expected %s (%q), got %s (%q): %v", c.names, c.grade, c.output, grade, output, err)
+		}
+	}
+}
+
+func TestChainSizeScan(t *testing.T) {
+	leaf := newTestCert(t, testTemplate("localhost"), testKey.Public(), nil, testKey)
+	small := serveChain(testKey, leaf)
+	defer small.Close()
+
+	grade, output, err := PKI.Scanners["ChainSize"].Scan(small.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := fmt.Sprintf("%d bytes in total\nlocalhost: %d bytes", len(leaf.Raw), len(leaf.Raw))
+	if grade != Good || output.String() != expected {
+		t.Fatalf("expected small chain to be Good (%q), got %s (%q)", expected, grade, output)
+	}
+
+	// Pad the chain with certificates carrying large, meaningless extensions.
+	template := testCATemplate("Bloated CA")
+	template.ExtraExtensions = []pkix.Extension{{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 2}, Value: make([]byte, 2048)}}
+	bloated := newTestCert(t, template, testKey.Public(), nil, testKey)
+	large := serveChain(testKey, leaf, bloated, bloated, bloated)
+	defer large.Close()
+
+	grade, output, err = PKI.Scanners["ChainSize"].Scan(large.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := output.(chainSize)
+	if grade != Warning || size.Total != len(leaf.Raw)+3*len(bloated.Raw) || len(size.Certificates) != 4 {
+		t.Fatalf("expected oversized chain to be flagged, got %s (%s)", grade, output)
+	}
+}
+
+func TestDANEScan(t *testing.T) {
+	rootKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	root := newTestCert(t, testCATemplate("Test Root"), rootKey.Public(), nil, rootKey)
+	leaf := newTestCert(t, testTemplate("localhost"), testKey.Public(), root, rootKey)
+	server := serveChain(testKey, leaf, root)
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	host := net.JoinHostPort("localhost", port)
+
+	leafSPKI := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+	rootCert := sha512.Sum512(root.Raw)
+	otherSPKI := sha256.Sum256(root.RawSubjectPublicKeyInfo)
+	cases := []struct {
+		records []TLSARecord
+		trusted bool
+		grade   Grade
+		output  string
+	}{
+		{nil, false, Skipped, ""},
+		{[]TLSARecord{{3, 1, 1, leafSPKI[:], true}}, false, Good, fmt.Sprintf("3 1 1 %x", leafSPKI)},
+		{[]TLSARecord{{3, 0, 0, leaf.Raw, true}}, false, Good, fmt.Sprintf("3 0 0 %x", leaf.Raw)},
+		{[]TLSARecord{{3, 1, 1, otherSPKI[:], true}, {2, 0, 2, rootCert[:], true}}, false, Good, fmt.Sprintf("2 0 2 %x", rootCert)},
+		// The leaf's key doesn't match a record meant for its issuer.
+		{[]TLSARecord{{2, 1, 1, leafSPKI[:], true}}, false, Bad, fmt.Sprintf("2 1 1 %x", leafSPKI)},
+		{[]TLSARecord{{3, 1, 1, otherSPKI[:], true}}, false, Bad, fmt.Sprintf("3 1 1 %x", otherSPKI)},
+		// Records not authenticated with DNSSEC are ignored.
+		{[]TLSARecord{{3, 1, 1, leafSPKI[:], false}}, false, Skipped, "TLSA records aren't authenticated with DNSSEC"},
+		{[]TLSARecord{{3, 1, 1, otherSPKI[:], false}, {3, 1, 1, leafSPKI[:], true}}, false, Good, fmt.Sprintf("3 1 1 %x", leafSPKI)},
+		// PKIX usages also need the chain to verify.
+		{[]TLSARecord{{1, 1, 1, leafSPKI[:], true}}, false, Bad, fmt.Sprintf("1 1 1 %x", leafSPKI)},
+		{[]TLSARecord{{1, 1, 1, leafSPKI[:], true}}, true, Good, fmt.Sprintf("1 1 1 %x", leafSPKI)},
+		{[]TLSARecord{{0, 0, 2, rootCert[:], true}}, false, Bad, fmt.Sprintf("0 0 2 %x", rootCert)},
+		{[]TLSARecord{{0, 0, 2, rootCert[:], true}}, true, Good, fmt.Sprintf("0 0 2 %x", rootCert)},
+	}
+
+	defer func(roots *x509.CertPool) { verifyRoots = roots }(verifyRoots)
+	trusted := x509.NewCertPool()
+	trusted.AddCert(root)
+	for i, c := range cases {
+		verifyRoots = x509.NewCertPool()
+		if c.trusted {
+			verifyRoots = trusted
+		}
+		resolver := stubResolver{tlsa: map[string][]TLSARecord{"_" + port + "._tcp.localhost": c.records}}
+		withResolver(resolver, func() {
+			grade, output, err := PKI.Scanners["DANE"].Scan(host)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if grade != c.grade {
+				t.Fatalf("case %d: expected %s, got %s (%v)", i, c.grade, grade, output)
+			}
+			if (output == nil) != (c.output == "") || output != nil && output.String() != c.output {
+				t.Fatalf("case %d: expected output %q, got %v", i, c.output, output)
+			}
+		})
+	}
+}
+
+func TestKeyAlgorithmScan(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, ed25519Key, _ := ed25519.GenerateKey(rand.Reader)
+	cases := []struct {
+		key    crypto.Signer
+		grade  Grade
+		output string
+	}{
+		{rsaKey, Good, "RSA"},
+		{testKey, Good, "ECDSA"},
+		{ed25519Key, Warning, "Ed25519"},
+	}
+
+	for _, c := range cases {
+		leaf := newTestCert(t, testTemplate("localhost"), c.key.Public(), nil, c.key)
+		grade, output, err := scanChain("KeyAlgorithm", nil, c.key, leaf)
+		if err != nil || grade != c.grade || outputString(output) != c.output {
+			t.Fatalf("expected %s (%s), got %s (%s): %v", c.grade, c.output, grade, output, err)
+		}
+	}
+
+	defer func(allowed map[x509.PublicKeyAlgorithm]bool) { AllowedKeyAlgorithms = allowed }(AllowedKeyAlgorithms)
+	AllowedKeyAlgorithms = map[x509.PublicKeyAlgorithm]bool{x509.Ed25519: true}
+	leaf := newTestCert(t, testTemplate("localhost"), ed25519Key.Public(), nil, ed25519Key)
+	server := serveChain(ed25519Key, leaf)
+	defer server.Close()
+	if grade, _, err := PKI.Scanners["KeyAlgorithm"].Scan(server.Listener.Addr().String()); err != nil || grade != Good {
+		t.Fatalf("expected allowed Ed25519 key to be Good, got %s: %v", grade, err)
+	}
+}
+
+// newSmallExponentKey generates an RSA key with the public exponent 3, which
+// rsa.GenerateKey won't.
+func newSmallExponentKey(t *testing.T) *rsa.PrivateKey {
+	e, one := big.NewInt(3), big.NewInt(1)
+	for {
+		p, err := rand.Prime(rand.Reader, 1024)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q, err := rand.Prime(rand.Reader, 1024)
+		if err != nil {
+			t.Fatal(err)
+		}
+		phi := new(big.Int).Mul(new(big.Int).Sub(p, one), new(big.Int).Sub(q, one))
+		d := new(big.Int).ModInverse(e, phi)
+		if d == nil || p.Cmp(q) == 0 {
+			continue
+		}
+		key := &rsa.PrivateKey{
+			PublicKey: rsa.PublicKey{N: new(big.Int).Mul(p, q), E: 3},
+			D:         d,
+			Primes:    []*big.Int{p, q},
+		}
+		key.Precompute()
+		return key
+	}
+}
+
+func TestRSAExponentScan(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	cases := []struct {
+		key    crypto.Signer
+		grade  Grade
+		output string
+	}{
+		{rsaKey, Good, "public exponent 65537"},
+		{newSmallExponentKey(t), Warning, "public exponent 3"},
+		{testKey, Skipped, ""},
+	}
+
+	for _, c := range cases {
+		leaf := newTestCert(t, testTemplate("localhost"), c.key.Public(), nil, testKey)
+		grade, output, err := scanChain("RSAExponent", nil, c.key, leaf)
+		if err != nil || grade != c.grade || outputString(output) != c.output {
+			t.Fatalf("expected %s (%q), got %s (%v): %v", c.grade, c.output, grade, output, err)
+		}
+	}
+}
+
+func TestKeyUsageCipherScan(t *testing.T) {
+	// Static RSA key exchange can't be negotiated with a crypto/tls server, so
+	// the scanner is given the negotiated suite directly.
+	cases := []struct {
+		usage  x509.KeyUsage
+		suite  uint16
+		grade  Grade
+		output string
+	}{
+		{x509.KeyUsageKeyEncipherment, tls.TLS_RSA_WITH_AES_128_GCM_SHA256, Good, "TLS_RSA_WITH_AES_128_GCM_SHA256 uses RSA key exchange, which the certificate's keyUsage permits"},
+		{x509.KeyUsageDigitalSignature, tls.TLS_RSA_WITH_AES_128_GCM_SHA256, Warning, "TLS_RSA_WITH_AES_128_GCM_SHA256 uses RSA key exchange, which needs the keyEncipherment keyUsage the certificate lacks"},
+		{x509.KeyUsageKeyEncipherment, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, Warning, "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 uses ECDHE_RSA key exchange, which needs the digitalSignature keyUsage the certificate lacks"},
+		{x509.KeyUsageDigitalSignature, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, Good, "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 uses ECDHE_ECDSA key exchange, which the certificate's keyUsage permits"},
+		{0, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, Skipped, ""},
+	}
+
+	for _, c := range cases {
+		template := testTemplate("localhost")
+		template.KeyUsage = c.usage
+		leaf := newTestCert(t, template, testKey.Public(), nil, testKey)
+		state := &cftls.ConnectionState{
+			Version:          tls.VersionTLS12,
+			CipherSuite:      c.suite,
+			PeerCertificates: []*x509.Certificate{leaf},
+		}
+		grade, output, err := keyUsageCipherScan("localhost:443", state)
+		if err != nil || grade != c.grade || outputString(output) != c.output {
+			t.Fatalf("expected %s (%q), got %s (%v): %v", c.grade, c.output, grade, output, err)
+		}
+	}
+
+	// The ECDHE suite the test server negotiates is checked the same way.
+	template := testTemplate("localhost")
+	template.KeyUsage = x509.KeyUsageKeyEncipherment
+	server := serveChain(testKey, newTestCert(t, template, testKey.Public(), nil, testKey))
+	defer server.Close()
+	if grade, output, err := PKI.Scanners["KeyUsageCipher"].Scan(server.Listener.Addr().String()); err != nil || grade != Warning {
+		t.Fatalf("expected ECDHE without digitalSignature to be Warning, got %s (%v): %v", grade, output, err)
+	}
+}
+
+func TestChainVerificationScan(t *testing.T) {
+	rootKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	root := newTestCert(t, testCATemplate("Test Root"), rootKey.Public(), nil, rootKey)
+	intermediateKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	intermediate := newTestCert(t, testCATemplate("Test Intermediate"), intermediateKey.Public(), root, rootKey)
+	template := testTemplate("localhost")
+	template.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	leaf := newTestCert(t, template, testKey.Public(), intermediate, intermediateKey)
+
+	defer func(roots *x509.CertPool) { verifyRoots = roots }(verifyRoots)
+	verifyRoots = x509.NewCertPool()
+	verifyRoots.AddCert(root)
+
+	cases := []struct {
+		description string
+		chain       []*x509.Certificate
+		grade       Grade
+	}{
+		{"ordered chain", []*x509.Certificate{leaf, intermediate}, Good},
+		{"chain with root", []*x509.Certificate{leaf, intermediate, root}, Good},
+		// Unlike a walk of the chain in the order it is sent, verification
+		// doesn't care where the intermediate appears.
+		{"misordered chain", []*x509.Certificate{leaf, root, intermediate}, Good},
+		{"missing intermediate", []*x509.Certificate{leaf}, Bad},
+	}
+
+	for _, c := range cases {
+		grade, output, err := scanChain("ChainVerification", nil, testKey, c.chain...)
+		if grade != c.grade {
+			t.Fatalf("%s: expected %s, got %s: %v", c.description, c.grade, grade, err)
+		}
+		if grade == Bad {
+			if _, ok := err.(x509.UnknownAuthorityError); !ok {
+				t.Fatalf("%s: expected unknown authority error, got %v", c.description, err)
+			}
+			if output.String() != "CN=localhost" {
+				t.Fatalf("%s: unexpected partial chain %s", c.description, output)
+			}
+			continue
+		}
+		if output.String() != "CN=localhost -> CN=Test Intermediate -> CN=Test Root" {
+			t.Fatalf("%s: unexpected chain %s", c.description, output)
+		}
+	}
+
+	// The verified chain identifies each certificate.
+	server := serveChain(testKey, leaf, intermediate)
+	defer server.Close()
+	_, output, err := PKI.Scanners["ChainVerification"].Scan(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := certChain{
+		{"CN=localhost", "CN=Test Intermediate", leaf.SerialNumber.String()},
+		{"CN=Test Intermediate", "CN=Test Root", intermediate.SerialNumber.String()},
+		{"CN=Test Root", "CN=Test Root", root.SerialNumber.String()},
+	}
+	if !reflect.DeepEqual(output, want) {
+		t.Fatalf("expected chain %+v, got %+v", want, output)
+	}
+
+	// Without the root, verification fails after building the chain up to it.
+	verifyRoots = x509.NewCertPool()
+	if _, output, _ = PKI.Scanners["ChainVerification"].Scan(server.Listener.Addr().String()); output.String() != "CN=localhost -> CN=Test Intermediate" {
+		t.Fatalf("expected partial chain up to the intermediate, got %s", output)
+	}
+}
+
+func TestIssuerOutliers(t *testing.T) {
+	rootKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	approvedCA := newTestCert(t, testCATemplate("Approved CA"), rootKey.Public(), nil, rootKey)
+	shadowCA := newTestCert(t, testCATemplate("Shadow CA"), rootKey.Public(), nil, rootKey)
+
+	var reports []HostReport
+	for _, ca := range []*x509.Certificate{approvedCA, approvedCA, shadowCA, approvedCA} {
+		leaf := newTestCert(t, testTemplate("localhost"), testKey.Public(), ca, rootKey)
+		server := serveChain(testKey, leaf, ca)
+		host := server.Listener.Addr().String()
+		results, err := Default.RunScans(host, "PKI", "Issuer")
+		server.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		reports = append(reports, HostReport{Host: host, Families: results})
+	}
+
+	outliers := IssuerOutliers(reports, []string{"Approved CA"})
+	if len(outliers) != 1 {
+		t.Fatalf("expected exactly one outlier, got %d", len(outliers))
+	}
+	outlier, ok := outliers[reports[2].Host]
+	if !ok {
+		t.Fatalf("expected %s to be flagged", reports[2].Host)
+	}
+	if outlier.Grade != Warning || outlier.Output.String() != "Shadow CA" {
+		t.Fatalf("unexpected outlier result %s (%s)", outlier.Grade, outlier.Output)
+	}
+}
+
+// testSCTListFromLogs returns a DER encoded extension value listing a dummy
+// SCT from each log, whose 32 byte ID is made of the log's byte repeated.
+func testSCTListFromLogs(logs ...byte) []byte {
+	return testSCTListAt(time.Unix(0, 0), logs...)
+}
+
+// testSCTListAt is testSCTListFromLogs with SCTs issued at the given time.
+func testSCTListAt(issued time.Time, logs ...byte) []byte {
+	var list []byte
+	for _, log := range logs {
+		sct := append([]byte{0}, bytes.Repeat([]byte{log}, 32)...)
+		timestamp := make([]byte, 8)
+		binary.BigEndian.PutUint64(timestamp, uint64(issued.UnixNano()/int64(time.Millisecond)))
+		sct = append(sct, timestamp...)
+		sct = append(sct, 0, 0)       // no extensions
+		sct = append(sct, 4, 3, 0, 0) // empty ECDSA signature
+		list = append(list, byte(len(sct)>>8), byte(len(sct)))
+		list = append(list, sct...)
+	}
+	list = append([]byte{byte(len(list) >> 8), byte(len(list))}, list...)
+	value, _ := asn1.Marshal(list)
+	return value
+}
+
+// testSCTList returns a DER encoded extension value listing n dummy SCTs from
+// distinct logs.
+func testSCTList(n int) []byte {
+	logs := make([]byte, n)
+	for i := range logs {
+		logs[i] = byte(i)
+	}
+	return testSCTListFromLogs(logs...)
+}
+
+// testLogID returns the base64 encoded ID of a log made by testSCTListFromLogs.
+func testLogID(log byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{log}, 32))
+}
+
+func TestParseSCTs(t *testing.T) {
+	for n := 0; n < 4; n++ {
+		scts, err := parseSCTs(testSCTList(n))
+		if err != nil || len(scts) != n {
+			t.Fatalf("expected %d SCTs, got %d: %v", n, len(scts), err)
+		}
+		for i, sct := range scts {
+			if logID, err := sctLogID(sct); err != nil || logID != testLogID(byte(i)) {
+				t.Fatalf("unexpected log ID %s: %v", logID, err)
+			}
+		}
+	}
+	malformed, _ := asn1.Marshal([]byte{0, 4, 0, 9, 1, 2})
+	if _, err := parseSCTs(malformed); err == nil {
+		t.Fatal("expected malformed SCT list to be rejected")
+	}
+}
+
+func TestCTMergeDelayScan(t *testing.T) {
+	search := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]ctSearchEntry{{"CN=Test CA", "0a", ""}})
+	}))
+	defer search.Close()
+	defer func(u string) { CTSearchURL = u }(CTSearchURL)
+
+	cases := []struct {
+		issued    time.Duration
+		serial    int64
+		searchURL string
+		grade     Grade
+		logged    string
+	}{
+		{0, 10, search.URL, Skipped, ""},
+		{time.Hour, 11, search.URL, Good, ""},
+		{48 * time.Hour, 11, "", Skipped, ""},
+		{48 * time.Hour, 10, search.URL, Good, ", and the certificate is logged"},
+		{48 * time.Hour, 11, search.URL, Warning, ", but the certificate isn't logged"},
+	}
+
+	for _, c := range cases {
+		CTSearchURL = c.searchURL
+		template := testTemplate("localhost")
+		template.SerialNumber = big.NewInt(c.serial)
+		if c.issued != 0 {
+			issued := time.Now().Add(-c.issued)
+			template.ExtraExtensions = []pkix.Extension{{Id: oidEmbeddedSCTList, Value: testSCTListAt(issued, 1, 2)}}
+		}
+		leaf := newTestCert(t, template, testKey.Public(), nil, testKey)
+		grade, output, err := scanChain("CTMergeDelay", nil, testKey, leaf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if grade != c.grade {
+			t.Fatalf("SCTs issued %s ago: expected %s, got %s (%v)", c.issued, c.grade, grade, output)
+		}
+		if c.issued == 0 {
+			continue
+		}
+		freshness := output.(ctFreshness)
+		if freshness.MMD != CTMaxMergeDelay || freshness.Age < c.issued-time.Second || freshness.Age > c.issued+time.Minute ||
+			!strings.HasSuffix(freshness.String(), "delay"+c.logged) {
+			t.Fatalf("unexpected freshness %s", freshness)
+		}
+	}
+}
+
+func TestSCTTimestampScan(t *testing.T) {
+	cases := []struct {
+		issued  time.Duration
+		grade   Grade
+		anomaly string
+	}{
+		{-time.Hour, Good, ""},
+		{time.Hour, Bad, "in the future"},
+		{-30 * 24 * time.Hour, Warning, "long before the certificate became valid"},
+	}
+
+	for _, c := range cases {
+		issued := time.Now().Add(c.issued).Truncate(time.Second)
+		template := testTemplate("localhost")
+		template.ExtraExtensions = []pkix.Extension{{Id: oidEmbeddedSCTList, Value: testSCTListAt(issued, 1)}}
+		leaf := newTestCert(t, template, testKey.Public(), nil, testKey)
+		grade, output, err := scanChain("SCTTimestamps", nil, testKey, leaf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := "embedded: " + issued.UTC().Format(time.RFC3339)
+		if c.anomaly != "" {
+			expected += " (" + c.anomaly + ")"
+		}
+		if grade != c.grade || output.String() != expected {
+			t.Fatalf("expected %s (%s), got %s (%s)", c.grade, expected, grade, output)
+		}
+	}
+}
+
+func TestBackdatingScan(t *testing.T) {
+	cases := []struct {
+		backdate time.Duration
+		scts     bool
+		grade    Grade
+	}{
+		{time.Hour, false, Skipped},
+		{time.Hour, true, Good},
+		{30 * 24 * time.Hour, true, Warning},
+	}
+
+	for _, c := range cases {
+		issued := time.Now().Add(-time.Hour).Truncate(time.Second)
+		template := testTemplate("localhost")
+		template.NotBefore = issued.Add(-c.backdate)
+		if c.scts {
+			template.ExtraExtensions = []pkix.Extension{{Id: oidEmbeddedSCTList, Value: testSCTListAt(issued, 1)}}
+		}
+		leaf := newTestCert(t, template, testKey.Public(), nil, testKey)
+		grade, output, err := scanChain("Backdating", nil, testKey, leaf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if grade != c.grade {
+			t.Fatalf("backdated %s: expected %s, got %s (%v)", c.backdate, c.grade, grade, output)
+		}
+		if grade == Skipped {
+			continue
+		}
+		expected := "notBefore " + template.NotBefore.UTC().Format(time.RFC3339) + ", earliest SCT " + issued.UTC().Format(time.RFC3339)
+		if output.String() != expected {
+			t.Fatalf("expected %q, got %q", expected, output)
+		}
+	}
+}
+
+func TestSCTScanOperators(t *testing.T) {
+	defer func(operators map[string]string) { CTLogOperators = operators }(CTLogOperators)
+	CTLogOperators = map[string]string{
+		testLogID(1): "Google",
+		testLogID(2): "Google",
+		testLogID(3): "Cloudflare",
+	}
+
+	cases := []struct {
+		logs   []byte
+		grade  Grade
+		output string
+	}{
+		{[]byte{1, 2}, Warning, "2 embedded in certificate; log operators: Google"},
+		{[]byte{1, 3}, Good, "2 embedded in certificate; log operators: Cloudflare, Google"},
+		{[]byte{1, 2, 3}, Good, "3 embedded in certificate; log operators: Cloudflare, Google"},
+		// A log of unknown operator may be run by another operator.
+		{[]byte{1, 9}, Good, "2 embedded in certificate; log operators: Google"},
+	}
+
+	for _, c := range cases {
+		template := testTemplate("localhost")
+		template.ExtraExtensions = []pkix.Extension{{Id: oidEmbeddedSCTList, Value: testSCTListFromLogs(c.logs...)}}
+		leaf := newTestCert(t, template, testKey.Public(), nil, testKey)
+		grade, output, err := scanChain("SignedCertificateTimestamps", nil, testKey, leaf)
+		if err != nil || grade != c.grade || outputString(output) != c.output {
+			t.Fatalf("logs %v: expected %s (%s), got %s (%s): %v", c.logs, c.grade, c.output, grade, output, err)
+		}
+	}
+}
+
+func TestSCTScan(t *testing.T) {
+	garbled := []byte{0x30, 0x03, 0x0a, 0x01}
+	_, garbledErr := ocsp.ParseResponse(garbled, nil)
+	cases := []struct {
+		embedded, tlsExtension, ocsp int
+		grade                        Grade
+		output                       string
+	}{
+		{0, 0, 0, Bad, "no SCTs"},
+		// A garbled staple is noted, and the other sources still graded.
+		{2, 0, -1, Good, "2 embedded in certificate; stapled OCSP response ignored: " + garbledErr.Error()},
+		{0, 0, 2, Good, "2 in stapled OCSP response"},
+		{0, 1, 1, Good, "1 in TLS extension, 1 in stapled OCSP response"},
+		{1, 0, 0, Warning, "1 embedded in certificate"},
+		{2, 0, 0, Good, "2 embedded in certificate"},
+	}
+
+	for _, c := range cases {
+		template := testTemplate("localhost")
+		if c.embedded > 0 {
+			template.ExtraExtensions = []pkix.Extension{{Id: oidEmbeddedSCTList, Value: testSCTList(c.embedded)}}
+		}
+		leaf := newTestCert(t, template, testKey.Public(), nil, testKey)
+		server := newChainServer(testKey, leaf)
+		cert := &server.TLS.Certificates[0]
+		for i := 0; i < c.tlsExtension; i++ {
+			cert.SignedCertificateTimestamps = append(cert.SignedCertificateTimestamps, []byte{0, 1, 2, byte(i)})
+		}
+		if c.ocsp > 0 {
+			staple, err := ocsp.CreateResponse(leaf, leaf, ocsp.Response{
+				Status:          ocsp.Good,
+				SerialNumber:    leaf.SerialNumber,
+				ThisUpdate:      time.Now().Add(-time.Hour),
+				NextUpdate:      time.Now().Add(time.Hour),
+				ExtraExtensions: []pkix.Extension{{Id: oidOCSPSCTList, Value: testSCTList(c.ocsp)}},
+			}, testKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cert.OCSPStaple = staple
+		} else if c.ocsp < 0 {
+			cert.OCSPStaple = garbled
+		}
+		server.StartTLS()
+
+		grade, output, err := PKI.Scanners["SignedCertificateTimestamps"].Scan(server.Listener.Addr().String())
+		server.Close()
+		if err != nil || grade != c.grade || outputString(output) != c.output {
+			t.Fatalf("expected %s (%s), got %s (%s): %v", c.grade, c.output, grade, output, err)
+		}
+	}
+}
+
+func TestPKIRemediation(t *testing.T) {
+	for name, scanner := range PKI.Scanners {
+		if scanner.Remediation == "" || scanner.Category == "" {
+			t.Fatalf("%s is missing its category or remediation", name)
+		}
+	}
+}
+
+func TestResultRemediation(t *testing.T) {
+	leaf := newTestCert(t, testTemplate("localhost"), testKey.Public(), nil, testKey)
+	server := serveChain(testKey, leaf)
+	defer server.Close()
+
+	defer func(keys map[string]bool) { CompromisedKeys = keys }(CompromisedKeys)
+	CompromisedKeys = map[string]bool{}
+	scanner := PKI.Scanners["CompromisedKey"]
+
+	results, err := Default.RunScans(server.Listener.Addr().String(), "PKI", "CompromisedKey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := results["PKI"]["CompromisedKey"]
+	if result.Category != scanner.Category || result.Remediation != "" || result.Reference != "" {
+		t.Fatalf("unexpected metadata for passing scan: %+v", result)
+	}
+
+	CompromisedKeys[string(certSPKIHash(leaf))] = true
+	results, err = Default.RunScans(server.Listener.Addr().String(), "PKI", "CompromisedKey")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result = results["PKI"]["CompromisedKey"]
+	if result.Grade != Bad || result.Remediation != scanner.Remediation {
+		t.Fatalf("expected failing scan to carry remediation, got %+v", result)
+	}
+
+	b, err := json.Marshal(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"remediation":`) || !strings.Contains(string(b), `"category":"Key"`) {
+		t.Fatalf("expected metadata in JSON %s", b)
+	}
+}
+
+func TestExtKeyUsageScan(t *testing.T) {
+	cases := []struct {
+		usages  []x509.ExtKeyUsage
+		unknown []asn1.ObjectIdentifier
+		grade   Grade
+		output  string
+	}{
+		{[]x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}, nil, Good, "serverAuth"},
+		{[]x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}, nil, Good, "serverAuth\nclientAuth"},
+		{[]x509.ExtKeyUsage{x509.ExtKeyUsageAny}, nil, Warning, "anyExtendedKeyUsage"},
+		{[]x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageCodeSigning}, []asn1.ObjectIdentifier{{1, 3, 6, 1, 4, 1, 99999, 3}},
+			Warning, "serverAuth\ncodeSigning\n1.3.6.1.4.1.99999.3"},
+	}
+
+	for _, c := range cases {
+		template := testTemplate("localhost")
+		template.ExtKeyUsage = c.usages
+		template.UnknownExtKeyUsage = c.unknown
+		leaf := newTestCert(t, template, testKey.Public(), nil, testKey)
+		grade, output, err := scanChain("ExtendedKeyUsage", nil, testKey, leaf)
+		if err != nil || grade != c.grade || outputString(output) != c.output {
+			t.Fatalf("expected %s (%q), got %s (%q): %v", c.grade, c.output, grade, output, err)
+		}
+	}
+}
+
+func TestRunScansSharedHandshakeError(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := l.Addr().String()
+	l.Close()
+
+	logger := new(capturingLogger)
+	defer func(l Logger) { ScanLogger = l }(ScanLogger)
+	ScanLogger = logger
+
+	var ran bool
+	fs := FamilySet{"PKI": PKI, "Other": &Family{
+		Description: "Doesn't need a connection",
+		Scanners: map[string]*Scanner{
+			"Offline": {
+				Description: "Succeeds without connecting",
+				scan: func(host string) (Grade, Output, error) {
+					ran = true
+					return Good, nil, nil
+				},
+			},
+		},
+	}}
+	results, err := fs.RunScans(host, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ran || results["Other"]["Offline"].Grade != Good {
+		t.Fatal("expected scanner not needing a connection to run")
+	}
+
+	// The error is reported once, by the first scanner by name, and the
+	// others point to it.
+	var names []string
+	for name, scanner := range PKI.Scanners {
+		if scanner.scanState != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		result := results["PKI"][name]
+		if result.Grade != Skipped {
+			t.Fatalf("%s: expected Skipped, got %s", name, result.Grade)
+		}
+		if _, ok := result.Error.(*HandshakeError); ok != (i == 0) {
+			t.Fatalf("%s: expected only PKI/%s to carry the handshake error, got %v", name, names[0], result.Error)
+		}
+		if i > 0 && result.Output != handshakeFailed("PKI/"+names[0]) {
+			t.Fatalf("%s: unexpected output %v", name, result.Output)
+		}
+	}
+
+	var warnings int
+	for _, line := range *logger {
+		if strings.HasPrefix(line, "WARNING") {
+			warnings++
+		}
+	}
+	if warnings != 1 {
+		t.Fatalf("expected a single warning for the failed handshake, got %d:\n%s", warnings, strings.Join(*logger, "\n"))
+	}
+}
+
+func TestReusedKeys(t *testing.T) {
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	var reports []HostReport
+	for _, key := range []*ecdsa.PrivateKey{testKey, otherKey, testKey} {
+		leaf := newTestCert(t, testTemplate("localhost"), key.Public(), nil, key)
+		server := serveChain(key, leaf)
+		host := server.Listener.Addr().String()
+		results, err := Default.RunScans(host, "PKI", "CompromisedKey")
+		server.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		reports = append(reports, HostReport{Host: host, Families: results})
+	}
+
+	reused := ReusedKeys(reports)
+	if len(reused) != 1 {
+		t.Fatalf("expected exactly one reused key, got %d", len(reused))
+	}
+	leaf := newTestCert(t, testTemplate("localhost"), testKey.Public(), nil, testKey)
+	result, ok := reused[string(certSPKIHash(leaf))]
+	if !ok {
+		t.Fatal("expected the shared key to be flagged")
+	}
+	hosts := []string{reports[0].Host, reports[2].Host}
+	sort.Strings(hosts)
+	if result.Grade != Warning || result.Output.String() != strings.Join(hosts, "\n") {
+		t.Fatalf("unexpected result %s (%s)", result.Grade, result.Output)
+	}
+}
+
+func TestWildcardBreadth(t *testing.T) {
+	cases := []struct {
+		name  string
+		grade Grade
+	}{
+		{"example.com", Good},
+		{"*.example.com", Good},
+		{"*.example.co.uk", Good},
+		{"*.com", Bad},
+		{"*.co.uk", Bad},
+		{"*.CO.UK", Bad},
+		{"*.github.io", Warning},
+	}
+	for _, c := range cases {
+		if grade := wildcardBreadth(c.name); grade != c.grade {
+			t.Fatalf("%s: expected %s, got %s", c.name, c.grade, grade)
+		}
+	}
+}
+
+func TestBroadWildcardScan(t *testing.T) {
+	cases := []struct {
+		names  []string
+		grade  Grade
+		output string
+	}{
+		{[]string{"example.com", "*.example.com"}, Good, ""},
+		{[]string{"example.co.uk", "*.co.uk"}, Bad, "*.co.uk"},
+	}
+
+	for _, c := range cases {
+		template := testTemplate("localhost")
+		template.DNSNames = c.names
+		leaf := newTestCert(t, template, testKey.Public(), nil, testKey)
+		grade, output, err := scanChain("BroadWildcard", nil, testKey, leaf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if grade != c.grade {
+			t.Fatalf("%v: expected %s, got %s", c.names, c.grade, grade)
+		}
+		if (output == nil) != (c.output == "") || output != nil && output.String() != c.output {
+			t.Fatalf("%v: expected output %q, got %v", c.names, c.output, output)
+		}
+	}
+}
+
+func TestProbeOnce(t *testing.T) {
+	template := testTemplate("localhost")
+	template.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	leaf := newTestCert(t, template, testKey.Public(), nil, testKey)
+	defer func(roots *x509.CertPool) { verifyRoots = roots }(verifyRoots)
+	verifyRoots = x509.NewCertPool()
+	verifyRoots.AddCert(leaf)
+
+	server := newChainServer(testKey, leaf)
+	var conns int32
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	server.StartTLS()
+	defer server.Close()
+	host := server.Listener.Addr().String()
+
+	report := Default.ProbeOnce(host)
+	if n := atomic.LoadInt32(&conns); n != 1 {
+		t.Fatalf("expected exactly one connection, got %d", n)
+	}
+	if report.Host != host {
+		t.Fatalf("expected report for %s, got %s", host, report.Host)
+	}
+
+	for familyName, family := range Default {
+		for scannerName, scanner := range family.Scanners {
+			result, ok := report.Families[familyName][scannerName]
+			if !ok {
+				t.Fatalf("%s/%s missing from report", familyName, scannerName)
+			}
+			if scanner.scanState == nil && (result.Grade != Skipped || result.Error != nil || result.Output != (needsConnection{})) {
+				t.Fatalf("expected %s/%s to be skipped, got %s (%v): %v", familyName, scannerName, result.Grade, result.Output, result.Error)
+			}
+			if scanner.scanState != nil && result.Error != nil {
+				t.Fatalf("%s/%s failed: %v", familyName, scannerName, result.Error)
+			}
+		}
+	}
+	if code := SummaryExitCode(report); code == 3 {
+		t.Fatal("expected scanners skipped in probe-once mode not to fail the exit code")
+	}
+}
+
+func TestDeprecatedExtensionScan(t *testing.T) {
+	netscapeCertType := pkix.Extension{Id: asn1.ObjectIdentifier{2, 16, 840, 1, 113730, 1, 1}, Value: []byte{3, 2, 6, 64}}
+	unknownCritical := pkix.Extension{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 1}, Critical: true, Value: []byte{5, 0}}
+
+	cases := []struct {
+		extensions []pkix.Extension
+		grade      Grade
+		output     string
+	}{
+		{nil, Good, ""},
+		{[]pkix.Extension{netscapeCertType}, Warning, "2.16.840.1.113730.1.1 (Netscape Certificate Type)"},
+		{[]pkix.Extension{netscapeCertType, unknownCritical}, Bad,
+			"2.16.840.1.113730.1.1 (Netscape Certificate Type)\n1.3.6.1.4.1.99999.1, critical"},
+	}
+
+	for _, c := range cases {
+		template := testTemplate("localhost")
+		template.ExtraExtensions = c.extensions
+		leaf := newTestCert(t, template, testKey.Public(), nil, testKey)
+		grade, output, err := scanChain("DeprecatedExtensions", nil, testKey, leaf)
+		if err != nil || grade != c.grade || outputString(output) != c.output {
+			t.Fatalf("expected %s (%q), got %s (%q): %v", c.grade, c.output, grade, output, err)
+		}
+	}
+}
+
+func TestECDSACurveScan(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	p384Key, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	cases := []struct {
+		key    crypto.Signer
+		grade  Grade
+		output string
+	}{
+		{testKey, Good, "P-256"},
+		{p384Key, Good, "P-384"},
+		{rsaKey, Skipped, ""},
+	}
+
+	for _, c := range cases {
+		leaf := newTestCert(t, testTemplate("localhost"), c.key.Public(), nil, c.key)
+		grade, output, err := scanChain("ECDSACurve", nil, c.key, leaf)
+		if err != nil || grade != c.grade || outputString(output) != c.output {
+			t.Fatalf("expected %s (%s), got %s (%v): %v", c.grade, c.output, grade, output, err)
+		}
+	}
+
+	// TLS servers can't sign handshakes with P-224 keys, so its grade is
+	// checked directly.
+	if grade := ecdsaCurveGrade(elliptic.P224()); grade != Warning {
+		t.Fatalf("expected P-224 to be graded Warning, got %s", grade)
+	}
+	if grade := ecdsaCurveGrade(&elliptic.CurveParams{Name: "brainpoolP256r1"}); grade != Bad {
+		t.Fatalf("expected non-standard curve to be graded Bad, got %s", grade)
+	}
+}
+
+func TestChainValidityScan(t *testing.T) {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	shortCA := testCATemplate("Short CA")
+	shortCA.NotAfter = time.Now().Add(30 * 24 * time.Hour)
+	lateCA := testCATemplate("Late CA")
+	lateCA.NotBefore = time.Now()
+
+	cases := []struct {
+		ca     *x509.Certificate
+		grade  Grade
+		output string
+	}{
+		{testCATemplate("Test CA"), Good, ""},
+		{shortCA, Bad, "Short CA expires at"},
+		{lateCA, Warning, "Late CA becomes valid at"},
+	}
+
+	for _, c := range cases {
+		ca := newTestCert(t, c.ca, caKey.Public(), nil, caKey)
+		leaf := newTestCert(t, testTemplate("localhost"), testKey.Public(), ca, caKey)
+		grade, output, err := scanChain("ChainValidity", nil, testKey, leaf, ca)
+		if out := outputString(output); err != nil || grade != c.grade || !strings.HasPrefix(out, c.output) || (out == "") != (c.output == "") {
+			t.Fatalf("%s: expected %s (%q), got %s (%q): %v", certName(ca), c.grade, c.output, grade, output, err)
+		}
+	}
+}
+
+func TestExpiredRootScan(t *testing.T) {
+	rootKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	validRoot := newTestCert(t, testCATemplate("Valid Root"), rootKey.Public(), nil, rootKey)
+	expiredTemplate := testCATemplate("Expired Root")
+	expiredTemplate.NotBefore = time.Now().Add(-10 * 365 * 24 * time.Hour)
+	expiredTemplate.NotAfter = time.Now().Add(-24 * time.Hour)
+	expiredRoot := newTestCert(t, expiredTemplate, rootKey.Public(), nil, rootKey)
+	intermediateKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	intermediate := newTestCert(t, testCATemplate("Test Intermediate"), intermediateKey.Public(), validRoot, rootKey)
+	leaf := newTestCert(t, testTemplate("localhost"), testKey.Public(), intermediate, intermediateKey)
+
+	cases := []struct {
+		description string
+		chain       []*x509.Certificate
+		grade       Grade
+		output      string
+	}{
+		{"no root", []*x509.Certificate{leaf, intermediate}, Skipped, ""},
+		{"valid root", []*x509.Certificate{leaf, intermediate, validRoot}, Good, ""},
+		{"expired root", []*x509.Certificate{leaf, intermediate, expiredRoot}, Warning, "CN=Expired Root expired at "},
+	}
+
+	for _, c := range cases {
+		grade, output, err := scanChain("ExpiredRoot", nil, testKey, c.chain...)
+		if out := outputString(output); err != nil || grade != c.grade || !strings.HasPrefix(out, c.output) || (out == "") != (c.output == "") {
+			t.Fatalf("%s: expected %s (%q), got %s (%v): %v", c.description, c.grade, c.output, grade, output, err)
+		}
+	}
+}
+
+func TestDanglingSANScan(t *testing.T) {
+	resolver := stubResolver{hosts: map[string][]string{
+		"localhost":       {"127.0.0.1"},
+		"www.example.com": {"192.0.2.1"},
+	}}
+	cases := []struct {
+		names  []string
+		grade  Grade
+		output string
+	}{
+		{[]string{"localhost", "www.example.com", "*.example.com"}, Good, ""},
+		{[]string{"localhost", "old.example.com"}, Warning, "old.example.com"},
+	}
+
+	withResolver(resolver, func() {
+		for _, c := range cases {
+			template := testTemplate("localhost")
+			template.DNSNames = c.names
+			leaf := newTestCert(t, template, testKey.Public(), nil, testKey)
+			grade, output, err := scanChain("DanglingSANs", nil, testKey, leaf)
+			if err != nil || grade != c.grade || outputString(output) != c.output {
+				t.Fatalf("%v: expected %s (%q), got %s (%q): %v", c.names, c.grade, c.output, grade, output, err)
+			}
+		}
+	})
+}
+
+func TestChainCompletionScan(t *testing.T) {
+	certs := make(map[string][]byte)
+	aia := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if der, ok := certs[r.URL.Path]; ok {
+			w.Write(der)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer aia.Close()
+
+	rootKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	root := newTestCert(t, testCATemplate("Test Root"), rootKey.Public(), nil, rootKey)
+	intermediateKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	intermediateTemplate := testCATemplate("Test Intermediate")
+	// The intermediate points back at itself, which mustn't loop forever.
+	intermediateTemplate.IssuingCertificateURL = []string{aia.URL + "/intermediate.der"}
+	intermediate := newTestCert(t, intermediateTemplate, intermediateKey.Public(), root, rootKey)
+	certs["/intermediate.der"] = intermediate.Raw
+
+	newLeaf := func(aiaPath string) *x509.Certificate {
+		template := testTemplate("localhost")
+		template.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+		if aiaPath != "" {
+			template.IssuingCertificateURL = []string{aia.URL + aiaPath}
+		}
+		return newTestCert(t, template, testKey.Public(), intermediate, intermediateKey)
+	}
+	leaf := newLeaf("/intermediate.der")
+
+	defer func(roots *x509.CertPool) { verifyRoots = roots }(verifyRoots)
+	verifyRoots = x509.NewCertPool()
+	verifyRoots.AddCert(root)
+
+	cases := []struct {
+		description string
+		chain       []*x509.Certificate
+		grade       Grade
+		output      string
+	}{
+		{"complete chain", []*x509.Certificate{leaf, intermediate}, Good,
+			"CN=localhost -> CN=Test Intermediate -> CN=Test Root"},
+		{"omitted intermediate", []*x509.Certificate{leaf}, Warning,
+			"CN=localhost -> CN=Test Intermediate -> CN=Test Root\nfetched " + aia.URL + "/intermediate.der"},
+		{"omitted intermediate without AIA", []*x509.Certificate{newLeaf("")}, Bad, "CN=localhost"},
+		{"omitted intermediate with broken AIA", []*x509.Certificate{newLeaf("/missing.der")}, Bad, "CN=localhost"},
+	}
+
+	for _, c := range cases {
+		grade, output, err := scanChain("ChainCompletion", nil, testKey, c.chain...)
+		if grade != c.grade || outputString(output) != c.output {
+			t.Fatalf("%s: expected %s (%q), got %s (%q): %v", c.description, c.grade, c.output, grade, output, err)
+		}
+		if (grade == Bad) != (err != nil) {
+			t.Fatalf("%s: unexpected error %v", c.description, err)
+		}
+	}
+
+	// Without a trusted root, fetching stops once the intermediate's AIA
+	// leads back to itself.
+	verifyRoots = x509.NewCertPool()
+	grade, output, _ := scanChain("ChainCompletion", nil, testKey, leaf)
+	if grade != Bad || outputString(output) != "CN=localhost -> CN=Test Intermediate\nfetched "+aia.URL+"/intermediate.der" {
+		t.Fatalf("expected chain up to the fetched intermediate, got %s (%q)", grade, output)
+	}
+}
+
+func TestDNSCertScan(t *testing.T) {
+	leaf := newTestCert(t, testTemplate("localhost"), testKey.Public(), nil, testKey)
+	other := newTestCert(t, testTemplate("localhost"), testKey.Public(), nil, testKey)
+	server := serveChain(testKey, leaf)
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	host := net.JoinHostPort("localhost", port)
+
+	cases := []struct {
+		records []CERTRecord
+		grade   Grade
+		output  string
+	}{
+		{nil, Skipped, ""},
+		// Only PKIX records hold X.509 certificates.
+		{[]CERTRecord{{Type: 3, Data: leaf.Raw}}, Skipped, ""},
+		{[]CERTRecord{{Type: certTypePKIX, Data: other.Raw}, {Type: certTypePKIX, Data: leaf.Raw}}, Good,
+			"certificate matches one of 2 CERT records"},
+		{[]CERTRecord{{Type: certTypePKIX, Data: other.Raw}}, Warning, "certificate matches none of 1 CERT records"},
+	}
+
+	for i, c := range cases {
+		resolver := stubResolver{cert: map[string][]CERTRecord{"localhost": c.records}}
+		withResolver(resolver, func() {
+			grade, output, err := PKI.Scanners["DNSCert"].Scan(host)
+			if err != nil || grade != c.grade || outputString(output) != c.output {
+				t.Fatalf("case %d: expected %s (%q), got %s (%v): %v", i, c.grade, c.output, grade, output, err)
+			}
+		})
+	}
+}
+
+func TestSubjectNameScan(t *testing.T) {
+	multiValued, _ := asn1.Marshal(pkix.RDNSequence{
+		{{Type: oidCommonName, Value: "localhost"}, {Type: asn1.ObjectIdentifier{2, 5, 4, 10}, Value: "Test"}},
+	})
+	twoNames, _ := asn1.Marshal(pkix.RDNSequence{
+		{{Type: oidCommonName, Value: "localhost"}},
+		{{Type: oidCommonName, Value: "example.com"}},
+	})
+
+	cases := []struct {
+		description string
+		template    func(*x509.Certificate)
+		grade       Grade
+		output      string
+	}{
+		{"normal subject", func(*x509.Certificate) {}, Good, "CN=localhost"},
+		{"empty subject with SANs", func(c *x509.Certificate) { c.Subject = pkix.Name{} }, Good, "empty subject"},
+		{"empty subject without SANs", func(c *x509.Certificate) {
+			c.Subject = pkix.Name{}
+			c.DNSNames = nil
+		}, Bad, "empty subject"},
+		{"multi-valued RDN", func(c *x509.Certificate) { c.RawSubject = multiValued }, Warning,
+			"O=Test+CN=localhost\nmulti-valued RDN O=Test+CN=localhost"},
+		{"two common names", func(c *x509.Certificate) { c.RawSubject = twoNames }, Warning,
+			"CN=example.com,CN=localhost\n2 common names"},
+	}
+
+	for _, c := range cases {
+		template := testTemplate("localhost")
+		c.template(template)
+		leaf := newTestCert(t, template, testKey.Public(), nil, testKey)
+		grade, output, err := scanChain("SubjectName", nil, testKey, leaf)
+		if err != nil || grade != c.grade || outputString(output) != c.output {
+			t.Fatalf("%s: expected %s (%q), got %s (%q): %v", c.description, c.grade, c.output, grade, output, err)
+		}
+	}
+}
+
+func TestIssuerCountryScan(t *testing.T) {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	newLeaf := func(countries ...string) *x509.Certificate {
+		template := testCATemplate("Test CA")
+		template.Subject.Country = countries
+		ca := newTestCert(t, template, caKey.Public(), nil, caKey)
+		return newTestCert(t, testTemplate("localhost"), testKey.Public(), ca, caKey)
+	}
+
+	defer func(countries []string) { AllowedIssuerCountries = countries }(AllowedIssuerCountries)
+	cases := []struct {
+		allowed []string
+		leaf    *x509.Certificate
+		grade   Grade
+		output  string
+	}{
+		{nil, newLeaf("US"), Skipped, ""},
+		{[]string{"DE", "FR"}, newLeaf("DE"), Good, "CN=localhost issued by a CA in DE"},
+		{[]string{"DE", "FR"}, newLeaf("fr"), Good, "CN=localhost issued by a CA in fr"},
+		{[]string{"DE", "FR"}, newLeaf("US"), Warning, "CN=localhost issued by a CA in US"},
+		{[]string{"DE", "FR"}, newLeaf(), Warning, "CN=localhost issued by a CA in no country"},
+	}
+
+	for i, c := range cases {
+		AllowedIssuerCountries = c.allowed
+		grade, output, err := scanChain("IssuerCountry", nil, testKey, c.leaf)
+		if err != nil || grade != c.grade || outputString(output) != c.output {
+			t.Fatalf("case %d: expected %s (%q), got %s (%v): %v", i, c.grade, c.output, grade, output, err)
+		}
+	}
+}
+
+func TestSelfSignedScan(t *testing.T) {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ca := newTestCert(t, testCATemplate("Test CA"), caKey.Public(), nil, caKey)
+	cases := []struct {
+		chain  []*x509.Certificate
+		grade  Grade
+		output string
+	}{
+		{[]*x509.Certificate{newTestCert(t, testTemplate("localhost"), testKey.Public(), nil, testKey)}, Warning,
+			"CN=localhost is self-signed"},
+		{[]*x509.Certificate{newTestCert(t, testTemplate("localhost"), testKey.Public(), ca, caKey), ca}, Good,
+			"CN=localhost is issued by a CA"},
+	}
+
+	for _, c := range cases {
+		grade, output, err := scanChain("SelfSigned", nil, testKey, c.chain...)
+		if err != nil || grade != c.grade || outputString(output) != c.output {
+			t.Fatalf("expected %s (%q), got %s (%q): %v", c.grade, c.output, grade, output, err)
+		}
+	}
+}
+
+func TestOCSPStapleScan(t *testing.T) {
+	leaf := newTestCert(t, testTemplate("localhost"), testKey.Public(), nil, testKey)
+	thisUpdate := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	nextUpdate := thisUpdate.Add(24 * time.Hour)
+	cases := []struct {
+		staple     bool
+		nextUpdate time.Time
+		grade      Grade
+		output     string
+	}{
+		{false, time.Time{}, Skipped, ""},
+		{true, nextUpdate, Good, "status good, this update " + thisUpdate.Format(time.RFC3339) +
+			", next update " + nextUpdate.Format(time.RFC3339)},
+		{true, time.Time{}, Warning, "status good, this update " + thisUpdate.Format(time.RFC3339) + ", no nextUpdate"},
+	}
+
+	for i, c := range cases {
+		var staple []byte
+		if c.staple {
+			var err error
+			if staple, err = ocsp.CreateResponse(leaf, leaf, ocsp.Response{
+				Status:       ocsp.Good,
+				SerialNumber: leaf.SerialNumber,
+				ThisUpdate:   thisUpdate,
+				NextUpdate:   c.nextUpdate,
+			}, testKey); err != nil {
+				t.Fatal(err)
+			}
+		}
+		grade, output, err := scanChain("OCSPStaple", staple, testKey, leaf)
+		if err != nil || grade != c.grade || outputString(output) != c.output {
+			t.Fatalf("case %d: expected %s (%q), got %s (%v): %v", i, c.grade, c.output, grade, output, err)
+		}
+	}
+}
+
+func TestOCSPStatusScan(t *testing.T) {
+	leaf := newTestCert(t, testTemplate("localhost"), testKey.Public(), nil, testKey)
+	revokedAt := time.Now().Add(-24 * time.Hour).UTC().Truncate(time.Second)
+	cases := []struct {
+		staple bool
+		status int
+		serial *big.Int
+		grade  Grade
+		output string
+	}{
+		{false, 0, leaf.SerialNumber, Skipped, ""},
+		{true, ocsp.Good, leaf.SerialNumber, Good, "status good"},
+		{true, ocsp.Unknown, leaf.SerialNumber, Warning, "status unknown"},
+		{true, ocsp.Revoked, leaf.SerialNumber, Bad, "status revoked at " + revokedAt.Format(time.RFC3339)},
+		{true, ocsp.Good, big.NewInt(1), Bad, "response isn't for certificate localhost"},
+	}
+
+	for i, c := range cases {
+		var staple []byte
+		if c.staple {
+			var err error
+			if staple, err = ocsp.CreateResponse(leaf, leaf, ocsp.Response{
+				Status:       c.status,
+				SerialNumber: c.serial,
+				ThisUpdate:   time.Now().Add(-time.Hour),
+				NextUpdate:   time.Now().Add(24 * time.Hour),
+				RevokedAt:    revokedAt,
+			}, testKey); err != nil {
+				t.Fatal(err)
+			}
+		}
+		grade, output, err := scanChain("OCSPStatus", staple, testKey, leaf)
+		if err != nil || grade != c.grade || outputString(output) != c.output {
+			t.Fatalf("case %d: expected %s (%q), got %s (%v): %v", i, c.grade, c.output, grade, output, err)
+		}
+	}
+}
+
+func TestOCSPStatusScanSignature(t *testing.T) {
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := newTestCert(t, testTemplate("localhost"), testKey.Public(), nil, testKey)
+	staple, err := ocsp.CreateResponse(leaf, leaf, ocsp.Response{
+		Status:       ocsp.Good,
+		SerialNumber: leaf.SerialNumber,
+		ThisUpdate:   time.Now().Add(-time.Hour),
+		NextUpdate:   time.Now().Add(24 * time.Hour),
+	}, otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = scanChain("OCSPStatus", staple, testKey, leaf); err == nil {
+		t.Fatal("expected an error for a response the issuer didn't sign")
+	}
+}
+
+func TestOCSPStapleTTLScan(t *testing.T) {
+	leaf := newTestCert(t, testTemplate("localhost"), testKey.Public(), nil, testKey)
+	thisUpdate := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	cases := []struct {
+		ttl   time.Duration
+		grade Grade
+	}{
+		{time.Hour, Warning},
+		{7 * 24 * time.Hour, Good},
+		{30 * 24 * time.Hour, Warning},
+	}
+
+	for _, c := range cases {
+		staple, err := ocsp.CreateResponse(leaf, leaf, ocsp.Response{
+			Status:       ocsp.Good,
+			SerialNumber: leaf.SerialNumber,
+			ThisUpdate:   thisUpdate,
+			NextUpdate:   thisUpdate.Add(c.ttl),
+		}, testKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		grade, output, err := scanChain("OCSPStapleTTL", staple, testKey, leaf)
+		if err != nil || grade != c.grade || outputString(output) != c.ttl.String() {
+			t.Fatalf("expected %s (%s), got %s (%v): %v", c.grade, c.ttl, grade, output, err)
+		}
+	}
+}
+
+func TestOCSPResponderScan(t *testing.T) {
+	rootKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	responderKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	root := newTestCert(t, testCATemplate("Test Root"), rootKey.Public(), nil, rootKey)
+	leaf := newTestCert(t, testTemplate("localhost"), testKey.Public(), root, rootKey)
+	delegated := testTemplate("Test OCSP Responder")
+	delegated.DNSNames = nil
+	delegated.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning}
+	cases := []struct {
+		responder *x509.Certificate
+		key       crypto.Signer
+		grade     Grade
+		output    string
+	}{
+		{nil, rootKey, Good, "issuer Test Root is authorized"},
+		{newTestCert(t, delegated, responderKey.Public(), root, rootKey), responderKey, Good, "delegated responder Test OCSP Responder is authorized"},
+		{leaf, testKey, Bad, "delegated responder localhost isn't authorized: certificate lacks the OCSP signing extended key usage"},
+		{newTestCert(t, delegated, responderKey.Public(), nil, responderKey), responderKey, Bad,
+			"delegated responder Test OCSP Responder isn't authorized: certificate isn't signed by issuer Test Root"},
+	}
+
+	for i, c := range cases {
+		staple, err := ocsp.CreateResponse(root, root, ocsp.Response{
+			Status:       ocsp.Good,
+			SerialNumber: leaf.SerialNumber,
+			ThisUpdate:   time.Now().Add(-time.Hour),
+			NextUpdate:   time.Now().Add(24 * time.Hour),
+			Certificate:  c.responder,
+		}, c.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		grade, output, err := scanChain("OCSPResponder", staple, testKey, leaf, root)
+		if err != nil || grade != c.grade || outputString(output) != c.output {
+			t.Fatalf("case %d: expected %s (%q), got %s (%v): %v", i, c.grade, c.output, grade, output, err)
+		}
+	}
+}
+
+func TestFetchAIA(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		size := maxAIACertSize
+		if r.URL.Path == "/large" {
+			size++
+		}
+		w.Write(make([]byte, size))
+	}))
+	defer server.Close()
+
+	if data, _, err := fetchAIA(server.URL + "/cert"); err != nil || int64(len(data)) != maxAIACertSize {
+		t.Fatalf("expected %d bytes, got %d: %v", maxAIACertSize, len(data), err)
+	}
+	if _, _, err := fetchAIA(server.URL + "/large"); err == nil {
+		t.Fatal("expected an oversized response to fail")
+	}
+	if _, _, err := fetchAIA("ldap://ldap.example.com/cn=Issuing%20CA"); err == nil {
+		t.Fatal("expected an ldap URL to be refused")
+	}
+}
+
+func TestAIAFormatScan(t *testing.T) {
+	root := newTestCert(t, testCATemplate("Test Root"), testKey.Public(), nil, testKey)
+	rootPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.Raw})
+	aia := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/root.der":
+			w.Header().Set("Content-Type", "application/pkix-cert")
+			w.Write(root.Raw)
+		case "/untyped.der":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(root.Raw)
+		case "/root.pem":
+			w.Header().Set("Content-Type", "application/x-pem-file")
+			w.Write(rootPEM)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer aia.Close()
+
+	cases := []struct {
+		path   string
+		grade  Grade
+		output string
+	}{
+		{"", Skipped, ""},
+		{"/root.der", Good, "/root.der: DER certificate as application/pkix-cert"},
+		{"/untyped.der", Warning, "/untyped.der: DER certificate as application/octet-stream"},
+		{"/root.pem", Warning, "/root.pem: PEM CERTIFICATE as application/x-pem-file"},
+		{"/missing.der", Warning, "/missing.der: nothing (fetching " + aia.URL + "/missing.der: 404 Not Found)"},
+	}
+
+	for _, c := range cases {
+		template := testTemplate("localhost")
+		if c.path != "" {
+			template.IssuingCertificateURL = []string{aia.URL + c.path}
+		}
+		leaf := newTestCert(t, template, testKey.Public(), root, testKey)
+		grade, output, err := scanChain("AIAFormat", nil, testKey, leaf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := c.output
+		if expected != "" {
+			expected = aia.URL + expected
+		}
+		if grade != c.grade || (output == nil) != (expected == "") || output != nil && output.String() != expected {
+			t.Fatalf("%s: expected %s (%q), got %s (%v)", c.path, c.grade, expected, grade, output)
+		}
+	}
+}
+
+func TestBackendCertsScan(t *testing.T) {
+	renewed := newTestCert(t, testTemplate("backends.test"), testKey.Public(), nil, testKey)
+	old := testTemplate("backends.test")
+	old.NotAfter = time.Now().Add(24 * time.Hour)
+	zombie := newTestCert(t, old, testKey.Public(), nil, testKey)
+
+	// Backends share a port on different loopback addresses.
+	first := serveChain(testKey, renewed)
+	defer first.Close()
+	_, port, _ := net.SplitHostPort(first.Listener.Addr().String())
+	l, err := net.Listen("tcp", net.JoinHostPort("127.0.0.2", port))
+	if err != nil {
+		t.Skipf("can't listen on a second loopback address: %v", err)
+	}
+	second := newChainServer(testKey, zombie)
+	second.Listener.Close()
+	second.Listener = l
+	second.StartTLS()
+	defer second.Close()
+	host := net.JoinHostPort("backends.test", port)
+
+	cases := []struct {
+		addrs []string
+		grade Grade
+		certs int
+	}{
+		{[]string{"127.0.0.1"}, Skipped, 0},
+		{[]string{"127.0.0.1", "127.0.0.1"}, Good, 2},
+		{[]string{"127.0.0.1", "127.0.0.2"}, Warning, 2},
+		// Nothing listens on 127.0.0.3, so only one backend or none answers.
+		{[]string{"127.0.0.1", "127.0.0.3"}, Skipped, 2},
+		{[]string{"127.0.0.3", "127.0.0.3"}, Skipped, 2},
+	}
+	for _, c := range cases {
+		withResolver(stubResolver{hosts: map[string][]string{"backends.test": c.addrs}}, func() {
+			grade, output, err := backendCertsScan(host)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if grade != c.grade {
+				t.Fatalf("%v: expected %s, got %s (%v)", c.addrs, c.grade, grade, output)
+			}
+			if c.certs == 0 {
+				return
+			}
+			certs := output.(backendCerts)
+			if len(certs) != c.certs {
+				t.Fatalf("%v: expected %d certificates, got %v", c.addrs, c.certs, certs)
+			}
+			if c.grade == Skipped && certs[len(certs)-1].Error == "" {
+				t.Fatalf("%v: expected the failing backend's error in the output: %v", c.addrs, certs)
+			}
+			if c.grade == Warning && !certs[1].NotAfter.Before(certs[0].NotAfter) {
+				t.Fatalf("%v: expected the second backend's certificate to expire first: %v", c.addrs, certs)
+			}
+		})
+	}
+}
+
+func TestEKUChainingScan(t *testing.T) {
+	rootKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	root := newTestCert(t, testCATemplate("Test Root"), rootKey.Public(), nil, rootKey)
+	cases := []struct {
+		usages []x509.ExtKeyUsage
+		grade  Grade
+		output string
+	}{
+		{nil, Good, ""},
+		{[]x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}, Good,
+			"Test Intermediate: serverAuth, clientAuth"},
+		{[]x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageEmailProtection}, Bad,
+			"Test Intermediate: clientAuth, emailProtection (lacks serverAuth)"},
+	}
+
+	for _, c := range cases {
+		template := testCATemplate("Test Intermediate")
+		template.ExtKeyUsage = c.usages
+		intermediate := newTestCert(t, template, testKey.Public(), root, rootKey)
+		leaf := newTestCert(t, testTemplate("localhost"), testKey.Public(), intermediate, testKey)
+		grade, output, err := scanChain("EKUChaining", nil, testKey, leaf, intermediate)
+		if err != nil || grade != c.grade || outputString(output) != c.output {
+			t.Fatalf("%v: expected %s (%q), got %s (%v): %v", c.usages, c.grade, c.output, grade, output, err)
+		}
+	}
+}
+
+func TestCTIssuanceScan(t *testing.T) {
+	recent := time.Now().Add(-24 * time.Hour).UTC().Format("2006-01-02T15:04:05")
+	old := time.Now().Add(-365 * 24 * time.Hour).UTC().Format("2006-01-02T15:04:05")
+	entries := []ctSearchEntry{
+		{"C=US, O=Let's Encrypt, CN=R3", "01", recent},
+		// The precertificate of the same certificate.
+		{"C=US, O=Let's Encrypt, CN=R3", "01", recent},
+		{"C=US, O=Let's Encrypt, CN=R3", "02", recent},
+		{"C=US, O=Let's Encrypt, CN=R3", "03", old},
+	}
+	search := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("q") != "example.com" || r.URL.Query().Get("output") != "json" {
+			http.Error(w, "unexpected query", http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(entries)
+	}))
+	defer search.Close()
+
+	if grade, _, err := ctIssuanceScan("example.com:443"); grade != Skipped || err != nil {
+		t.Fatalf("expected Skipped without CTSearchURL, got %s: %v", grade, err)
+	}
+
+	defer func(u string) { CTSearchURL = u }(CTSearchURL)
+	defer func(issuers []string) { ExpectedIssuers = issuers }(ExpectedIssuers)
+	defer func(limit int) { CTIssuanceLimit = limit }(CTIssuanceLimit)
+	CTSearchURL = search.URL
+	ExpectedIssuers = []string{"R3"}
+
+	grade, output, err := ctIssuanceScan("example.com:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grade != Good || output.String() != "2 certificates issued in the last 30 days: R3 (2)" {
+		t.Fatalf("expected Good, got %s (%v)", grade, output)
+	}
+
+	CTIssuanceLimit = 1
+	if grade, output, err = ctIssuanceScan("example.com:443"); err != nil || grade != Warning {
+		t.Fatalf("expected Warning for issuance beyond the limit, got %s (%v): %v", grade, output, err)
+	}
+
+	CTIssuanceLimit = 10
+	entries = append(entries, ctSearchEntry{"O=Shadow IT, CN=Shadow CA", "04", recent})
+	grade, output, err = ctIssuanceScan("example.com:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "3 certificates issued in the last 30 days: R3 (2), Shadow CA (1); unexpected issuers: Shadow CA"
+	if grade != Warning || output.String() != expected {
+		t.Fatalf("expected Warning (%q), got %s (%v)", expected, grade, output)
+	}
+}
+
+func TestChainAlgorithmsScan(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecdsaKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ecdsaCA := newTestCert(t, testCATemplate("ECDSA CA"), ecdsaKey.Public(), nil, ecdsaKey)
+	rsaCA := newTestCert(t, testCATemplate("RSA CA"), rsaKey.Public(), nil, rsaKey)
+
+	cases := []struct {
+		ca     *x509.Certificate
+		caKey  crypto.Signer
+		grade  Grade
+		output string
+	}{
+		{ecdsaCA, ecdsaKey, Good, "localhost: ECDSA key, signed with ECDSA-SHA256\nECDSA CA: ECDSA key, signed with ECDSA-SHA256"},
+		{rsaCA, rsaKey, Warning, "localhost: ECDSA key, signed with SHA256-RSA\nRSA CA: RSA key, signed with SHA256-RSA"},
+	}
+	for _, c := range cases {
+		leaf := newTestCert(t, testTemplate("localhost"), testKey.Public(), c.ca, c.caKey)
+		grade, output, err := scanChain("ChainAlgorithms", nil, testKey, leaf, c.ca)
+		if err != nil || grade != c.grade || outputString(output) != c.output {
+			t.Fatalf("expected %s (%q), got %s (%q): %v", c.grade, c.output, grade, output, err)
+		}
+	}
+}
+
+func TestCertParseScan(t *testing.T) {
+	longSerial := testTemplate("localhost")
+	longSerial.SerialNumber = new(big.Int).Lsh(big.NewInt(1), 8*21)
+	// Twenty octets of magnitude, but the high bit needs a sign octet.
+	signedSerial := testTemplate("localhost")
+	signedSerial.SerialNumber = new(big.Int).Lsh(big.NewInt(1), 8*20-1)
+	malformed := testTemplate("localhost")
+	// A private extension whose OCTET STRING is truncated, which crypto/x509
+	// tolerates since it doesn't decode the extension.
+	malformed.ExtraExtensions = []pkix.Extension{{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 1}, Value: []byte{0x04, 0x05, 1, 2}}}
+
+	cases := []struct {
+		template *x509.Certificate
+		grade    Grade
+		output   string
+	}{
+		{testTemplate("localhost"), Good, "localhost: parses cleanly"},
+		{longSerial, Warning, "localhost: serial number is longer than 20 octets"},
+		{signedSerial, Warning, "localhost: serial number is longer than 20 octets"},
+		{malformed, Bad, "localhost: extension 1.3.6.1.4.1.99999.1 isn't well-formed DER"},
+	}
+	for _, c := range cases {
+		leaf := newTestCert(t, c.template, testKey.Public(), nil, testKey)
+		grade, output, err := scanChain("CertParsing", nil, testKey, leaf)
+		if err != nil || grade != c.grade || outputString(output) != c.output {
+			t.Fatalf("expected %s (%q), got %s (%q): %v", c.grade, c.output, grade, output, err)
+		}
+	}
+}
+
+func TestTBSFailures(t *testing.T) {
+	// crypto/x509 never produces these encodings, so build a TBSCertificate
+	// by hand with an explicit v1, a GeneralizedTime before 2050, and an
+	// extension encoding critical FALSE.
+	type extension struct {
+		ID       asn1.ObjectIdentifier
+		Critical bool
+		Value    []byte
+	}
+	tbs, err := asn1.Marshal(struct {
+		Version    int `asn1:"explicit,tag:0"`
+		Serial     *big.Int
+		Algorithm  pkix.AlgorithmIdentifier
+		Issuer     asn1.RawValue
+		Validity   struct{ NotBefore, NotAfter asn1.RawValue }
+		Subject    asn1.RawValue
+		PublicKey  asn1.RawValue
+		Extensions []extension `asn1:"explicit,tag:3"`
+	}{
+		Serial:    big.NewInt(1),
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}},
+		Issuer:    asn1.RawValue{Tag: asn1.TagSequence, IsCompound: true},
+		Validity: struct{ NotBefore, NotAfter asn1.RawValue }{
+			asn1.RawValue{Tag: asn1.TagUTCTime, Bytes: []byte("300101000000Z")},
+			asn1.RawValue{Tag: asn1.TagGeneralizedTime, Bytes: []byte("20310101000000Z")},
+		},
+		Subject:    asn1.RawValue{Tag: asn1.TagSequence, IsCompound: true},
+		PublicKey:  asn1.RawValue{Tag: asn1.TagSequence, IsCompound: true},
+		Extensions: []extension{{asn1.ObjectIdentifier{2, 5, 29, 15}, false, []byte{3, 2, 7, 128}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	failures, serialLength := tbsFailures(tbs)
+	expected := []string{
+		"version 1 is encoded explicitly, though DER omits defaults",
+		`validity time "20310101000000Z" is GeneralizedTime before 2050`,
+		"extension 2.5.29.15 encodes critical FALSE, though DER omits defaults",
+	}
+	if !reflect.DeepEqual(failures, expected) || serialLength != 1 {
+		t.Fatalf("expected %q and a 1-octet serial, got %q and %d", expected, failures, serialLength)
+	}
+}
+
+func TestLifetimeEdgeScan(t *testing.T) {
+	cases := []struct {
+		days  int
+		grade Grade
+	}{
+		{90, Good},
+		{397, Good},
+		{398, Notice},
+		{825, Bad},
+	}
+	for _, c := range cases {
+		template := testTemplate("localhost")
+		// Lifetimes include the last second.
+		template.NotAfter = template.NotBefore.Add(time.Duration(c.days)*24*time.Hour - time.Second)
+		grade, output, err := scanChain("LifetimeEdge", nil, testKey, newTestCert(t, template, testKey.Public(), nil, testKey))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := fmt.Sprintf("%d.0 days", c.days); grade != c.grade || output.String() != expected {
+			t.Fatalf("expected %s (%s), got %s (%s)", c.grade, expected, grade, output)
+		}
+	}
+}
+
+func TestCommonNameOnlyScan(t *testing.T) {
+	cases := []struct {
+		cn    string
+		sans  bool
+		email bool
+		grade Grade
+	}{
+		{"127.0.0.1", true, false, Good},
+		{"127.0.0.1", false, false, Bad},
+		// An email SAN doesn't name the host.
+		{"127.0.0.1", false, true, Bad},
+		{"example.com", false, false, Skipped},
+	}
+
+	for _, c := range cases {
+		template := testTemplate(c.cn)
+		if !c.sans {
+			template.DNSNames = nil
+		}
+		if c.email {
+			template.EmailAddresses = []string{"admin@example.com"}
+		}
+		leaf := newTestCert(t, template, testKey.Public(), nil, testKey)
+		grade, output, err := scanChain("CommonNameOnly", nil, testKey, leaf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if grade != c.grade {
+			t.Fatalf("CN %s with SANs %t and email %t: expected %s, got %s (%v)", c.cn, c.sans, c.email, c.grade, grade, output)
+		}
+		if grade == Bad && output.String() != "host named only by CN=127.0.0.1 in a certificate without DNS or IP subject alternative names" {
+			t.Fatalf("unexpected output: %s", output)
+		}
+	}
+}
+
+func TestMatchesCommonName(t *testing.T) {
+	cases := []struct {
+		cn, hostname string
+		matches      bool
+	}{
+		{"example.com", "EXAMPLE.com", true},
+		{"example.com.", "example.com", true},
+		{"*.example.com", "www.example.com", true},
+		{"*.example.com", "example.com", false},
+		{"*.example.com", "a.b.example.com", false},
+		{"example.com", "example.org", false},
+	}
+	for _, c := range cases {
+		if matchesCommonName(c.cn, c.hostname) != c.matches {
+			t.Fatalf("CN %s, host %s: expected match %t", c.cn, c.hostname, c.matches)
+		}
+	}
+}
+
+func TestCTIssuerPolicyScan(t *testing.T) {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ca := newTestCert(t, testCATemplate("Test CA"), caKey.Public(), nil, caKey)
+	newLeaf := func(scts int) *x509.Certificate {
+		template := testTemplate("localhost")
+		if scts > 0 {
+			template.ExtraExtensions = []pkix.Extension{{Id: oidEmbeddedSCTList, Value: testSCTList(scts)}}
+		}
+		return newTestCert(t, template, testKey.Public(), ca, caKey)
+	}
+
+	defer func(issuers []string) { CTEnforcedIssuers = issuers }(CTEnforcedIssuers)
+	cases := []struct {
+		enforced []string
+		leaf     *x509.Certificate
+		grade    Grade
+		output   string
+	}{
+		{nil, newLeaf(0), Skipped, ""},
+		{[]string{"Other CA"}, newLeaf(0), Skipped, "issuer Test CA not subject to CT enforcement, 0 SCTs"},
+		{[]string{"Test CA"}, newLeaf(0), Warning, "issuer Test CA subject to CT enforcement, 0 SCTs"},
+		{[]string{"Test CA"}, newLeaf(1), Warning, "issuer Test CA subject to CT enforcement, 1 SCTs"},
+		{[]string{"Test CA"}, newLeaf(2), Good, "issuer Test CA subject to CT enforcement, 2 SCTs"},
+	}
+
+	for i, c := range cases {
+		CTEnforcedIssuers = c.enforced
+		grade, output, err := scanChain("CTIssuerPolicy", nil, testKey, c.leaf)
+		if err != nil || grade != c.grade || outputString(output) != c.output {
+			t.Fatalf("case %d: expected %s (%q), got %s (%v): %v", i, c.grade, c.output, grade, output, err)
+		}
+	}
+}
+
+func TestApexCoverageScan(t *testing.T) {
+	cases := []struct {
+		host   string
+		names  []string
+		grade  Grade
+		output string
+	}{
+		{"www.example.com", []string{"example.com", "www.example.com"}, Good, "covers example.com, www.example.com"},
+		{"example.com", []string{"example.com", "*.example.com"}, Good, "covers example.com, www.example.com"},
+		{"www.example.com", []string{"*.example.com"}, Warning, "covers www.example.com; missing example.com"},
+		{"example.com", []string{"example.com"}, Warning, "covers example.com; missing www.example.com"},
+		{"www.example.co.uk", []string{"api.example.co.uk"}, Warning, "covers neither name; missing example.co.uk, www.example.co.uk"},
+		{"api.example.com", []string{"*.example.com"}, Skipped, ""},
+		{"127.0.0.1", []string{"localhost"}, Skipped, ""},
+	}
+
+	for _, c := range cases {
+		template := testTemplate("localhost")
+		template.DNSNames = c.names
+		leaf := newTestCert(t, template, testKey.Public(), nil, testKey)
+		server := serveChain(testKey, leaf)
+		state, err := connectionState(server.Listener.Addr().String())
+		server.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		grade, output, err := apexCoverageScan(net.JoinHostPort(c.host, "443"), state)
+		if err != nil || grade != c.grade || outputString(output) != c.output {
+			t.Fatalf("%s with %v: expected %s (%q), got %s (%v): %v", c.host, c.names, c.grade, c.output, grade, output, err)
+		}
+	}
+}
+
+func TestRequiredNamesScanner(t *testing.T) {
+	template := testTemplate("localhost")
+	template.DNSNames = []string{"api.example.com", "*.internal.example.com"}
+	leaf := newTestCert(t, template, testKey.Public(), nil, testKey)
+	server := serveChain(testKey, leaf)
+	defer server.Close()
+
+	cases := []struct {
+		required []string
+		grade    Grade
+		output   string
+	}{
+		{[]string{"api.example.com", "admin.internal.example.com"}, Good, ""},
+		{[]string{"api.example.com", "admin.example.com", "www.example.com"}, Bad, "admin.example.com\nwww.example.com"},
+	}
+
+	for _, c := range cases {
+		scanner := NewRequiredNamesScanner(c.required...)
+		grade, output, err := scanner.Scan(server.Listener.Addr().String())
+		if err != nil || grade != c.grade || outputString(output) != c.output {
+			t.Fatalf("%v: expected %s (%q), got %s (%v): %v", c.required, c.grade, c.output, grade, output, err)
+		}
+	}
+}
+
+func TestServiceNamesScanner(t *testing.T) {
+	template := testTemplate("localhost")
+	template.DNSNames = []string{"localhost", "example.com", "*.example.com"}
+	leaf := newTestCert(t, template, testKey.Public(), nil, testKey)
+	server := serveChain(testKey, leaf)
+	defer server.Close()
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+
+	cases := []struct {
+		host   string
+		names  []string
+		grade  Grade
+		output string
+	}{
+		{"localhost", []string{"example.com", "www.example.com"}, Good, ""},
+		{"localhost", []string{"www.example.com", "example.org", "a.b.example.com"}, Bad, "example.org\na.b.example.com"},
+		{"127.0.0.1", []string{"example.com"}, Bad, "127.0.0.1"},
+	}
+
+	for _, c := range cases {
+		scanner := NewServiceNamesScanner(c.names...)
+		grade, output, err := scanner.Scan(net.JoinHostPort(c.host, port))
+		if err != nil || grade != c.grade || outputString(output) != c.output {
+			t.Fatalf("%s with %v: expected %s (%q), got %s (%v): %v", c.host, c.names, c.grade, c.output, grade, output, err)
+		}
+	}
+}
+
+func TestInterceptionScan(t *testing.T) {
+	newRoot := func(name string) (*x509.Certificate, *ecdsa.PrivateKey) {
+		rootKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		return newTestCert(t, testCATemplate(name), rootKey.Public(), nil, rootKey), rootKey
+	}
+	newChain := func(root *x509.Certificate, rootKey *ecdsa.PrivateKey) []*x509.Certificate {
+		intermediateKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		intermediate := newTestCert(t, testCATemplate("Issuing CA"), intermediateKey.Public(), root, rootKey)
+		leaf := newTestCert(t, testTemplate("localhost"), testKey.Public(), intermediate, intermediateKey)
+		return []*x509.Certificate{leaf, intermediate}
+	}
+	public, publicKey := newRoot("Public Root")
+	other, _ := newRoot("Other Public Root")
+	corporate, corporateKey := newRoot("Corporate Inspection CA")
+	// A root impersonating the public one by name, with its own key.
+	impostor, impostorKey := newRoot("Public Root")
+	untrusted, untrustedKey := newRoot("Untrusted Root")
+
+	defer func(roots *x509.CertPool) { verifyRoots = roots }(verifyRoots)
+	verifyRoots = x509.NewCertPool()
+	for _, root := range []*x509.Certificate{public, other, corporate, impostor} {
+		verifyRoots.AddCert(root)
+	}
+	defer func(roots []string) { ExpectedRoots = roots }(ExpectedRoots)
+	expected := []string{string(certSPKIHash(public)), string(certSPKIHash(other))}
+	cases := []struct {
+		expected []string
+		chain    []*x509.Certificate
+		grade    Grade
+		output   string
+	}{
+		{nil, newChain(public, publicKey), Skipped, ""},
+		{expected, newChain(public, publicKey), Good, "Public Root"},
+		{expected, newChain(corporate, corporateKey), Warning, "Corporate Inspection CA"},
+		{expected, newChain(impostor, impostorKey), Warning, "Public Root"},
+		{expected, newChain(untrusted, untrustedKey), Skipped, "Untrusted Root: x509: certificate signed by unknown authority"},
+	}
+
+	for i, c := range cases {
+		ExpectedRoots = c.expected
+		grade, output, err := scanChain("Interception", nil, testKey, c.chain...)
+		if out := outputString(output); err != nil || grade != c.grade || !strings.HasPrefix(out, c.output) || (out == "") != (c.output == "") {
+			t.Fatalf("case %d: expected %s (%q), got %s (%v): %v", i, c.grade, c.output, grade, output, err)
+		}
+	}
+}
+
+func TestCAKeySizeScan(t *testing.T) {
+	rootKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	root := newTestCert(t, testCATemplate("Test Root"), rootKey.Public(), nil, rootKey)
+	newChain := func(bits int) []*x509.Certificate {
+		intermediateKey, err := rsa.GenerateKey(rand.Reader, bits)
+		if err != nil {
+			t.Fatal(err)
+		}
+		intermediate := newTestCert(t, testCATemplate("Test Intermediate"), intermediateKey.Public(), root, rootKey)
+		leaf := newTestCert(t, testTemplate("localhost"), testKey.Public(), intermediate, intermediateKey)
+		return []*x509.Certificate{leaf, intermediate, root}
+	}
+	leaf := newTestCert(t, testTemplate("localhost"), testKey.Public(), nil, testKey)
+
+	cases := []struct {
+		chain  []*x509.Certificate
+		grade  Grade
+		output string
+	}{
+		{[]*x509.Certificate{leaf}, Skipped, ""},
+		{newChain(2048), Good, ""},
+		{newChain(1024), Bad, "Test Intermediate has a 1024 bit RSA key"},
+	}
+
+	for i, c := range cases {
+		grade, output, err := scanChain("CAKeySize", nil, testKey, c.chain...)
+		if err != nil || grade != c.grade || outputString(output) != c.output {
+			t.Fatalf("case %d: expected %s (%q), got %s (%v): %v", i, c.grade, c.output, grade, output, err)
+		}
+	}
+}
+
+func TestSKIDerivationScan(t *testing.T) {
+	der, _ := x509.MarshalPKIXPublicKey(testKey.Public())
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(der, &spki); err != nil {
+		t.Fatal(err)
+	}
+	sha1Sum := sha1.Sum(spki.PublicKey.Bytes)
+	sha256Sum := sha256.Sum256(spki.PublicKey.Bytes)
+	spkiSum := sha1.Sum(der)
+
+	cases := []struct {
+		ski    []byte
+		grade  Grade
+		method string
+	}{
+		{sha1Sum[:], Good, "SHA-1 of the public key"},
+		{sha256Sum[:20], Warning, "truncated SHA-256 of the public key"},
+		{spkiSum[:], Warning, "an unknown method"},
+	}
+
+	for _, c := range cases {
+		template := testTemplate("localhost")
+		template.SubjectKeyId = c.ski
+		leaf := newTestCert(t, template, testKey.Public(), nil, testKey)
+		grade, output, err := scanChain("SubjectKeyIdentifier", nil, testKey, leaf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := fmt.Sprintf("SKI %x derived by %s (SHA-1 %x, SHA-256 %x)", c.ski, c.method, sha1Sum, sha256Sum)
+		if grade != c.grade || output.String() != expected {
+			t.Fatalf("expected %s (%s), got %s (%s)", c.grade, expected, grade, output)
+		}
+	}
+}
+
+func TestCTPolicyScan(t *testing.T) {
+	day := 24 * time.Hour
+	cases := []struct {
+		lifetime time.Duration
+		scts     int
+		grade    Grade
+		output   string
+	}{
+		{90 * day, 2, Good, "Chrome: pass (2 of 2 embedded SCTs, 0 delivered otherwise)\n" +
+			"Apple: pass (2 of 2 embedded SCTs, 0 delivered otherwise)"},
+		{365 * day, 2, Warning, "Chrome: fail (2 of 3 embedded SCTs, 0 delivered otherwise)\n" +
+			"Apple: fail (2 of 3 embedded SCTs, 0 delivered otherwise)"},
+		{365 * day, 3, Good, "Chrome: pass (3 of 3 embedded SCTs, 0 delivered otherwise)\n" +
+			"Apple: pass (3 of 3 embedded SCTs, 0 delivered otherwise)"},
+	}
+
+	for _, c := range cases {
+		template := testTemplate("localhost")
+		template.NotAfter = template.NotBefore.Add(c.lifetime)
+		template.ExtraExtensions = []pkix.Extension{{Id: oidEmbeddedSCTList, Value: testSCTList(c.scts)}}
+		leaf := newTestCert(t, template, testKey.Public(), nil, testKey)
+		grade, output, err := scanChain("CTPolicies", nil, testKey, leaf)
+		if err != nil || grade != c.grade || outputString(output) != c.output {
+			t.Fatalf("%s lifetime with %d SCTs: expected %s (%q), got %s (%q): %v", c.lifetime, c.scts, c.grade, c.output, grade, output, err)
+		}
+	}
+}
+
+func TestCTPolicyScanCustomPolicy(t *testing.T) {
+	defer func(policies []CTPolicy) { CTPolicies = policies }(CTPolicies)
+	CTPolicies = []CTPolicy{
+		{Name: "Lenient", Lifetimes: []CTLifetimeRule{{0, 1}}, NonEmbedded: 1},
+		{Name: "Strict", Lifetimes: []CTLifetimeRule{{30 * 24 * time.Hour, 2}, {0, 4}}, NonEmbedded: 3},
+	}
+
+	template := testTemplate("localhost")
+	template.ExtraExtensions = []pkix.Extension{{Id: oidEmbeddedSCTList, Value: testSCTList(2)}}
+	leaf := newTestCert(t, template, testKey.Public(), nil, testKey)
+
+	grade, output, err := scanChain("CTPolicies", nil, testKey, leaf)
+	expected := "Lenient: pass (2 of 1 embedded SCTs, 0 delivered otherwise)\n" +
+		"Strict: fail (2 of 4 embedded SCTs, 0 delivered otherwise)"
+	if err != nil || grade != Warning || outputString(output) != expected {
+		t.Fatalf("expected Warning (%q), got %s (%q): %v", expected, grade, output, err)
+	}
+}
+
+func TestQualifiedLogScan(t *testing.T) {
+	defer func(logs map[string]bool) { QualifiedCTLogs = logs }(QualifiedCTLogs)
+	qualified := map[string]bool{testLogID(1): true, testLogID(2): true, testLogID(3): true}
+	cases := []struct {
+		qualified map[string]bool
+		logs      []byte
+		grade     Grade
+		output    string
+	}{
+		{map[string]bool{}, []byte{1, 2}, Skipped, ""},
+		{qualified, nil, Skipped, ""},
+		{qualified, []byte{1, 2}, Good, fmt.Sprintf("qualified logs: %s, %s\nretired or unknown logs: none", testLogID(1), testLogID(2))},
+		{qualified, []byte{1, 2, 9}, Good, fmt.Sprintf("qualified logs: %s, %s\nretired or unknown logs: %s", testLogID(1), testLogID(2), testLogID(9))},
+		{qualified, []byte{1, 9}, Warning, fmt.Sprintf("qualified logs: %s\nretired or unknown logs: %s", testLogID(1), testLogID(9))},
+		{qualified, []byte{8, 9}, Warning, fmt.Sprintf("qualified logs: none\nretired or unknown logs: %s, %s", testLogID(8), testLogID(9))},
+	}
+
+	for i, c := range cases {
+		QualifiedCTLogs = c.qualified
+		template := testTemplate("localhost")
+		if len(c.logs) > 0 {
+			template.ExtraExtensions = []pkix.Extension{{Id: oidEmbeddedSCTList, Value: testSCTListFromLogs(c.logs...)}}
+		}
+		leaf := newTestCert(t, template, testKey.Public(), nil, testKey)
+		grade, output, err := scanChain("QualifiedCTLogs", nil, testKey, leaf)
+		if err != nil || grade != c.grade || outputString(output) != c.output {
+			t.Fatalf("case %d: expected %s (%q), got %s (%v): %v", i, c.grade, c.output, grade, output, err)
+		}
+	}
+}
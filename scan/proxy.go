@@ -0,0 +1,90 @@
+package scan
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// ProxyProtocol, when set, makes every connection to a scanned host begin with
+// a PROXY protocol header, so that origin servers behind TLS-terminating load
+// balancers, which only accept connections announced this way, can be scanned
+// directly.
+var ProxyProtocol *ProxyHeader
+
+// ProxyHeader describes the PROXY protocol header sent ahead of each connection.
+type ProxyHeader struct {
+	// Version is the protocol version, 1 for the text header or 2 for the
+	// binary one.
+	Version int
+	// Source and Destination are the addresses announced for the client and
+	// the load balancer. When nil, the local and remote addresses of the
+	// connection are announced.
+	Source, Destination *net.TCPAddr
+}
+
+// proxyV2Signature begins every version 2 PROXY protocol header.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// marshal returns the header announcing a connection from src to dst.
+func (h *ProxyHeader) marshal(src, dst *net.TCPAddr) ([]byte, error) {
+	if h.Source != nil {
+		src = h.Source
+	}
+	if h.Destination != nil {
+		dst = h.Destination
+	}
+	src4, dst4 := src.IP.To4(), dst.IP.To4()
+	if (src4 == nil) != (dst4 == nil) {
+		return nil, errors.New("PROXY protocol source and destination must have the same address family")
+	}
+
+	switch h.Version {
+	case 1:
+		family := "TCP4"
+		if src4 == nil {
+			family = "TCP6"
+		}
+		return []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n", family, src.IP, dst.IP, src.Port, dst.Port)), nil
+	case 2:
+		b := new(bytes.Buffer)
+		b.Write(proxyV2Signature)
+		b.WriteByte(0x21) // version 2, PROXY command
+		srcIP, dstIP := []byte(src4), []byte(dst4)
+		if src4 != nil {
+			b.WriteByte(0x11) // TCP over IPv4
+		} else {
+			b.WriteByte(0x21) // TCP over IPv6
+			srcIP, dstIP = src.IP.To16(), dst.IP.To16()
+		}
+		binary.Write(b, binary.BigEndian, uint16(2*len(srcIP)+4))
+		b.Write(srcIP)
+		b.Write(dstIP)
+		binary.Write(b, binary.BigEndian, uint16(src.Port))
+		binary.Write(b, binary.BigEndian, uint16(dst.Port))
+		return b.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("unsupported PROXY protocol version %d", h.Version)
+	}
+}
+
+// sendProxyHeader writes the ProxyProtocol header, if any, to a newly
+// established TCP connection.
+func sendProxyHeader(conn net.Conn) error {
+	if ProxyProtocol == nil {
+		return nil
+	}
+	src, srcOK := conn.LocalAddr().(*net.TCPAddr)
+	dst, dstOK := conn.RemoteAddr().(*net.TCPAddr)
+	if !srcOK || !dstOK {
+		return errors.New("PROXY protocol headers can only be sent over TCP")
+	}
+	header, err := ProxyProtocol.marshal(src, dst)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(header)
+	return err
+}
@@ -0,0 +1,109 @@
+package scan
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+)
+
+// bufferedConn reads through a bufio.Reader that may already hold data read from the connection.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// serveProxied starts a TLS server that expects each connection to begin with
+// a PROXY protocol header, sending each header received on headers.
+func serveProxied(t *testing.T, version int, headers chan<- []byte) net.Listener {
+	leaf := newTestCert(t, testTemplate("localhost"), testKey.Public(), nil, testKey)
+	config := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{leaf.Raw}, PrivateKey: testKey}}}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			r := bufio.NewReader(conn)
+			var header []byte
+			if version == 1 {
+				header, err = r.ReadBytes('\n')
+			} else {
+				header = make([]byte, 16)
+				if _, err = io.ReadFull(r, header); err == nil {
+					addrs := make([]byte, int(header[14])<<8|int(header[15]))
+					_, err = io.ReadFull(r, addrs)
+					header = append(header, addrs...)
+				}
+			}
+			if err != nil {
+				conn.Close()
+				continue
+			}
+			headers <- header
+			tlsConn := tls.Server(bufferedConn{conn, r}, config)
+			tlsConn.Handshake()
+			tlsConn.Close()
+		}
+	}()
+	return l
+}
+
+func TestProxyProtocol(t *testing.T) {
+	defer func(h *ProxyHeader) { ProxyProtocol = h }(ProxyProtocol)
+	src := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 56324}
+	dst := &net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 443}
+
+	cases := []struct {
+		version int
+		header  []byte
+	}{
+		{1, []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n")},
+		{2, append(append([]byte{}, proxyV2Signature...),
+			0x21, 0x11, 0, 12,
+			192, 0, 2, 1,
+			198, 51, 100, 1,
+			0xdc, 0x04, 0x01, 0xbb)},
+	}
+
+	for _, c := range cases {
+		headers := make(chan []byte, 1)
+		l := serveProxied(t, c.version, headers)
+		ProxyProtocol = &ProxyHeader{Version: c.version, Source: src, Destination: dst}
+		grade, _, err := Connectivity.Scanners["TLSDial"].Scan(l.Addr().String())
+		l.Close()
+		if err != nil || grade != Good {
+			t.Fatalf("version %d: expected handshake behind PROXY header, got %s: %v", c.version, grade, err)
+		}
+		if header := <-headers; !bytes.Equal(header, c.header) {
+			t.Fatalf("version %d: expected header %q, got %q", c.version, c.header, header)
+		}
+	}
+
+	// By default, the connection's own addresses are announced.
+	headers := make(chan []byte, 1)
+	l := serveProxied(t, 1, headers)
+	defer l.Close()
+	ProxyProtocol = &ProxyHeader{Version: 1}
+	conn, err := dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	local, remote := conn.LocalAddr().(*net.TCPAddr), conn.RemoteAddr().(*net.TCPAddr)
+	want := []byte("PROXY TCP4 127.0.0.1 127.0.0.1 " + strconv.Itoa(local.Port) + " " + strconv.Itoa(remote.Port) + "\r\n")
+	if header := <-headers; !bytes.Equal(header, want) {
+		t.Fatalf("expected header %q, got %q", want, header)
+	}
+}
@@ -0,0 +1,160 @@
+package scan
+
+import (
+	"sort"
+)
+
+// sarifSchema is the JSON schema of SARIF 2.1.0 logs.
+const sarifSchema = "https://json.schemastore.org/sarif-2.1.0.json"
+
+// SARIFLog is a SARIF 2.1.0 log of failed scans, for security tooling that
+// ingests static analysis results.
+type SARIFLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string        `json:"id"`
+	ShortDescription sarifMessage  `json:"shortDescription"`
+	Help             *sarifMessage `json:"help,omitempty"`
+	HelpURI          string        `json:"helpUri,omitempty"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+// sarifLevel maps the grade of a failed scan to a SARIF result level.
+func sarifLevel(result ScannerResult) string {
+	if result.Grade == Bad || result.Error != nil && result.Grade != Skipped {
+		return "error"
+	}
+	if result.Grade == Notice {
+		return "note"
+	}
+	return "warning"
+}
+
+// sarifText describes a failed scan by its output and error.
+func sarifText(result ScannerResult) string {
+	text := ""
+	if result.Output != nil {
+		text = result.Output.String()
+	}
+	if result.Error != nil && result.Grade != Skipped {
+		if text != "" {
+			text += "\n"
+		}
+		text += result.Error.Error()
+	}
+	if text == "" {
+		text = "graded " + result.Grade.String()
+	}
+	return text
+}
+
+// SARIF converts the failed scans in reports into a SARIF log. Each scanner
+// graded below Good, or that failed with an error, becomes a result of the
+// rule named after it, located at the host scanned.
+func (fs FamilySet) SARIF(reports []HostReport) SARIFLog {
+	driver := sarifDriver{
+		Name:           "cfssl scan",
+		InformationURI: "https://github.com/cloudflare/cfssl",
+		Rules:          []sarifRule{},
+	}
+	results := []sarifResult{}
+	ruled := make(map[string]bool)
+
+	for _, report := range reports {
+		var familyNames []string
+		for familyName := range report.Families {
+			familyNames = append(familyNames, familyName)
+		}
+		sort.Strings(familyNames)
+
+		for _, familyName := range familyNames {
+			familyResult := report.Families[familyName]
+			var scannerNames []string
+			for scannerName := range familyResult {
+				scannerNames = append(scannerNames, scannerName)
+			}
+			sort.Strings(scannerNames)
+
+			for _, scannerName := range scannerNames {
+				result := familyResult[scannerName]
+				if result.Grade >= Good && (result.Error == nil || result.Grade == Skipped) {
+					continue
+				}
+
+				if !ruled[scannerName] {
+					ruled[scannerName] = true
+					rule := sarifRule{ID: scannerName}
+					if family, ok := fs[familyName]; ok {
+						if scanner, ok := family.Scanners[scannerName]; ok {
+							rule.ShortDescription.Text = scanner.Description
+							rule.HelpURI = scanner.Reference
+							if scanner.Remediation != "" {
+								rule.Help = &sarifMessage{scanner.Remediation}
+							}
+						}
+					}
+					if rule.ShortDescription.Text == "" {
+						rule.ShortDescription.Text = familyName + "/" + scannerName
+					}
+					driver.Rules = append(driver.Rules, rule)
+				}
+
+				results = append(results, sarifResult{
+					RuleID:  scannerName,
+					Level:   sarifLevel(result),
+					Message: sarifMessage{sarifText(result)},
+					Locations: []sarifLocation{{sarifPhysicalLocation{
+						sarifArtifactLocation{report.Host},
+					}}},
+				})
+			}
+		}
+	}
+
+	return SARIFLog{
+		Schema:  sarifSchema,
+		Version: "2.1.0",
+		Runs:    []sarifRun{{Tool: sarifTool{driver}, Results: results}},
+	}
+}
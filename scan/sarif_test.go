@@ -0,0 +1,107 @@
+package scan
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestSARIF(t *testing.T) {
+	fs := FamilySet{"Test": &Family{
+		Description: "Test scanners",
+		Scanners: map[string]*Scanner{
+			"Broken": {
+				Description: "Always broken",
+				Remediation: "Fix it.",
+				Reference:   "https://example.com/broken",
+			},
+			"Shaky":   {Description: "Sometimes broken"},
+			"Failing": {Description: "Fails to run"},
+			"Fine":    {Description: "Always fine"},
+			"Unsure":  {Description: "Can't tell"},
+		},
+	}}
+	reports := []HostReport{{Host: "example.com:443", Families: map[string]FamilyResult{"Test": {
+		"Broken":  {Grade: Bad, Output: OutputString("broken")},
+		"Shaky":   {Grade: Warning},
+		"Failing": {Grade: Bad, Error: errors.New("connection refused")},
+		"Fine":    {Grade: Good, Output: OutputString("fine")},
+		"Unsure":  {Grade: Skipped, Error: errors.New("not applicable")},
+	}}}}
+
+	b, err := json.Marshal(fs.SARIF(reports))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var log struct {
+		Schema  string `json:"$schema"`
+		Version string `json:"version"`
+		Runs    []struct {
+			Tool struct {
+				Driver struct {
+					Name  string `json:"name"`
+					Rules []struct {
+						ID               string `json:"id"`
+						ShortDescription struct {
+							Text string `json:"text"`
+						} `json:"shortDescription"`
+						Help *struct {
+							Text string `json:"text"`
+						} `json:"help"`
+						HelpURI string `json:"helpUri"`
+					} `json:"rules"`
+				} `json:"driver"`
+			} `json:"tool"`
+			Results []struct {
+				RuleID  string `json:"ruleId"`
+				Level   string `json:"level"`
+				Message struct {
+					Text string `json:"text"`
+				} `json:"message"`
+				Locations []struct {
+					PhysicalLocation struct {
+						ArtifactLocation struct {
+							URI string `json:"uri"`
+						} `json:"artifactLocation"`
+					} `json:"physicalLocation"`
+				} `json:"locations"`
+			} `json:"results"`
+		} `json:"runs"`
+	}
+	if err = json.Unmarshal(b, &log); err != nil {
+		t.Fatal(err)
+	}
+
+	if log.Version != "2.1.0" || log.Schema != sarifSchema || len(log.Runs) != 1 {
+		t.Fatalf("unexpected SARIF envelope: %s", b)
+	}
+	run := log.Runs[0]
+	if run.Tool.Driver.Name != "cfssl scan" {
+		t.Fatalf("unexpected tool %q", run.Tool.Driver.Name)
+	}
+
+	expected := []struct{ ruleID, level, message string }{
+		{"Broken", "error", "broken"},
+		{"Failing", "error", "connection refused"},
+		{"Shaky", "warning", "graded Warning"},
+	}
+	if len(run.Results) != len(expected) || len(run.Tool.Driver.Rules) != len(expected) {
+		t.Fatalf("expected %d results and rules, got %s", len(expected), b)
+	}
+	for i, e := range expected {
+		result := run.Results[i]
+		if result.RuleID != e.ruleID || result.Level != e.level || result.Message.Text != e.message {
+			t.Fatalf("result %d: expected %s %s %q, got %s %s %q",
+				i, e.ruleID, e.level, e.message, result.RuleID, result.Level, result.Message.Text)
+		}
+		if len(result.Locations) != 1 || result.Locations[0].PhysicalLocation.ArtifactLocation.URI != "example.com:443" {
+			t.Fatalf("result %d: expected location of scanned host, got %+v", i, result.Locations)
+		}
+		if rule := run.Tool.Driver.Rules[i]; rule.ID != e.ruleID || rule.ShortDescription.Text != fs["Test"].Scanners[e.ruleID].Description {
+			t.Fatalf("rule %d: unexpected rule %+v", i, rule)
+		}
+	}
+	if rule := run.Tool.Driver.Rules[0]; rule.Help == nil || rule.Help.Text != "Fix it." || rule.HelpURI != "https://example.com/broken" {
+		t.Fatalf("expected remediation and reference in rule help, got %+v", rule)
+	}
+}
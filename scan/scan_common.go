@@ -1,9 +1,16 @@
 package scan
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"regexp"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cloudflare/cf-tls/tls"
@@ -15,16 +22,69 @@ var (
 	Network = "tcp"
 	// Dialer is the default dialer to use, with a 1s timeout.
 	Dialer = &net.Dialer{Timeout: time.Second}
+	// ScanLogger is notified of each step taken while scanning. It discards
+	// everything by default.
+	ScanLogger Logger = nopLogger{}
+	// ScannerTimeout bounds the time given to each scanner, after which it is
+	// abandoned and reported as failed. An abandoned scanner keeps running in
+	// the background until it returns. Zero means no limit.
+	ScannerTimeout time.Duration
+	// HostTimeout bounds the total time spent scanning a single host, however
+	// many scanners are run. Scanners that can't finish in the time remaining
+	// are Skipped. Zero means no limit.
+	HostTimeout time.Duration
+	// Policy overrides the grades given by scanners. It is empty by default.
+	Policy = GradePolicy{}
+	// HostConcurrency is how many hosts ScanTargets and RunScansPorts scan at
+	// once. Values below one scan hosts one at a time, as does the default.
+	HostConcurrency = 1
+	// ScannerConcurrency is how many scanners are run at once against each
+	// host. Values below one run scanners one at a time, as does the default.
+	// Fragile hosts may not cope with many simultaneous connections.
+	ScannerConcurrency = 1
 )
 
+// scanOptions are the grade policy and concurrency limits of a scan, which
+// default to Policy, HostConcurrency and ScannerConcurrency.
+type scanOptions struct {
+	policy             GradePolicy
+	hostConcurrency    int
+	scannerConcurrency int
+}
+
+// defaultScanOptions returns the options currently set by the package defaults.
+func defaultScanOptions() scanOptions {
+	return scanOptions{Policy, HostConcurrency, ScannerConcurrency}
+}
+
+// Logger is a leveled logger that observes the steps taken by the scanners,
+// such as dialing a host or parsing its certificates. It has no influence on
+// the grades given.
+type Logger interface {
+	Debugf(format string, v ...interface{})
+	Infof(format string, v ...interface{})
+	Warningf(format string, v ...interface{})
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debugf(format string, v ...interface{})   {}
+func (nopLogger) Infof(format string, v ...interface{})    {}
+func (nopLogger) Warningf(format string, v ...interface{}) {}
+
 // Grade gives a subjective rating of the host's success in a scan.
 type Grade int
 
 const (
 	// Bad describes a host with serious misconfiguration or vulnerability.
 	Bad Grade = iota
+	// Warning describes a host with a non-ideal configuration that should be addressed.
+	Warning
 	// Legacy describes a host with non-ideal configuration that maintains support for legacy clients.
 	Legacy
+	// Notice describes a host with an acceptable configuration that is worth
+	// revisiting before it becomes a problem.
+	Notice
 	// Good describes host performing the expected state-of-the-art.
 	Good
 	// Skipped descibes the "grade" of a scan that has been skipped.
@@ -36,8 +96,12 @@ func (g Grade) String() string {
 	switch g {
 	case Bad:
 		return "Bad"
+	case Warning:
+		return "Warning"
 	case Legacy:
 		return "Legacy"
+	case Notice:
+		return "Notice"
 	case Good:
 		return "Good"
 	case Skipped:
@@ -47,6 +111,82 @@ func (g Grade) String() string {
 	}
 }
 
+// MarshalJSON encodes the Grade as its name.
+func (g Grade) MarshalJSON() ([]byte, error) {
+	return json.Marshal(g.String())
+}
+
+// UnmarshalJSON decodes a Grade from its name.
+func (g *Grade) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err != nil {
+		return err
+	}
+	grade, err := parseGrade(name)
+	if err != nil {
+		return err
+	}
+	*g = grade
+	return nil
+}
+
+// parseGrade returns the Grade with the given name.
+func parseGrade(name string) (Grade, error) {
+	for grade := Bad; grade <= Skipped; grade++ {
+		if grade.String() == name {
+			return grade, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid grade %q", name)
+}
+
+// GradeOverride adjusts the grade given by a scanner.
+type GradeOverride func(Grade) Grade
+
+// Reclassify returns a GradeOverride replacing each grade in remap with the
+// grade it maps to, leaving other grades alone.
+func Reclassify(remap map[Grade]Grade) GradeOverride {
+	return func(g Grade) Grade {
+		if to, ok := remap[g]; ok {
+			return to
+		}
+		return g
+	}
+}
+
+// Clamp returns a GradeOverride limiting grades to between min and max.
+// Skipped scans aren't graded, so are left alone.
+func Clamp(min, max Grade) GradeOverride {
+	return func(g Grade) Grade {
+		switch {
+		case g == Skipped:
+			return g
+		case g < min:
+			return min
+		case g > max:
+			return max
+		}
+		return g
+	}
+}
+
+// GradePolicy maps scanners to the overrides applied to their grades after
+// they run, letting users disagree with the severity chosen by a scanner. A
+// scanner is named either by itself, as in "CertExpiration", or qualified by
+// its family, as in "PKI/CertExpiration", which takes precedence.
+type GradePolicy map[string]GradeOverride
+
+// apply returns grade as overridden for the named scanner.
+func (p GradePolicy) apply(familyName, scannerName string, grade Grade) Grade {
+	if override, ok := p[familyName+"/"+scannerName]; ok {
+		return override(grade)
+	}
+	if override, ok := p[scannerName]; ok {
+		return override(grade)
+	}
+	return grade
+}
+
 // Output is the result of a scan, to be stored for potential use by later Scanners.
 type Output interface {
 	fmt.Stringer
@@ -56,18 +196,54 @@ type Output interface {
 type Scanner struct {
 	// Description describes the nature of the scan to be performed.
 	Description string `json:"description"`
+	// Category groups related scanners across families, such as "Certificate".
+	Category string `json:"category,omitempty"`
+	// Remediation tells users how to fix a host that fails the scan.
+	Remediation string `json:"remediation,omitempty"`
+	// Reference points to the standard or documentation behind the scan.
+	Reference string `json:"reference,omitempty"`
 	// scan is the function that scans the given host and provides a Grade and Output.
 	scan func(host string) (Grade, Output, error)
+	// scanState is used in place of scan by scanners that only need the result
+	// of a default TLS handshake with the host, which can be shared between them.
+	scanState func(host string, state *tls.ConnectionState) (Grade, Output, error)
 }
 
 // Scan performs the scan to be performed on the given host and stores its result.
 func (s *Scanner) Scan(host string) (Grade, Output, error) {
-	grade, output, err := s.scan(host)
-	if err != nil {
+	return s.run(host, func() (*tls.ConnectionState, error) {
+		return connectionState(host)
+	})
+}
+
+// run performs the scan on the given host, calling handshake for the
+// connection state if the scanner needs one. A scanner that panics, say on
+// malformed input from the host, fails with a PanicError rather than taking
+// down the whole scan.
+func (s *Scanner) run(host string, handshake func() (*tls.ConnectionState, error)) (grade Grade, output Output, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			ScanLogger.Debugf("scan: scanner panicked on %s: %v\n%s", host, r, debug.Stack())
+			grade, output, err = Bad, nil, &PanicError{Host: host, Panic: fmt.Sprint(r)}
+			log.Infof("scan: %v", err)
+		}
+	}()
+	if s.scanState != nil {
+		var state *tls.ConnectionState
+		if state, err = handshake(); err == nil && (state == nil || len(state.PeerCertificates) == 0) {
+			err = errNoCertificates
+		}
+		if err == nil {
+			grade, output, err = s.scanState(host, state)
+		}
+	} else {
+		grade, output, err = s.scan(host)
+	}
+	if _, shared := err.(*HandshakeError); err != nil && !shared {
+		// The runner reports a failed shared handshake once for all scanners.
 		log.Infof("scan: %v", err)
-		return grade, output, err
 	}
-	return grade, output, err
+	return
 }
 
 // Family defines a set of related scans meant to be run together in sequence.
@@ -86,22 +262,143 @@ var Default = FamilySet{
 	"Connectivity": Connectivity,
 	"TLSHandshake": TLSHandshake,
 	"TLSSession":   TLSSession,
-	"PKI":          PKI,
+	pkiFamily:      PKI,
+	"HTTP":         HTTP,
 }
 
 // ScannerResult contains the result for a single scan.
 type ScannerResult struct {
-	Grade  string `json:"grade"`
+	Grade  Grade  `json:"grade"`
 	Output Output `json:"output,omitempty"`
 	Error  error  `json:"error,omitempty"`
+	// Category, Remediation and Reference are copied from the Scanner, the
+	// latter two only when the scan fails.
+	Category    string `json:"category,omitempty"`
+	Remediation string `json:"remediation,omitempty"`
+	Reference   string `json:"reference,omitempty"`
 }
 
 // FamilyResult contains a scan response for a single Family
 type FamilyResult map[string]ScannerResult
 
+// HostReport contains the scan responses of each Family run against a single host.
+type HostReport struct {
+	Host     string                  `json:"host"`
+	Tags     map[string]string       `json:"tags,omitempty"`
+	Families map[string]FamilyResult `json:"families"`
+}
+
+// WorstGrade returns the lowest grade of any scan in the report, or Skipped
+// if it has none.
+func (r HostReport) WorstGrade() Grade {
+	worst := Skipped
+	for _, familyResult := range r.Families {
+		for _, result := range familyResult {
+			if result.Grade < worst {
+				worst = result.Grade
+			}
+		}
+	}
+	return worst
+}
+
+// Target is a host to scan, with tags such as its environment, team or
+// service by which reports can be grouped.
+type Target struct {
+	Host string            `json:"host"`
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+// ScanTargets runs the scans matching the family and scanner regular
+// expressions against each target, up to HostConcurrency at once, returning a
+// report for each that carries its tags in the order given.
+func (fs FamilySet) ScanTargets(targets []Target, family, scanner string) ([]HostReport, error) {
+	return fs.scanTargets(targets, family, scanner, defaultScanOptions())
+}
+
+// scanTargets is ScanTargets with the given options.
+func (fs FamilySet) scanTargets(targets []Target, family, scanner string, opts scanOptions) ([]HostReport, error) {
+	reports := make([]HostReport, len(targets))
+	errs := make([]error, len(targets))
+	forEachLimit(len(targets), opts.hostConcurrency, func(i int) {
+		var results map[string]FamilyResult
+		results, errs[i] = fs.runScansWith(targets[i].Host, family, scanner, opts)
+		reports[i] = HostReport{Host: targets[i].Host, Tags: targets[i].Tags, Families: results}
+	})
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return reports, nil
+}
+
+// TagGroup summarizes the reports of hosts sharing a tag value.
+type TagGroup struct {
+	Hosts      []string `json:"hosts"`
+	WorstGrade Grade    `json:"worst_grade"`
+}
+
+// GroupByTag groups reports by the value of their tag named key, giving the
+// worst grade of each group. Hosts without the tag are grouped under the
+// empty value.
+func GroupByTag(reports []HostReport, key string) map[string]TagGroup {
+	groups := make(map[string]TagGroup)
+	for _, report := range reports {
+		value := report.Tags[key]
+		group, ok := groups[value]
+		if !ok {
+			group.WorstGrade = Skipped
+		}
+		group.Hosts = append(group.Hosts, report.Host)
+		if grade := report.WorstGrade(); grade < group.WorstGrade {
+			group.WorstGrade = grade
+		}
+		groups[value] = group
+	}
+	return groups
+}
+
+// Distribution summarizes the reports of a fleet of hosts.
+type Distribution struct {
+	Hosts int `json:"hosts"`
+	// WorstGrades counts the hosts by the name of their worst grade.
+	WorstGrades map[string]int `json:"worst_grades"`
+	// Failures counts the hosts failing each scanner, keyed by family and
+	// scanner as in "PKI/SHA1". Scanners that failed on no host are omitted.
+	Failures map[string]int `json:"failures"`
+}
+
+// GradeDistribution summarizes reports across hosts, counting the hosts at
+// each worst grade and the hosts failing each scanner. A scanner fails on a
+// host when it grades it Bad, Warning or Legacy, or fails with an error.
+func GradeDistribution(reports []HostReport) Distribution {
+	distribution := Distribution{
+		Hosts:       len(reports),
+		WorstGrades: make(map[string]int),
+		Failures:    make(map[string]int),
+	}
+	for _, report := range reports {
+		distribution.WorstGrades[report.WorstGrade().String()]++
+		for familyName, familyResult := range report.Families {
+			for scannerName, result := range familyResult {
+				if result.Error != nil || result.Grade < Notice {
+					distribution.Failures[familyName+"/"+scannerName]++
+				}
+			}
+		}
+	}
+	return distribution
+}
+
 // RunScans interates over AllScans, running scans matching the family and scanner
 // regular expressions.
 func (fs FamilySet) RunScans(host, family, scanner string) (map[string]FamilyResult, error) {
+	return fs.runScansWith(host, family, scanner, defaultScanOptions())
+}
+
+// runScansWith is RunScans with the given options.
+func (fs FamilySet) runScansWith(host, family, scanner string, opts scanOptions) (map[string]FamilyResult, error) {
 	if _, _, err := net.SplitHostPort(host); err != nil {
 		host = net.JoinHostPort(host, "443")
 	}
@@ -116,27 +413,405 @@ func (fs FamilySet) RunScans(host, family, scanner string) (map[string]FamilyRes
 		return nil, err
 	}
 
-	familyResults := make(map[string]FamilyResult)
+	handshake := sharedHandshake(host)
+	return fs.runScans(host, familyRegexp, scannerRegexp, opts, func(s *Scanner) (Grade, Output, error) {
+		return s.run(host, handshake)
+	}), nil
+}
+
+// needsConnection is the output of scanners ProbeOnce doesn't run because they
+// need a connection of their own.
+type needsConnection struct{}
+
+func (needsConnection) String() string {
+	return "not run: scanner needs its own connection to the host, which probe-once mode forbids"
+}
+
+// MarshalJSON encodes the output as its description.
+func (n needsConnection) MarshalJSON() ([]byte, error) {
+	return json.Marshal(n.String())
+}
+
+// ProbeOnce runs every scan that can be graded from a single default TLS
+// handshake with host, making exactly one connection to it and never retrying.
+// Scanners that need a connection of their own aren't run, and are reported
+// as Skipped without an error, so that they don't fail SummaryExitCode.
+func (fs FamilySet) ProbeOnce(host string) HostReport {
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, "443")
+	}
+
+	handshake := sharedHandshake(host)
+	all := regexp.MustCompile("")
+	results := fs.runScans(host, all, all, defaultScanOptions(), func(s *Scanner) (Grade, Output, error) {
+		if s.scanState == nil {
+			return Skipped, needsConnection{}, nil
+		}
+		return s.run(host, handshake)
+	})
+	return HostReport{Host: host, Families: results}
+}
+
+// errNeedsDial is the error reported by ScanConn for scanners that need to
+// dial the host themselves.
+var errNeedsDial = errors.New("scanner needs to dial the host itself, so can't scan an established connection")
+
+// ScanConn performs a default TLS handshake with serverName over conn, an
+// already established connection such as a tunneled socket, and runs each of
+// scanners against the result, returning their results in the same order.
+// Scanners that need to dial the host themselves are reported as Skipped with
+// an error. Like RunScans, ScanConn applies Policy, ScannerTimeout and
+// HostTimeout, and runs up to ScannerConcurrency scanners at once. It closes
+// conn once the handshake is complete.
+func ScanConn(conn net.Conn, serverName string, scanners ...*Scanner) ([]ScannerResult, error) {
+	host := serverName
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		port := "443"
+		if _, p, err := net.SplitHostPort(conn.RemoteAddr().String()); err == nil {
+			port = p
+		}
+		host = net.JoinHostPort(serverName, port)
+	}
+
+	state, err := handshakeOver(host, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]scanJob, len(scanners))
+	for i, scanner := range scanners {
+		jobs[i] = Default.scanJob(scanner)
+	}
+	return runJobs(host, jobs, defaultScanOptions(), func(s *Scanner) (Grade, Output, error) {
+		if s.scanState == nil {
+			return Skipped, nil, errNeedsDial
+		}
+		return s.run(host, func() (*tls.ConnectionState, error) { return state, nil })
+	}), nil
+}
+
+// scanJob is a scanner to run, with the names of its family and itself by
+// which a GradePolicy overrides it and its progress is logged.
+type scanJob struct {
+	familyName, scannerName string
+	scanner                 *Scanner
+}
 
+// scanJob returns a job for s named as it is in fs, or by its description
+// if fs doesn't include it.
+func (fs FamilySet) scanJob(s *Scanner) scanJob {
 	for familyName, family := range fs {
-		if familyRegexp.MatchString(familyName) {
-			scannerResults := make(map[string]ScannerResult)
+		for scannerName, scanner := range family.Scanners {
+			if scanner == s {
+				return scanJob{familyName, scannerName, s}
+			}
+		}
+	}
+	return scanJob{"", s.Description, s}
+}
+
+// sharedHandshake returns a function that performs a default TLS handshake
+// with host when first called, and gives its result to every later caller.
+func sharedHandshake(host string) func() (*tls.ConnectionState, error) {
+	var state *tls.ConnectionState
+	var err error
+	var once sync.Once
+	return func() (*tls.ConnectionState, error) {
+		performed := false
+		once.Do(func() {
+			performed = true
+			if state, err = connectionState(host); err != nil {
+				err = &HandshakeError{Host: host, Err: err}
+			}
+		})
+		if !performed {
+			atomic.AddUint64(&stats.CacheHits, 1)
+		}
+		return state, err
+	}
+}
+
+// HandshakeError is the error of the default TLS handshake with a host shared
+// by the scanners that need it, when that handshake failed. Such scanners
+// aren't run, and are reported as Skipped. The first of them in a report
+// carries the HandshakeError, and the rest a handshakeFailed output naming
+// it, so that the failure is reported once.
+type HandshakeError struct {
+	Host string
+	Err  error
+}
+
+func (e *HandshakeError) Error() string {
+	return "handshake with " + e.Host + " failed: " + e.Err.Error()
+}
+
+// handshakeFailed is the output of scanners not run because the shared
+// handshake failed, naming the scanner whose result carries the error.
+type handshakeFailed string
+
+func (h handshakeFailed) String() string {
+	return "not run: the handshake failed, as reported by " + string(h)
+}
+
+// MarshalJSON encodes the output as its description.
+func (h handshakeFailed) MarshalJSON() ([]byte, error) {
+	return json.Marshal(h.String())
+}
+
+// PanicError is the error reported for a scanner that panicked while scanning
+// Host, typically on malformed input such as an unparseable certificate.
+type PanicError struct {
+	Host  string
+	Panic string
+}
+
+func (e *PanicError) Error() string {
+	return "scanner panicked on " + e.Host + ": " + e.Panic
+}
+
+// errScannerTimeout is reported for scanners that take longer than ScannerTimeout.
+var errScannerTimeout = errors.New("scanner timed out")
+
+// deadlineExceeded is the output of scanners skipped because HostTimeout ran out.
+type deadlineExceeded struct{}
+
+func (deadlineExceeded) String() string {
+	return "deadline exceeded"
+}
+
+// MarshalJSON encodes the output as its description.
+func (d deadlineExceeded) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
 
+// runWithin runs scan, abandoning it if it doesn't finish within timeout. A
+// timeout of zero waits for scan to finish however long it takes.
+//
+// Scanners take no context and dial through the package-level dial, so an
+// abandoned scan can't be cancelled: its goroutine leaks until the scanner
+// returns by itself, and its result is then discarded. Dials and handshakes
+// are bounded by Dialer.Timeout, but a scanner stalled elsewhere, say reading
+// from a host that stops responding, leaks for as long as it stalls.
+func runWithin(timeout time.Duration, scan func() (Grade, Output, error)) (grade Grade, output Output, err error, timedOut bool) {
+	if timeout == 0 {
+		grade, output, err = scan()
+		return
+	}
+
+	type result struct {
+		grade  Grade
+		output Output
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		grade, output, err := scan()
+		done <- result{grade, output, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.grade, r.output, r.err, false
+	case <-time.After(timeout):
+		return Bad, nil, nil, true
+	}
+}
+
+// forEachLimit calls f with each index below n, making at most limit calls at
+// once. A limit below one is treated as one, making the calls in sequence.
+func forEachLimit(n, limit int, f func(i int)) {
+	if limit < 1 {
+		limit = 1
+	}
+	sem := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			f(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// runScans uses run to perform the scans matching familyRegexp and scannerRegexp
+// against host with opts.
+func (fs FamilySet) runScans(host string, familyRegexp, scannerRegexp *regexp.Regexp, opts scanOptions, run func(*Scanner) (Grade, Output, error)) map[string]FamilyResult {
+	var jobs []scanJob
+	familyResults := make(map[string]FamilyResult)
+	for familyName, family := range fs {
+		if familyRegexp.MatchString(familyName) {
+			familyResults[familyName] = make(FamilyResult)
 			for scannerName, scanner := range family.Scanners {
 				if scannerRegexp.MatchString(scannerName) {
-					grade, output, err := scanner.Scan(host)
-					scannerResults[scannerName] = ScannerResult{
-						Grade:  grade.String(),
-						Output: output,
-						Error:  err,
-					}
+					jobs = append(jobs, scanJob{familyName, scannerName, scanner})
 				}
 			}
+		}
+	}
 
-			familyResults[familyName] = scannerResults
+	// Run in a stable order, which decides the result reporting a failed handshake.
+	sort.Slice(jobs, func(i, j int) bool {
+		if jobs[i].familyName != jobs[j].familyName {
+			return jobs[i].familyName < jobs[j].familyName
 		}
+		return jobs[i].scannerName < jobs[j].scannerName
+	})
+	for i, result := range runJobs(host, jobs, opts, run) {
+		familyResults[jobs[i].familyName][jobs[i].scannerName] = result
 	}
-	return familyResults, nil
+	return familyResults
+}
+
+// runJobs uses run to perform each of jobs against host, running up to
+// opts.scannerConcurrency of them at once, each within ScannerTimeout and all
+// within HostTimeout, and returns their results, as overridden by
+// opts.policy, in the same order. A failed shared handshake is logged and
+// reported by the first job needing it.
+func runJobs(host string, jobs []scanJob, opts scanOptions, run func(*Scanner) (Grade, Output, error)) []ScannerResult {
+	var hostDeadline time.Time
+	if HostTimeout > 0 {
+		hostDeadline = time.Now().Add(HostTimeout)
+	}
+
+	// A failed handshake is shared by many scanners, but only logged once.
+	var handshakeLogged bool
+	var mu sync.Mutex
+
+	results := make([]ScannerResult, len(jobs))
+	forEachLimit(len(jobs), opts.scannerConcurrency, func(i int) {
+		familyName, scannerName, scanner := jobs[i].familyName, jobs[i].scannerName, jobs[i].scanner
+		ScanLogger.Infof("scan: running %s/%s against %s", familyName, scannerName, host)
+		timeout, hostLimited := ScannerTimeout, false
+		if !hostDeadline.IsZero() {
+			if remaining := hostDeadline.Sub(time.Now()); timeout == 0 || remaining < timeout {
+				timeout, hostLimited = remaining, true
+			}
+		}
+
+		var grade Grade
+		var output Output
+		var err error
+		timedOut := hostLimited && timeout <= 0
+		if !timedOut {
+			grade, output, err, timedOut = runWithin(timeout, func() (Grade, Output, error) {
+				return run(scanner)
+			})
+		}
+		if timedOut {
+			atomic.AddUint64(&stats.Timeouts, 1)
+		}
+		if timedOut && hostLimited {
+			grade, output, err = Skipped, deadlineExceeded{}, nil
+		} else if timedOut {
+			err = errScannerTimeout
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if handshakeErr, ok := err.(*HandshakeError); ok {
+			grade, output = Skipped, nil
+			if !handshakeLogged {
+				ScanLogger.Warningf("scan: %v", handshakeErr)
+				handshakeLogged = true
+			}
+		} else if err != nil {
+			ScanLogger.Warningf("scan: %s/%s failed against %s: %v", familyName, scannerName, host, err)
+		}
+		if overridden := opts.policy.apply(familyName, scannerName, grade); overridden != grade {
+			ScanLogger.Debugf("scan: policy overrides %s/%s grade %s with %s", familyName, scannerName, grade, overridden)
+			grade = overridden
+		}
+		ScanLogger.Infof("scan: %s/%s graded %s as %s", familyName, scannerName, host, grade)
+		result := ScannerResult{
+			Grade:    grade,
+			Output:   output,
+			Error:    err,
+			Category: scanner.Category,
+		}
+		if grade < Good || err != nil && grade != Skipped {
+			result.Remediation = scanner.Remediation
+			result.Reference = scanner.Reference
+		}
+		results[i] = result
+	})
+
+	var reportedBy string
+	for i := range results {
+		if _, ok := results[i].Error.(*HandshakeError); !ok {
+			continue
+		}
+		if reportedBy == "" {
+			reportedBy = jobs[i].familyName + "/" + jobs[i].scannerName
+			continue
+		}
+		results[i].Error, results[i].Output = nil, handshakeFailed(reportedBy)
+	}
+	return results
+}
+
+// WarningsFail determines whether SummaryExitCode treats Warning and Legacy
+// grades as failures.
+var WarningsFail = true
+
+// SummaryExitCode maps the worst result in report to an exit code, so that
+// automated pipelines can fail on a poorly configured host:
+//
+//	0: every scan was Good, Notice or Skipped, or Warning or Legacy if WarningsFail is false
+//	1: the worst grade was Warning or Legacy
+//	2: the worst grade was Bad
+//	3: a scan failed with an error
+func SummaryExitCode(report HostReport) int {
+	code := 0
+	for _, familyResult := range report.Families {
+		for _, result := range familyResult {
+			resultCode := 0
+			switch {
+			case result.Error != nil:
+				resultCode = 3
+			case result.Grade == Bad:
+				resultCode = 2
+			case result.Grade == Warning || result.Grade == Legacy:
+				if WarningsFail {
+					resultCode = 1
+				}
+			}
+			if resultCode > code {
+				code = resultCode
+			}
+		}
+	}
+	return code
+}
+
+// ExpandPorts returns an address for each of the given ports on host. Any port
+// already present in host is replaced.
+func ExpandPorts(host string, ports []int) []string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	addrs := make([]string, len(ports))
+	for i, port := range ports {
+		addrs[i] = net.JoinHostPort(host, strconv.Itoa(port))
+	}
+	return addrs
+}
+
+// RunScansPorts runs the scans matching the family and scanner regular
+// expressions against each of the given ports on host, up to HostConcurrency
+// at once, returning a report for each port in the order given.
+func (fs FamilySet) RunScansPorts(host string, ports []int, family, scanner string) ([]HostReport, error) {
+	addrs := ExpandPorts(host, ports)
+	targets := make([]Target, len(addrs))
+	for i, addr := range addrs {
+		targets[i] = Target{Host: addr}
+	}
+	return fs.ScanTargets(targets, family, scanner)
 }
 
 func defaultTLSConfig(host string) *tls.Config {
@@ -146,3 +821,45 @@ func defaultTLSConfig(host string) *tls.Config {
 	}
 	return &tls.Config{ServerName: h, InsecureSkipVerify: true}
 }
+
+// connectionState performs a default TLS handshake with host and returns the
+// resulting connection state, which always includes at least one certificate.
+func connectionState(host string) (*tls.ConnectionState, error) {
+	return wrappedConnectionState(host, nil)
+}
+
+// wrappedConnectionState is connectionState, performing the handshake over
+// wrap(conn) rather than the connection itself if wrap isn't nil.
+func wrappedConnectionState(host string, wrap func(net.Conn) net.Conn) (*tls.ConnectionState, error) {
+	ScanLogger.Debugf("scan: dialing %s", host)
+	rawConn, err := dial(Network, host)
+	if err != nil {
+		return nil, err
+	}
+	if wrap != nil {
+		rawConn = wrap(rawConn)
+	}
+	return handshakeOver(host, rawConn)
+}
+
+// errNoCertificates is reported when a handshake leaves no certificates for
+// scanners to inspect.
+var errNoCertificates = errors.New("host presented no certificates")
+
+// handshakeOver performs a default TLS handshake with host over rawConn,
+// which it closes, and returns the resulting connection state.
+func handshakeOver(host string, rawConn net.Conn) (*tls.ConnectionState, error) {
+	conn := tls.Client(rawConn, defaultTLSConfig(host))
+	err := clientHandshake(conn, rawConn)
+	conn.Close()
+	if err != nil {
+		return nil, err
+	}
+	ScanLogger.Debugf("scan: handshake with %s complete", host)
+	state := conn.ConnectionState()
+	if len(state.PeerCertificates) == 0 {
+		return nil, errNoCertificates
+	}
+	ScanLogger.Debugf("scan: parsed %d certificates from %s", len(state.PeerCertificates), host)
+	return &state, nil
+}
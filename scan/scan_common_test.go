@@ -1,8 +1,17 @@
 package scan
 
 import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"sync"
 	"testing"
+	"time"
 )
 
 type OutputString string
@@ -70,3 +79,511 @@ func TestCommon(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestExpandPorts(t *testing.T) {
+	cases := []struct {
+		host     string
+		ports    []int
+		expected []string
+	}{
+		{"example.com", []int{443, 8443}, []string{"example.com:443", "example.com:8443"}},
+		{"example.com:443", []int{993, 465}, []string{"example.com:993", "example.com:465"}},
+		{"::1", []int{443}, []string{"[::1]:443"}},
+		{"[::1]:8443", []int{443}, []string{"[::1]:443"}},
+		{"example.com", nil, []string{}},
+	}
+
+	for _, c := range cases {
+		addrs := ExpandPorts(c.host, c.ports)
+		if len(addrs) != len(c.expected) {
+			t.Fatalf("%s: expected %v, got %v", c.host, c.expected, addrs)
+		}
+		for i := range addrs {
+			if addrs[i] != c.expected[i] {
+				t.Fatalf("%s: expected %v, got %v", c.host, c.expected, addrs)
+			}
+		}
+	}
+}
+
+func TestRunScansPorts(t *testing.T) {
+	fs := FamilySet{
+		"Ports": &Family{
+			Description: "Grades hosts by port",
+			Scanners: map[string]*Scanner{
+				"Port": {
+					Description: "Only port 443 is Good",
+					scan: func(host string) (Grade, Output, error) {
+						if _, port, _ := net.SplitHostPort(host); port == "443" {
+							return Good, OutputString(host), nil
+						}
+						return Bad, OutputString(host), nil
+					},
+				},
+			},
+		},
+	}
+
+	reports, err := fs.RunScansPorts("example.com", []int{443, 8443, 993}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		host  string
+		grade Grade
+	}{
+		{"example.com:443", Good},
+		{"example.com:8443", Bad},
+		{"example.com:993", Bad},
+	}
+	if len(reports) != len(expected) {
+		t.Fatalf("expected %d reports, got %d", len(expected), len(reports))
+	}
+	for i, e := range expected {
+		result := reports[i].Families["Ports"]["Port"]
+		if reports[i].Host != e.host || result.Output.String() != e.host || result.Grade != e.grade {
+			t.Fatalf("port %d: expected %s graded %s, got %s graded %s", i, e.host, e.grade, reports[i].Host, result.Grade)
+		}
+	}
+}
+
+func TestGradeJSON(t *testing.T) {
+	for grade := Bad; grade <= Skipped; grade++ {
+		b, err := json.Marshal(grade)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != `"`+grade.String()+`"` {
+			t.Fatalf("expected %s to marshal to its name, got %s", grade, b)
+		}
+		var decoded Grade
+		if err = json.Unmarshal(b, &decoded); err != nil || decoded != grade {
+			t.Fatalf("expected %s to round trip, got %s: %v", grade, decoded, err)
+		}
+	}
+
+	var g Grade
+	if err := json.Unmarshal([]byte(`"Excellent"`), &g); err == nil {
+		t.Fatal("expected unknown grade name to fail to unmarshal")
+	}
+}
+
+func TestSummaryExitCode(t *testing.T) {
+	report := func(results ...ScannerResult) HostReport {
+		familyResult := make(FamilyResult)
+		for i, result := range results {
+			familyResult[fmt.Sprint(i)] = result
+		}
+		return HostReport{Host: "example.com:443", Families: map[string]FamilyResult{"Family": familyResult}}
+	}
+
+	cases := []struct {
+		report       HostReport
+		code         int
+		warningsPass int
+	}{
+		{report(), 0, 0},
+		{report(ScannerResult{Grade: Good}, ScannerResult{Grade: Skipped}), 0, 0},
+		{report(ScannerResult{Grade: Good}, ScannerResult{Grade: Warning}), 1, 0},
+		{report(ScannerResult{Grade: Legacy}), 1, 0},
+		{report(ScannerResult{Grade: Notice}, ScannerResult{Grade: Good}), 0, 0},
+		{report(ScannerResult{Grade: Warning}, ScannerResult{Grade: Bad}), 2, 2},
+		{report(ScannerResult{Grade: Bad}, ScannerResult{Grade: Bad, Error: errors.New("dial failed")}), 3, 3},
+	}
+
+	defer func(fail bool) { WarningsFail = fail }(WarningsFail)
+	for i, c := range cases {
+		WarningsFail = true
+		if code := SummaryExitCode(c.report); code != c.code {
+			t.Fatalf("case %d: expected exit code %d, got %d", i, c.code, code)
+		}
+		WarningsFail = false
+		if code := SummaryExitCode(c.report); code != c.warningsPass {
+			t.Fatalf("case %d: expected exit code %d when warnings pass, got %d", i, c.warningsPass, code)
+		}
+	}
+}
+
+// sleepingFamily returns a Family of n scanners, each taking d to give a Good grade.
+func sleepingFamily(n int, d time.Duration) *Family {
+	family := &Family{Description: "Sleeps", Scanners: make(map[string]*Scanner)}
+	for i := 0; i < n; i++ {
+		family.Scanners[fmt.Sprint(i)] = &Scanner{
+			Description: "Sleeps then succeeds",
+			scan: func(host string) (Grade, Output, error) {
+				time.Sleep(d)
+				return Good, OutputString("done"), nil
+			},
+		}
+	}
+	return family
+}
+
+func TestScannerTimeout(t *testing.T) {
+	defer func(d time.Duration) { ScannerTimeout = d }(ScannerTimeout)
+	ScannerTimeout = 20 * time.Millisecond
+
+	fs := FamilySet{
+		"Slow": sleepingFamily(1, time.Second),
+		"Fast": sleepingFamily(1, 0),
+	}
+	results, err := fs.RunScans("example.com", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slow := results["Slow"]["0"]; slow.Error != errScannerTimeout {
+		t.Fatalf("expected slow scanner to time out, got %s: %v", slow.Grade, slow.Error)
+	}
+	if fast := results["Fast"]["0"]; fast.Grade != Good || fast.Error != nil {
+		t.Fatalf("expected fast scanner to succeed, got %s: %v", fast.Grade, fast.Error)
+	}
+}
+
+func TestHostTimeout(t *testing.T) {
+	defer func(d time.Duration) { HostTimeout = d }(HostTimeout)
+	HostTimeout = 75 * time.Millisecond
+
+	// Every scanner is quick enough on its own, but only the first fits in the budget.
+	fs := FamilySet{"Sleeps": sleepingFamily(3, 50*time.Millisecond)}
+	start := time.Now()
+	results, err := fs.RunScans("example.com", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("expected scans to stop at the host deadline, took %s", elapsed)
+	}
+
+	var good, skipped int
+	for _, result := range results["Sleeps"] {
+		switch {
+		case result.Grade == Good:
+			good++
+		case result.Grade == Skipped && result.Output.String() == "deadline exceeded" && result.Error == nil:
+			skipped++
+		default:
+			t.Fatalf("unexpected result %s (%v): %v", result.Grade, result.Output, result.Error)
+		}
+	}
+	if good != 1 || skipped != 2 {
+		t.Fatalf("expected 1 scanner to finish and 2 to be skipped, got %d and %d", good, skipped)
+	}
+}
+
+// gradeFamily returns a Family whose single scanner "Fixed" always gives grade.
+func gradeFamily(grade Grade) *Family {
+	return &Family{
+		Description: "Gives a fixed grade",
+		Scanners: map[string]*Scanner{
+			"Fixed": {
+				Description: "Gives a fixed grade",
+				scan: func(host string) (Grade, Output, error) {
+					return grade, nil, nil
+				},
+			},
+		},
+	}
+}
+
+func TestGradePolicy(t *testing.T) {
+	defer func(p GradePolicy) { Policy = p }(Policy)
+	Policy = GradePolicy{"Strict/Fixed": Reclassify(map[Grade]Grade{Warning: Bad})}
+
+	fs := FamilySet{"Strict": gradeFamily(Warning), "Lenient": gradeFamily(Warning)}
+	results, err := fs.RunScans("example.com", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grade := results["Strict"]["Fixed"].Grade; grade != Bad {
+		t.Fatalf("expected policy to reclassify Warning as Bad, got %s", grade)
+	}
+	if grade := results["Lenient"]["Fixed"].Grade; grade != Warning {
+		t.Fatalf("expected scanner outside policy to keep its grade, got %s", grade)
+	}
+
+	// Unqualified names apply to scanners of that name in every family.
+	Policy = GradePolicy{"Fixed": Reclassify(map[Grade]Grade{Warning: Bad})}
+	if results, err = fs.RunScans("example.com", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if results["Strict"]["Fixed"].Grade != Bad || results["Lenient"]["Fixed"].Grade != Bad {
+		t.Fatal("expected unqualified policy to apply to every family")
+	}
+}
+
+func TestClamp(t *testing.T) {
+	clamp := Clamp(Warning, Legacy)
+	cases := map[Grade]Grade{Bad: Warning, Warning: Warning, Legacy: Legacy, Good: Legacy, Skipped: Skipped}
+	for from, to := range cases {
+		if g := clamp(from); g != to {
+			t.Fatalf("expected %s to be clamped to %s, got %s", from, to, g)
+		}
+	}
+}
+
+func TestScanConn(t *testing.T) {
+	leaf := newTestCert(t, testTemplate("localhost"), testKey.Public(), nil, testKey)
+	client, server := net.Pipe()
+	go func() {
+		conn := tls.Server(server, &tls.Config{Certificates: []tls.Certificate{{
+			Certificate: [][]byte{leaf.Raw},
+			PrivateKey:  testKey,
+		}}})
+		// Read until the scanner hangs up, since writes to a pipe block.
+		io.Copy(ioutil.Discard, conn)
+		conn.Close()
+	}()
+
+	// Policy applies to scanners run over a connection as it does to RunScans.
+	defer func(p GradePolicy) { Policy = p }(Policy)
+	Policy = GradePolicy{"PKI/CompromisedKey": Reclassify(map[Grade]Grade{Good: Notice})}
+
+	results, err := ScanConn(client, "localhost", PKI.Scanners["CompromisedKey"], PKI.Scanners["IntermediateCAs"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(results))
+	}
+	if results[0].Grade != Notice || results[0].Output.String() != string(certSPKIHash(leaf)) {
+		t.Fatalf("unexpected result %s (%v): %v", results[0].Grade, results[0].Output, results[0].Error)
+	}
+	if results[1].Grade != Skipped || results[1].Error != errNeedsDial {
+		t.Fatalf("expected scanner needing to dial to be skipped, got %s: %v", results[1].Grade, results[1].Error)
+	}
+}
+
+func TestScanConnHandshakeFailure(t *testing.T) {
+	client, server := net.Pipe()
+	server.Close()
+	if _, err := ScanConn(client, "localhost", PKI.Scanners["CompromisedKey"]); err == nil {
+		t.Fatal("expected handshake over closed connection to fail")
+	}
+}
+
+func TestHandshakeDeadline(t *testing.T) {
+	// The listener accepts connections into its backlog but never answers.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	defer func(timeout time.Duration) { Dialer.Timeout = timeout }(Dialer.Timeout)
+	Dialer.Timeout = 100 * time.Millisecond
+	start := time.Now()
+	if _, err := connectionState(l.Addr().String()); err == nil {
+		t.Fatal("expected handshake with a silent host to fail")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("handshake with a silent host took %s", elapsed)
+	}
+}
+
+// hostGradeFamily returns a Family whose single scanner "ByHost" gives each host its grade in grades.
+func hostGradeFamily(grades map[string]Grade) *Family {
+	return &Family{
+		Description: "Gives a grade per host",
+		Scanners: map[string]*Scanner{
+			"ByHost": {
+				Description: "Gives a grade per host",
+				scan: func(host string) (Grade, Output, error) {
+					return grades[host], nil, nil
+				},
+			},
+		},
+	}
+}
+
+func TestGroupByTag(t *testing.T) {
+	fs := FamilySet{
+		"Hosts": hostGradeFamily(map[string]Grade{
+			"a.example.com:443": Good,
+			"b.example.com:443": Warning,
+			"c.example.com:443": Good,
+			"d.example.com:443": Bad,
+		}),
+		"Others": gradeFamily(Skipped),
+	}
+	targets := []Target{
+		{Host: "a.example.com:443", Tags: map[string]string{"env": "prod", "team": "edge"}},
+		{Host: "b.example.com:443", Tags: map[string]string{"env": "prod", "team": "api"}},
+		{Host: "c.example.com:443", Tags: map[string]string{"env": "staging", "team": "edge"}},
+		{Host: "d.example.com:443"},
+	}
+	reports, err := fs.ScanTargets(targets, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != len(targets) || reports[0].Tags["team"] != "edge" {
+		t.Fatalf("expected a report carrying the tags of each target, got %+v", reports)
+	}
+
+	expected := map[string]TagGroup{
+		"prod":    {Hosts: []string{"a.example.com:443", "b.example.com:443"}, WorstGrade: Warning},
+		"staging": {Hosts: []string{"c.example.com:443"}, WorstGrade: Good},
+		"":        {Hosts: []string{"d.example.com:443"}, WorstGrade: Bad},
+	}
+	if groups := GroupByTag(reports, "env"); !reflect.DeepEqual(groups, expected) {
+		t.Fatalf("expected groups %+v, got %+v", expected, groups)
+	}
+
+	if groups := GroupByTag(reports, "team"); groups["edge"].WorstGrade != Good || groups["api"].WorstGrade != Warning {
+		t.Fatalf("unexpected team groups %+v", groups)
+	}
+}
+
+func TestGradeDistribution(t *testing.T) {
+	report := func(host string, sha1, expiration ScannerResult) HostReport {
+		return HostReport{Host: host, Families: map[string]FamilyResult{
+			"PKI": {"SHA1": sha1, "CertExpiration": expiration},
+		}}
+	}
+	reports := []HostReport{
+		report("a.example.com:443", ScannerResult{Grade: Good}, ScannerResult{Grade: Good}),
+		report("b.example.com:443", ScannerResult{Grade: Bad}, ScannerResult{Grade: Warning}),
+		report("c.example.com:443", ScannerResult{Grade: Bad}, ScannerResult{Grade: Notice}),
+		report("d.example.com:443", ScannerResult{Grade: Legacy}, ScannerResult{Grade: Skipped, Error: errors.New("dial failed")}),
+		report("e.example.com:443", ScannerResult{Grade: Skipped}, ScannerResult{Grade: Skipped}),
+	}
+
+	expected := Distribution{
+		Hosts:       5,
+		WorstGrades: map[string]int{"Good": 1, "Bad": 2, "Legacy": 1, "Skipped": 1},
+		Failures:    map[string]int{"PKI/SHA1": 3, "PKI/CertExpiration": 2},
+	}
+	if distribution := GradeDistribution(reports); !reflect.DeepEqual(distribution, expected) {
+		t.Fatalf("expected distribution %+v, got %+v", expected, distribution)
+	}
+
+	if distribution := GradeDistribution(nil); distribution.Hosts != 0 || len(distribution.WorstGrades) != 0 || len(distribution.Failures) != 0 {
+		t.Fatalf("expected an empty distribution for no reports, got %+v", distribution)
+	}
+}
+
+// concurrencyGauge tracks the number of scans in flight, and the most seen at
+// once. Its reached channel is closed once limit scans are in flight.
+type concurrencyGauge struct {
+	mu            sync.Mutex
+	current, peak int
+	limit         int
+	reached       chan struct{}
+}
+
+func newConcurrencyGauge(limit int) *concurrencyGauge {
+	return &concurrencyGauge{limit: limit, reached: make(chan struct{})}
+}
+
+func (g *concurrencyGauge) enter() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.current++
+	if g.current > g.peak {
+		g.peak = g.current
+		if g.peak == g.limit {
+			close(g.reached)
+		}
+	}
+}
+
+func (g *concurrencyGauge) leave() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.current--
+}
+
+// gaugedFamily returns a family of n scanners tracking the scans in flight
+// per host and across hosts. Each scan waits until all reaches its limit, so
+// that the limit is reached however the scans are scheduled, or until
+// timeout passes should it never be.
+func gaugedFamily(n int, timeout time.Duration, perHost map[string]*concurrencyGauge, all *concurrencyGauge) *Family {
+	family := &Family{Description: "Waits for its peers", Scanners: make(map[string]*Scanner)}
+	for i := 0; i < n; i++ {
+		family.Scanners[fmt.Sprint(i)] = &Scanner{
+			Description: "Waits for its peers then succeeds",
+			scan: func(host string) (Grade, Output, error) {
+				for _, g := range []*concurrencyGauge{perHost[host], all} {
+					g.enter()
+					defer g.leave()
+				}
+				select {
+				case <-all.reached:
+				case <-time.After(timeout):
+				}
+				return Good, nil, nil
+			},
+		}
+	}
+	return family
+}
+
+func TestConcurrencyLimits(t *testing.T) {
+	defer func(h, s int) { HostConcurrency, ScannerConcurrency = h, s }(HostConcurrency, ScannerConcurrency)
+
+	cases := []struct{ hosts, scanners int }{
+		{1, 1},
+		{1, 4},
+		{3, 1},
+		{2, 3},
+	}
+	for _, c := range cases {
+		HostConcurrency, ScannerConcurrency = c.hosts, c.scanners
+		limit := c.hosts * c.scanners
+
+		perHost := make(map[string]*concurrencyGauge)
+		var targets []Target
+		for i := 0; i < 6; i++ {
+			host := fmt.Sprintf("host%d.example.com:443", i)
+			perHost[host] = newConcurrencyGauge(0)
+			targets = append(targets, Target{Host: host})
+		}
+		all := newConcurrencyGauge(limit)
+		fs := FamilySet{"Waits": gaugedFamily(8, 5*time.Second, perHost, all)}
+
+		reports, err := fs.ScanTargets(targets, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, report := range reports {
+			if report.Host != targets[i].Host || len(report.Families["Waits"]) != 8 {
+				t.Fatalf("unexpected report %d: %+v", i, report)
+			}
+		}
+
+		select {
+		case <-all.reached:
+		default:
+			t.Fatalf("%d hosts by %d scanners: never ran %d scans at once, at most %d", c.hosts, c.scanners, limit, all.peak)
+		}
+		if all.peak > limit {
+			t.Fatalf("%d hosts by %d scanners: %d scans ran at once", c.hosts, c.scanners, all.peak)
+		}
+		for host, g := range perHost {
+			if g.peak > c.scanners {
+				t.Fatalf("%d scanners per host: %d ran at once against %s", c.scanners, g.peak, host)
+			}
+		}
+	}
+}
+
+func TestScannerPanic(t *testing.T) {
+	scanner := &Scanner{
+		Description: "Panics on every host",
+		scan: func(host string) (Grade, Output, error) {
+			var certs []byte
+			return Good, OutputString(certs[1:]), nil
+		},
+	}
+
+	grade, output, err := scanner.Scan("panic.example:443")
+	panicErr, ok := err.(*PanicError)
+	if !ok {
+		t.Fatalf("expected a PanicError, got %v", err)
+	}
+	if panicErr.Host != "panic.example:443" {
+		t.Fatalf("expected the panic to name the host, got %q", panicErr.Host)
+	}
+	if grade != Bad || output != nil {
+		t.Fatalf("expected a panicking scanner to be Bad without output, got %s (%v)", grade, output)
+	}
+}
@@ -0,0 +1,95 @@
+package scan
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudflare/cf-tls/tls"
+)
+
+// ScanStats counts the work done by scans since the counters were last reset,
+// for operators running scans continuously to scrape or log.
+type ScanStats struct {
+	// Dials counts connections opened to scanned hosts.
+	Dials uint64 `json:"dials"`
+	// HandshakeFailures counts TLS handshakes with scanned hosts that failed.
+	HandshakeFailures uint64 `json:"handshake_failures"`
+	// Timeouts counts scanners abandoned for exceeding ScannerTimeout or HostTimeout.
+	Timeouts uint64 `json:"timeouts"`
+	// CacheHits counts scanners given the result of a handshake shared with
+	// another scanner rather than performing their own.
+	CacheHits uint64 `json:"cache_hits"`
+}
+
+// stats is updated atomically by concurrent scans.
+var stats ScanStats
+
+// Stats returns a snapshot of the counters.
+func Stats() ScanStats {
+	return ScanStats{
+		Dials:             atomic.LoadUint64(&stats.Dials),
+		HandshakeFailures: atomic.LoadUint64(&stats.HandshakeFailures),
+		Timeouts:          atomic.LoadUint64(&stats.Timeouts),
+		CacheHits:         atomic.LoadUint64(&stats.CacheHits),
+	}
+}
+
+// ResetStats sets every counter back to zero.
+func ResetStats() {
+	atomic.StoreUint64(&stats.Dials, 0)
+	atomic.StoreUint64(&stats.HandshakeFailures, 0)
+	atomic.StoreUint64(&stats.Timeouts, 0)
+	atomic.StoreUint64(&stats.CacheHits, 0)
+}
+
+// dial connects to addr with Dialer, counting the connection and announcing
+// it with the ProxyProtocol header if one is configured.
+func dial(network, addr string) (net.Conn, error) {
+	return dialWith(Dialer, network, addr)
+}
+
+// dialWith is dial through dialer in place of Dialer.
+func dialWith(dialer *net.Dialer, network, addr string) (net.Conn, error) {
+	atomic.AddUint64(&stats.Dials, 1)
+	conn, err := dialer.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if err = sendProxyHeader(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// clientHandshake performs the handshake of conn, a TLS client over rawConn,
+// within Dialer.Timeout as tls.DialWithDialer does, so that a host accepting
+// the connection and then sending nothing can't stall a scan. The deadline is
+// cleared once the handshake is over. Failures are counted.
+func clientHandshake(conn *tls.Conn, rawConn net.Conn) error {
+	if Dialer.Timeout > 0 {
+		rawConn.SetDeadline(time.Now().Add(Dialer.Timeout))
+		defer rawConn.SetDeadline(time.Time{})
+	}
+	if err := conn.Handshake(); err != nil {
+		atomic.AddUint64(&stats.HandshakeFailures, 1)
+		return err
+	}
+	return nil
+}
+
+// tlsDial connects to host and performs a TLS handshake using config within
+// Dialer.Timeout, counting the connection and any handshake failure.
+func tlsDial(host string, config *tls.Config) (*tls.Conn, error) {
+	rawConn, err := dial(Network, host)
+	if err != nil {
+		return nil, err
+	}
+	conn := tls.Client(rawConn, config)
+	if err = clientHandshake(conn, rawConn); err != nil {
+		rawConn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
@@ -0,0 +1,136 @@
+package scan
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cloudflare/cf-tls/tls"
+)
+
+// handshakeFamily returns a Family of n scanners sharing the default handshake.
+func handshakeFamily(n int) *Family {
+	family := &Family{Description: "Handshakes", Scanners: make(map[string]*Scanner)}
+	for i := 0; i < n; i++ {
+		family.Scanners[string('A'+rune(i))] = &Scanner{
+			Description: "Succeeds after the handshake",
+			scanState: func(host string, state *tls.ConnectionState) (Grade, Output, error) {
+				return Good, nil, nil
+			},
+		}
+	}
+	return family
+}
+
+// concurrently calls f from n goroutines and waits for them to return.
+func concurrently(n int, f func()) {
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			f()
+		}()
+	}
+	wg.Wait()
+}
+
+func TestStatsConcurrentScans(t *testing.T) {
+	leaf := newTestCert(t, testTemplate("localhost"), testKey.Public(), nil, testKey)
+	server := serveChain(testKey, leaf)
+	defer server.Close()
+	host := server.Listener.Addr().String()
+
+	ResetStats()
+	const scans = 10
+	fs := FamilySet{"Handshakes": handshakeFamily(3)}
+	concurrently(scans, func() {
+		if _, err := fs.RunScans(host, "", ""); err != nil {
+			t.Error(err)
+		}
+	})
+
+	want := ScanStats{Dials: scans, CacheHits: 2 * scans}
+	if got := Stats(); got != want {
+		t.Fatalf("expected %+v, got %+v", want, got)
+	}
+
+	ResetStats()
+	if got := Stats(); got != (ScanStats{}) {
+		t.Fatalf("expected counters to be reset, got %+v", got)
+	}
+}
+
+func TestStatsHandshakeFailures(t *testing.T) {
+	// A listener closing every connection it accepts fails every handshake.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	ResetStats()
+	const scans = 10
+	fs := FamilySet{"Handshakes": handshakeFamily(2)}
+	concurrently(scans, func() {
+		if _, err := fs.RunScans(l.Addr().String(), "", ""); err != nil {
+			t.Error(err)
+		}
+	})
+
+	want := ScanStats{Dials: scans, HandshakeFailures: scans, CacheHits: scans}
+	if got := Stats(); got != want {
+		t.Fatalf("expected %+v, got %+v", want, got)
+	}
+}
+
+func TestStatsTimeouts(t *testing.T) {
+	defer func(d time.Duration) { ScannerTimeout = d }(ScannerTimeout)
+	ScannerTimeout = 10 * time.Millisecond
+
+	ResetStats()
+	const scans = 10
+	fs := FamilySet{"Slow": sleepingFamily(2, time.Second)}
+	concurrently(scans, func() {
+		if _, err := fs.RunScans("example.com", "", ""); err != nil {
+			t.Error(err)
+		}
+	})
+
+	if got := Stats().Timeouts; got != 2*scans {
+		t.Fatalf("expected %d timeouts, got %d", 2*scans, got)
+	}
+}
+
+func TestTLSDialDeadline(t *testing.T) {
+	// The listener accepts connections into its backlog but never answers.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	defer func(timeout time.Duration) { Dialer.Timeout = timeout }(Dialer.Timeout)
+	Dialer.Timeout = 100 * time.Millisecond
+	ResetStats()
+	start := time.Now()
+	if _, err := tlsDial(l.Addr().String(), defaultTLSConfig(l.Addr().String())); err == nil {
+		t.Fatal("expected handshake with a silent host to fail")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("handshake with a silent host took %s", elapsed)
+	}
+	if s := Stats(); s.HandshakeFailures != 1 {
+		t.Fatalf("expected the failed handshake to be counted, got %+v", s)
+	}
+}
@@ -0,0 +1,109 @@
+//go:build linux && !386
+// +build linux,!386
+
+package scan
+
+import (
+	"net"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	// tcpFastOpenConnect is the TCP_FASTOPEN_CONNECT socket option, which
+	// defers connecting until the first write so that its data can be sent
+	// in the SYN.
+	tcpFastOpenConnect = 30
+	// tcpiOptSYNData is the tcpi_options flag set once the host acknowledges
+	// data sent in the SYN.
+	tcpiOptSYNData = 0x20
+)
+
+// tcpInfo is the start of Linux's struct tcp_info, up to tcpi_options and
+// the bit fields following it. The kernel fills as much of the structure as
+// the buffer given to getsockopt holds.
+type tcpInfo struct {
+	State       uint8
+	CAState     uint8
+	Retransmits uint8
+	Probes      uint8
+	Backoff     uint8
+	Options     uint8
+	_           [2]uint8
+}
+
+// tcpFastOpen reports whether host accepts data in the SYN through TCP Fast
+// Open. The first connection requests a cookie from the host, which a second
+// connection presents along with the start of a ClientHello. Both wait for
+// the host's reply, since the write returns as soon as the SYN is queued,
+// before the SYN-ACK carrying the cookie or acknowledging the data arrives.
+func tcpFastOpen(host string) (bool, error) {
+	dialer := *Dialer
+	dialer.Control = func(network, address string, c syscall.RawConn) error {
+		var err error
+		if controlErr := c.Control(func(fd uintptr) {
+			err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, tcpFastOpenConnect, 1)
+		}); controlErr != nil {
+			return controlErr
+		}
+		return err
+	}
+
+	var synData bool
+	for i := 0; i < 2; i++ {
+		conn, err := dialWith(&dialer, Network, host)
+		if err != nil {
+			return false, err
+		}
+		if _, err = conn.Write(newClientHello(host).marshal()); err == nil {
+			awaitReply(conn)
+			if i == 1 {
+				synData, err = tcpInfoSYNData(conn)
+			}
+		}
+		conn.Close()
+		if err != nil {
+			return false, err
+		}
+	}
+	return synData, nil
+}
+
+// awaitReply waits up to Dialer.Timeout for the first byte the host sends on
+// conn, by which time the connection is established. Hosts that close the
+// connection or don't reply are left to TCP_INFO, so read errors are ignored.
+func awaitReply(conn net.Conn) {
+	if Dialer.Timeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(Dialer.Timeout))
+	}
+	conn.Read(make([]byte, 1))
+}
+
+// tcpInfoSYNData reports whether the host acknowledged the data conn sent in
+// its SYN, reading TCP_INFO, for which the syscall package has no getter.
+func tcpInfoSYNData(conn net.Conn) (bool, error) {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return false, errTCPFastOpenUnsupported
+	}
+	raw, err := tcpConn.SyscallConn()
+	if err != nil {
+		return false, err
+	}
+	var info tcpInfo
+	size := uint32(unsafe.Sizeof(info))
+	if controlErr := raw.Control(func(fd uintptr) {
+		_, _, errno := syscall.Syscall6(syscall.SYS_GETSOCKOPT, fd, syscall.IPPROTO_TCP, syscall.TCP_INFO,
+			uintptr(unsafe.Pointer(&info)), uintptr(unsafe.Pointer(&size)), 0)
+		if errno != 0 {
+			err = errno
+		}
+	}); controlErr != nil {
+		return false, controlErr
+	}
+	if err != nil {
+		return false, err
+	}
+	return info.Options&tcpiOptSYNData != 0, nil
+}
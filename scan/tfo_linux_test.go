@@ -0,0 +1,76 @@
+//go:build linux && !386
+// +build linux,!386
+
+package scan
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// tcpFastOpenServer is the TCP_FASTOPEN socket option, which lets a listener
+// accept data in the SYN from up to the given number of pending connections.
+const tcpFastOpenServer = 23
+
+// serveFastOpen starts a TCP server with TCP Fast Open enabled that replies
+// to whatever each connection sends first.
+func serveFastOpen(t *testing.T) net.Listener {
+	config := net.ListenConfig{Control: func(network, address string, c syscall.RawConn) error {
+		var err error
+		if controlErr := c.Control(func(fd uintptr) {
+			err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, tcpFastOpenServer, 16)
+		}); controlErr != nil {
+			return controlErr
+		}
+		return err
+	}}
+	l, err := config.Listen(context.Background(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("TCP Fast Open listener unavailable: %v", err)
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if _, err := conn.Read(make([]byte, 1024)); err != nil {
+					return
+				}
+				conn.Write([]byte{0})
+				io.Copy(ioutil.Discard, conn)
+			}()
+		}
+	}()
+	return l
+}
+
+func TestTCPFastOpen(t *testing.T) {
+	// Bit 0 enables TCP Fast Open for clients and bit 1 for servers.
+	sysctl, err := ioutil.ReadFile("/proc/sys/net/ipv4/tcp_fastopen")
+	if err != nil {
+		t.Skip(err)
+	}
+	if mode, err := strconv.Atoi(strings.TrimSpace(string(sysctl))); err != nil || mode&3 != 3 {
+		t.Skipf("TCP Fast Open isn't enabled for both clients and servers (net.ipv4.tcp_fastopen = %s)", strings.TrimSpace(string(sysctl)))
+	}
+
+	l := serveFastOpen(t)
+	defer l.Close()
+	fastOpen, err := tcpFastOpen(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fastOpen {
+		t.Fatal("expected data in the SYN to be accepted by a TCP Fast Open listener")
+	}
+}
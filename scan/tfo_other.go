@@ -0,0 +1,10 @@
+//go:build !linux || 386
+// +build !linux 386
+
+package scan
+
+// tcpFastOpen always fails, since TCP Fast Open is only probed on Linux,
+// where 386 lacks the getsockopt system call reading TCP_INFO.
+func tcpFastOpen(host string) (bool, error) {
+	return false, errTCPFastOpenUnsupported
+}
@@ -2,12 +2,21 @@ package scan
 
 import (
 	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/cloudflare/cf-tls/tls"
+	"golang.org/x/net/dns/dnsmessage"
 )
 
 // TLSHandshake contains scanners testing host cipher suite negotiation
@@ -15,14 +24,82 @@ var TLSHandshake = &Family{
 	Description: "Scans for host's SSL/TLS version and cipher suite negotiation",
 	Scanners: map[string]*Scanner{
 		"CipherSuite": {
-			"Determines host's cipher suites accepted and prefered order",
-			cipherSuiteScan,
+			Description: "Determines host's cipher suites accepted and prefered order",
+			scan:        cipherSuiteScan,
+		},
+		"EncryptedClientHello": {
+			Description: "Host publishes an Encrypted Client Hello configuration in its HTTPS DNS records",
+			scan:        echScan,
+		},
+		"CertificateCompression": {
+			Description: "Host compresses its certificate chain in TLS 1.3 handshakes",
+			scan:        certCompressionScan,
+		},
+		"MaxFragmentLength": {
+			Description: "Host honors requests for smaller TLS records through the max_fragment_length extension",
+			scan:        maxFragmentLengthScan,
+		},
+		"Heartbeat": {
+			Description: "Host doesn't negotiate the deprecated TLS heartbeat extension",
+			scan:        heartbeatScan,
+		},
+		"RenegotiationClientAuth": {
+			Description: "Host doesn't renegotiate mid-connection, as done to request client certificates, which TLS 1.3 can't",
+			scan:        renegotiationScan,
+		},
+		"SNIVirtualHosting": {
+			Description: "Host selects its certificate according to the requested server name",
+			scan:        sniVirtualHostingScan,
+		},
+		"HelloTolerance": {
+			Description: "Host answers ClientHellos with GREASE, unknown extensions or padding as it does a plain one",
+			scan:        helloToleranceScan,
+		},
+		"FalseStart": {
+			Description: "Host negotiates a forward secret AEAD cipher suite and an application protocol, making it eligible for TLS False Start",
+			scan:        falseStartScan,
+		},
+		"DefaultCertDuplicate": {
+			Description: "Host doesn't serve a broad wildcard certificate both for its name and as its default",
+			scan:        defaultCertDuplicateScan,
+		},
+		"DefaultCertLeak": {
+			Description: "Host's default certificate, served for unknown server names, doesn't reveal internal host names",
+			scan:        defaultCertLeakScan,
+		},
+		"UnknownSNI": {
+			Description: "Reports whether host answers an unknown server name with an alert, its default certificate or a closed connection",
+			scan:        unknownSNIScan,
+		},
+		"PostQuantumKeyExchange": {
+			Description: "TLS 1.3 host supports a hybrid post-quantum key exchange group",
+			scan:        postQuantumScan,
+		},
+		"SupportedGroups": {
+			Description: "Host accepts only strong named groups for key exchange",
+			scan:        supportedGroupsScan,
+		},
+		"HandshakeSignature": {
+			Description: "Host signs its TLS 1.2 ECDHE key exchange with a strong signature scheme",
+			scan:        handshakeSignatureScan,
+		},
+		"TLS13CipherSuites": {
+			Description: "TLS 1.3 host supports more than one TLS 1.3 cipher suite",
+			scan:        tls13CipherSuitesScan,
+		},
+		"WeakCipher": {
+			Description: "Host refuses handshakes offering only obsolete 3DES cipher suites",
+			scan:        weakCipherScan,
+		},
+		"DowngradeSentinel": {
+			Description: "TLS 1.3 host signals downgrades to TLS 1.2 in its ServerHello random",
+			scan:        downgradeSentinelScan,
 		},
 	},
 }
 
 func sayHello(host string, ciphers []uint16, vers uint16) (cipherIndex int, err error) {
-	tcpConn, err := net.Dial(Network, host)
+	tcpConn, err := dial(Network, host)
 	if err != nil {
 		return
 	}
@@ -131,3 +208,1072 @@ func cipherSuiteScan(host string) (grade Grade, output Output, err error) {
 	output = cvList
 	return
 }
+
+// downgradeSentinel describes whether a TLS 1.2 ServerHello carried the TLS 1.3 downgrade sentinel.
+type downgradeSentinel bool
+
+func (d downgradeSentinel) String() string {
+	if d {
+		return "TLS 1.2 ServerHello random ends with the TLS 1.3 downgrade sentinel"
+	}
+	return "TLS 1.2 ServerHello random lacks the TLS 1.3 downgrade sentinel"
+}
+
+// downgradeSentinelScan tests that a host supporting TLS 1.3 marks the
+// ServerHello of a TLS 1.2 handshake with the downgrade sentinel, which lets
+// TLS 1.3 clients detect an attacker forcing them to an older version.
+func downgradeSentinelScan(host string) (grade Grade, output Output, err error) {
+	hello := newClientHello(host)
+	hello.offerTLS13()
+	serverHello, err := sendClientHello(host, hello)
+	if err != nil {
+		return
+	}
+	if serverHello.version() != versionTLS13 {
+		return Skipped, nil, nil
+	}
+
+	if serverHello, err = sendClientHello(host, newClientHello(host)); err != nil {
+		return
+	}
+	if serverHello.version() != versionTLS12 {
+		err = fmt.Errorf("server negotiated version %#04x to a TLS 1.2 ClientHello", serverHello.version())
+		return
+	}
+
+	found := bytes.HasSuffix(serverHello.random, downgradeSentinelTLS12)
+	output = downgradeSentinel(found)
+	if !found {
+		grade = Warning
+		return
+	}
+	grade = Good
+	return
+}
+
+// echSupport reports whether the host publishes an ECH configuration, and
+// what is wrong with it if clients can't use it.
+type echSupport struct {
+	Published bool   `json:"published"`
+	Problem   string `json:"problem,omitempty"`
+}
+
+func (ech echSupport) String() string {
+	switch {
+	case ech.Problem != "":
+		return "ECH configuration published, but " + ech.Problem
+	case ech.Published:
+		return "ECH configuration published"
+	}
+	return "no ECH configuration published"
+}
+
+// echVersion is the ECHConfig version of the Encrypted Client Hello draft
+// clients implement.
+const echVersion = 0xfe0d
+
+// echConfigProblem describes why config, the value of an ech SvcParam, isn't
+// an ECHConfigList a client can use, or returns "". The list is a two-byte
+// length followed by configurations, each a two-byte version and a two-byte
+// length followed by its contents, at least one of which must be of
+// echVersion.
+func echConfigProblem(config []byte) string {
+	if len(config) < 2 || int(config[0])<<8|int(config[1]) != len(config)-2 {
+		return "its length is wrong"
+	}
+	var supported bool
+	for rest := config[2:]; len(rest) > 0; {
+		if len(rest) < 4 {
+			return "a configuration is truncated"
+		}
+		version, length := int(rest[0])<<8|int(rest[1]), int(rest[2])<<8|int(rest[3])
+		if len(rest) < 4+length {
+			return "a configuration is truncated"
+		}
+		supported = supported || version == echVersion
+		rest = rest[4+length:]
+	}
+	if !supported {
+		return fmt.Sprintf("no configuration is of version %#x", echVersion)
+	}
+	return ""
+}
+
+// httpsRecordName returns the name of the HTTPS DNS records for host, which
+// are prefixed with the port unless it is the default.
+func httpsRecordName(host string) (string, error) {
+	hostname, port, err := net.SplitHostPort(host)
+	if err != nil {
+		return "", err
+	}
+	if port == "443" {
+		return hostname, nil
+	}
+	return "_" + port + "._https." + hostname, nil
+}
+
+// echScan tests that the host's HTTPS DNS records carry an Encrypted Client
+// Hello configuration, allowing clients to hide the server name they request.
+// ECH is still rarely deployed, so hosts without it are graded Notice, while
+// a configuration clients can't use is a Warning.
+func echScan(host string) (grade Grade, output Output, err error) {
+	name, err := httpsRecordName(host)
+	if err != nil {
+		return
+	}
+	records, err := DNSResolver.LookupHTTPS(name)
+	if err != nil {
+		return
+	}
+
+	var ech echSupport
+	for _, record := range records {
+		if config, ok := record.GetParam(dnsmessage.SVCParamECH); ok && len(config) > 0 {
+			ech.Published = true
+			if ech.Problem = echConfigProblem(config); ech.Problem == "" {
+				return Good, ech, nil
+			}
+		}
+	}
+	if ech.Published {
+		return Warning, ech, nil
+	}
+	return Notice, ech, nil
+}
+
+// certCompression names the algorithm a host compressed its certificate
+// chain with, if any.
+type certCompression string
+
+func (c certCompression) String() string {
+	if c == "" {
+		return "certificate compression is not supported by the TLS library"
+	}
+	return "certificate chain compressed with " + string(c)
+}
+
+// certCompressionScan tests that the host compresses its certificate chain
+// with brotli, zlib or zstd as described by RFC 8879. The compressed chain is
+// only sent within the encrypted part of a TLS 1.3 handshake, which cf-tls
+// can't perform, so every host is Skipped until it supports the extension.
+func certCompressionScan(host string) (grade Grade, output Output, err error) {
+	return Skipped, certCompression(""), nil
+}
+
+// sniProbeName is a server name no host is expected to serve, requested to
+// see whether the certificate a host presents depends on SNI.
+var sniProbeName = "sni-probe.invalid"
+
+// sniCertificate records the certificate presented for a requested server name.
+type sniCertificate struct {
+	ServerName string `json:"server_name"`
+	CommonName string `json:"common_name,omitempty"`
+	Refused    bool   `json:"refused,omitempty"`
+}
+
+type sniCertificates []sniCertificate
+
+func (certs sniCertificates) String() string {
+	lines := make([]string, len(certs))
+	for i, cert := range certs {
+		name := cert.ServerName
+		if net.ParseIP(name) != nil {
+			// Clients don't send IP addresses as server names.
+			name = "no SNI"
+		}
+		if cert.Refused {
+			lines[i] = name + ": handshake refused"
+		} else {
+			lines[i] = name + ": " + cert.CommonName
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// leafForServerName returns the leaf certificate the host presents when
+// serverName is requested.
+func leafForServerName(host, serverName string) (*x509.Certificate, error) {
+	config := defaultTLSConfig(host)
+	config.ServerName = serverName
+	conn, err := tlsDial(host, config)
+	if err != nil {
+		return nil, err
+	}
+	conn.Close()
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, errNoCertificates
+	}
+	return certs[0], nil
+}
+
+// sniVirtualHostingScan tests that the host presents a different certificate,
+// or refuses the handshake, when a server name it doesn't serve is requested.
+// A host presenting the same certificate whatever the name ignores SNI, which
+// breaks hosting several names on one address.
+func sniVirtualHostingScan(host string) (grade Grade, output Output, err error) {
+	hostname, _, err := net.SplitHostPort(host)
+	if err != nil {
+		return
+	}
+	leaf, err := leafForServerName(host, hostname)
+	if err != nil {
+		return
+	}
+	certs := sniCertificates{{ServerName: hostname, CommonName: leaf.Subject.CommonName}}
+
+	probe, err := leafForServerName(host, sniProbeName)
+	if err != nil {
+		certs = append(certs, sniCertificate{ServerName: sniProbeName, Refused: true})
+		return Good, certs, nil
+	}
+	certs = append(certs, sniCertificate{ServerName: sniProbeName, CommonName: probe.Subject.CommonName})
+	output = certs
+	if bytes.Equal(leaf.Raw, probe.Raw) {
+		grade = Warning
+		return
+	}
+	grade = Good
+	return
+}
+
+// defaultCertLeakScan requests sniProbeName, a server name the host doesn't
+// serve, and tests that the default certificate it falls back to names no
+// internal hosts or private addresses. Anyone can fetch that certificate, so
+// such names map out the network behind the host. Hosts that refuse the
+// handshake are Skipped.
+func defaultCertLeakScan(host string) (grade Grade, output Output, err error) {
+	leaf, err := leafForServerName(host, sniProbeName)
+	if err != nil {
+		return Skipped, nil, nil
+	}
+
+	var leaked domainList
+	for _, name := range leaf.DNSNames {
+		if isInternalName(name) {
+			leaked = append(leaked, name)
+		}
+	}
+	for _, ip := range leaf.IPAddresses {
+		if ip.IsPrivate() {
+			leaked = append(leaked, ip.String())
+		}
+	}
+	if len(leaked) > 0 {
+		return Warning, leaked, nil
+	}
+	return Good, nil, nil
+}
+
+// defaultCertDuplicate compares the certificates presented for the host's
+// name and for an unknown server name.
+type defaultCertDuplicate struct {
+	Certificates   sniCertificates `json:"certificates"`
+	Identical      bool            `json:"identical"`
+	BroadWildcards []string        `json:"broad_wildcards,omitempty"`
+}
+
+func (d defaultCertDuplicate) String() string {
+	comparison := "different certificates"
+	if d.Identical {
+		comparison = "identical certificates"
+	}
+	if len(d.BroadWildcards) > 0 {
+		comparison += ", covering " + strings.Join(d.BroadWildcards, ", ")
+	}
+	return d.Certificates.String() + "\n" + comparison
+}
+
+// defaultCertDuplicateScan compares the certificate the host presents for its
+// own name with the default one it falls back to for sniProbeName, and tests
+// that they aren't the same certificate with a broad wildcard name. A
+// wildcard over the whole domain served to any client, whatever name it asks
+// for, suggests the certificate is broader than the service needs. Hosts
+// that refuse the unknown name present no default certificate and are Good.
+func defaultCertDuplicateScan(host string) (grade Grade, output Output, err error) {
+	hostname, _, err := net.SplitHostPort(host)
+	if err != nil {
+		return
+	}
+	leaf, err := leafForServerName(host, hostname)
+	if err != nil {
+		return
+	}
+	duplicate := defaultCertDuplicate{
+		Certificates: sniCertificates{{ServerName: hostname, CommonName: leaf.Subject.CommonName}},
+	}
+
+	probe, probeErr := leafForServerName(host, sniProbeName)
+	if probeErr != nil {
+		duplicate.Certificates = append(duplicate.Certificates, sniCertificate{ServerName: sniProbeName, Refused: true})
+		return Good, duplicate, nil
+	}
+	duplicate.Certificates = append(duplicate.Certificates, sniCertificate{ServerName: sniProbeName, CommonName: probe.Subject.CommonName})
+	duplicate.Identical = bytes.Equal(leaf.Raw, probe.Raw)
+	if duplicate.Identical {
+		for _, name := range leaf.DNSNames {
+			if isBroadWildcard(name) {
+				duplicate.BroadWildcards = append(duplicate.BroadWildcards, name)
+			}
+		}
+	}
+	output = duplicate
+	if len(duplicate.BroadWildcards) > 0 {
+		grade = Warning
+		return
+	}
+	grade = Good
+	return
+}
+
+// unknownSNIBehavior describes how a host answered a ClientHello requesting a
+// server name it doesn't serve: with an alert, with its default certificate,
+// or by closing the connection.
+type unknownSNIBehavior struct {
+	ServerName string `json:"server_name"`
+	Behavior   string `json:"behavior"`
+	Alert      string `json:"alert,omitempty"`
+	CommonName string `json:"common_name,omitempty"`
+}
+
+func (b unknownSNIBehavior) String() string {
+	switch {
+	case b.Alert != "":
+		return b.ServerName + ": " + b.Behavior + " (" + b.Alert + ")"
+	case b.CommonName != "":
+		return b.ServerName + ": " + b.Behavior + " (" + b.CommonName + ")"
+	}
+	return b.ServerName + ": " + b.Behavior
+}
+
+// unknownSNIScan requests a random server name the host can't serve and
+// reports how it answers, which characterizes its virtual hosting: an
+// unrecognized_name or other alert, its default certificate, or a closed
+// connection. The grade is informational.
+func unknownSNIScan(host string) (grade Grade, output Output, err error) {
+	label := make([]byte, 8)
+	rand.Read(label)
+	behavior := unknownSNIBehavior{ServerName: hex.EncodeToString(label) + ".invalid"}
+
+	conn, err := dial(Network, host)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(helloTimeout))
+	hello := newClientHello(host)
+	hello.setExtension(serverNameExtension(behavior.ServerName))
+	if _, err = conn.Write(hello.marshal()); err != nil {
+		return
+	}
+
+	r := &handshakeReader{conn: conn}
+	_, err = r.serverHello()
+	if a, ok := err.(alert); ok {
+		level := "fatal"
+		if r.alertLevel == alertLevelWarning {
+			level = "warning"
+		}
+		name, ok := alertNames[a]
+		if !ok {
+			name = fmt.Sprint(uint8(a))
+		}
+		behavior.Behavior, behavior.Alert = "alert", level+" "+name
+		return Good, behavior, nil
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return
+	}
+	if err != nil {
+		behavior.Behavior = "connection closed"
+		return Good, behavior, nil
+	}
+
+	behavior.Behavior = "default certificate"
+	if typ, body, certErr := r.next(); certErr == nil && typ == typeCertificate && len(body) >= 6 {
+		length := int(body[3])<<16 | int(body[4])<<8 | int(body[5])
+		if len(body) >= 6+length {
+			if leaf, parseErr := x509.ParseCertificate(body[6 : 6+length]); parseErr == nil {
+				behavior.CommonName = leaf.Subject.CommonName
+			}
+		}
+	}
+	return Good, behavior, nil
+}
+
+const (
+	// greaseValue is one of the GREASE code points reserved by RFC 8701 for
+	// clients to offer so that servers learn to ignore unknown values.
+	greaseValue uint16 = 0x0a0a
+	// extensionUnknown is an extension type from the private use range, which
+	// no server is expected to understand.
+	extensionUnknown uint16 = 0xffce
+	// paddedHelloSize is the size a ClientHello is padded to, beyond the
+	// 256 to 511 byte range some stacks are known to choke on.
+	paddedHelloSize = 1024
+)
+
+// helloVariants are unusual but valid ClientHellos a server should answer as
+// it does a plain one, each made by modifying a plain ClientHello.
+var helloVariants = []struct {
+	name   string
+	modify func(*clientHello)
+}{
+	{"GREASE", func(h *clientHello) {
+		h.cipherSuites = append([]uint16{greaseValue}, h.cipherSuites...)
+		h.setExtension(supportedGroupsExtension(append([]uint16{greaseValue}, helloGroups...)...))
+		h.setExtension(helloExtension{greaseValue, nil})
+	}},
+	{"unknown extension", func(h *clientHello) {
+		h.setExtension(helloExtension{extensionUnknown, []byte{0, 1, 2, 3}})
+	}},
+	{"padded", func(h *clientHello) {
+		// The padding extension's own header takes 4 bytes.
+		if pad := paddedHelloSize - len(h.marshal()) - 4; pad > 0 {
+			h.setExtension(helloExtension{extensionPadding, make([]byte, pad)})
+		}
+	}},
+}
+
+// helloTolerance lists how the host answered each ClientHello variant.
+type helloTolerance []helloVariantResult
+
+// helloVariantResult describes how the host answered a ClientHello variant.
+type helloVariantResult struct {
+	Variant string `json:"variant"`
+	Result  string `json:"result"`
+	// Tolerated is set if the host answered with a ServerHello.
+	Tolerated bool `json:"tolerated"`
+}
+
+func (results helloTolerance) String() string {
+	lines := make([]string, len(results))
+	for i, r := range results {
+		lines[i] = r.Variant + ": " + r.Result
+	}
+	return strings.Join(lines, "\n")
+}
+
+// helloToleranceScan sends the host each of helloVariants, and tests that it
+// answers them with a ServerHello as it does a plain ClientHello. A host
+// that breaks on GREASE, unknown extensions or large ClientHellos has a
+// fragile TLS stack that new clients may fail to connect to, and is graded
+// Warning. Hosts refusing a plain ClientHello with an alert are Skipped, and
+// failing to reach the host at all is an error.
+func helloToleranceScan(host string) (grade Grade, output Output, err error) {
+	if _, err = sendClientHello(host, newClientHello(host)); err != nil {
+		if _, ok := err.(alert); ok {
+			return Skipped, nil, nil
+		}
+		return
+	}
+
+	var results helloTolerance
+	grade = Good
+	for _, variant := range helloVariants {
+		hello := newClientHello(host)
+		variant.modify(hello)
+		result := helloVariantResult{Variant: variant.name, Result: "handshakes normally", Tolerated: true}
+		if _, helloErr := sendClientHello(host, hello); helloErr != nil {
+			result.Result, result.Tolerated = helloErr.Error(), false
+			grade = Warning
+		}
+		results = append(results, result)
+	}
+	return grade, results, nil
+}
+
+// falseStartProtocols are the application protocols offered through ALPN when
+// testing False Start eligibility.
+var falseStartProtocols = []string{"h2", "http/1.1"}
+
+// falseStartEligibility records which of the conditions clients set for TLS
+// False Start a TLS 1.2 connection to the host meets.
+type falseStartEligibility struct {
+	ForwardSecret bool   `json:"forward_secret"`
+	AEAD          bool   `json:"aead"`
+	Protocol      string `json:"protocol,omitempty"`
+}
+
+func (e falseStartEligibility) String() string {
+	status := func(met bool) string {
+		if met {
+			return "met"
+		}
+		return "unmet"
+	}
+	return "forward secret key exchange: " + status(e.ForwardSecret) +
+		"\nAEAD cipher: " + status(e.AEAD) +
+		"\nALPN or NPN protocol negotiated: " + status(e.Protocol != "")
+}
+
+// containsSuite reports whether suites includes suite.
+func containsSuite(suites []uint16, suite uint16) bool {
+	for _, s := range suites {
+		if s == suite {
+			return true
+		}
+	}
+	return false
+}
+
+// noTLS12 notes that the host refuses TLS 1.2 handshakes.
+type noTLS12 struct{}
+
+func (noTLS12) String() string {
+	return "TLS 1.2 not supported"
+}
+
+// MarshalJSON encodes the output as its description.
+func (n noTLS12) MarshalJSON() ([]byte, error) {
+	return json.Marshal(n.String())
+}
+
+// falseStartScan tests whether a TLS 1.2 connection to the host is eligible
+// for False Start, which saves clients a round trip by sending application
+// data before the handshake completes. Clients only do so once a forward
+// secret key exchange, an AEAD cipher and an application protocol negotiated
+// through ALPN or NPN are all in place. False Start is optional, so
+// ineligible hosts are graded Notice. Hosts refusing TLS 1.2, whose TLS 1.3
+// handshakes need no False Start, are Skipped.
+func falseStartScan(host string) (grade Grade, output Output, err error) {
+	config := defaultTLSConfig(host)
+	config.MaxVersion = tls.VersionTLS12
+	config.NextProtos = falseStartProtocols
+	conn, err := tlsDial(host, config)
+	if err != nil {
+		if _, helloErr := sendClientHello(host, newClientHello(host)); helloErr != nil {
+			if _, ok := helloErr.(alert); ok {
+				return Skipped, noTLS12{}, nil
+			}
+		}
+		return
+	}
+	state := conn.ConnectionState()
+	conn.Close()
+
+	eligibility := falseStartEligibility{
+		ForwardSecret: containsSuite(ecdheCipherSuites, state.CipherSuite),
+		AEAD:          containsSuite(aeadCipherSuites, state.CipherSuite),
+		Protocol:      state.NegotiatedProtocol,
+	}
+	output = eligibility
+	if !eligibility.ForwardSecret || !eligibility.AEAD || eligibility.Protocol == "" {
+		grade = Notice
+		return
+	}
+	grade = Good
+	return
+}
+
+// maxFragmentLength512 is the max_fragment_length code requesting records of
+// at most 2^9 bytes, the smallest defined by RFC 6066.
+const maxFragmentLength512 uint8 = 1
+
+// fragmentLength reports whether the host agreed to limit its records to 512 bytes.
+type fragmentLength bool
+
+func (f fragmentLength) String() string {
+	if f {
+		return "max_fragment_length of 512 bytes honored"
+	}
+	return "max_fragment_length not negotiated"
+}
+
+// maxFragmentLengthScan tests whether the host agrees to send records of at
+// most 512 bytes when asked through the max_fragment_length extension, which
+// helps clients on lossy links. Support is optional, so hosts that don't
+// negotiate the extension are Skipped rather than penalized.
+func maxFragmentLengthScan(host string) (grade Grade, output Output, err error) {
+	hello := newClientHello(host)
+	hello.setExtension(maxFragmentLengthExtension(maxFragmentLength512))
+	serverHello, err := sendClientHello(host, hello)
+	if err != nil {
+		return
+	}
+
+	ext, ok := serverHello.extensions[extensionMaxFragmentLength]
+	if !ok {
+		return Skipped, fragmentLength(false), nil
+	}
+	if len(ext) != 1 || ext[0] != maxFragmentLength512 {
+		err = fmt.Errorf("server answered max_fragment_length %d with %x", maxFragmentLength512, ext)
+		return
+	}
+	return Good, fragmentLength(true), nil
+}
+
+// heartbeatSupport reports whether the host acknowledged the heartbeat extension.
+type heartbeatSupport bool
+
+func (h heartbeatSupport) String() string {
+	if h {
+		return "heartbeat extension negotiated"
+	}
+	return "heartbeat extension not negotiated"
+}
+
+// heartbeatScan offers the TLS heartbeat extension, which is of no use to
+// HTTPS and whose implementation bugs such as Heartbleed leaked server memory,
+// and grades hosts acknowledging it Warning.
+func heartbeatScan(host string) (grade Grade, output Output, err error) {
+	hello := newClientHello(host)
+	hello.setExtension(heartbeatExtension(heartbeatPeerAllowedToSend))
+	serverHello, err := sendClientHello(host, hello)
+	if err != nil {
+		return
+	}
+
+	if _, ok := serverHello.extensions[extensionHeartbeat]; ok {
+		return Warning, heartbeatSupport(true), nil
+	}
+	return Good, heartbeatSupport(false), nil
+}
+
+// renegotiationTimeout bounds how long renegotiationScan waits for the host's response.
+var renegotiationTimeout = 5 * time.Second
+
+// renegotiationBehavior describes whether the host renegotiated after a request.
+type renegotiationBehavior string
+
+func (r renegotiationBehavior) String() string {
+	return string(r)
+}
+
+// renegotiationScan sends an HTTP request over a TLS 1.2 connection and
+// watches for the host starting a new handshake before answering it, which
+// servers do to request a client certificate for particular resources. TLS
+// 1.3 removed renegotiation, so such hosts can't move to it without changing
+// how they authenticate clients. The tls package refuses to renegotiate, but
+// the handshake record the host sends is visible beneath it. Hosts that
+// don't answer the request are Skipped.
+func renegotiationScan(host string) (grade Grade, output Output, err error) {
+	tcpConn, err := dial(Network, host)
+	if err != nil {
+		return
+	}
+	rc := &recordConn{Conn: tcpConn}
+	config := defaultTLSConfig(host)
+	// Record types are only visible before TLS 1.3, which lacks renegotiation anyway.
+	config.MaxVersion = tls.VersionTLS12
+	conn := tls.Client(rc, config)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(renegotiationTimeout))
+
+	if err = conn.Handshake(); err != nil {
+		atomic.AddUint64(&stats.HandshakeFailures, 1)
+		return
+	}
+	rc.lastType = 0
+	if _, err = fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: %s\r\nConnection: close\r\n\r\n", config.ServerName); err != nil {
+		return
+	}
+
+	_, readErr := io.Copy(ioutil.Discard, conn)
+	if rc.lastType == recordTypeHandshake {
+		return Warning, renegotiationBehavior("host started renegotiating after the request, as done to request a client certificate"), nil
+	}
+	if netErr, ok := readErr.(net.Error); ok && netErr.Timeout() {
+		return Skipped, renegotiationBehavior("host didn't answer the request"), nil
+	}
+	return Good, renegotiationBehavior("host answered the request without renegotiating"), nil
+}
+
+// tls13Suites lists the TLS 1.3 cipher suites a host accepts.
+type tls13Suites []uint16
+
+func (suites tls13Suites) names() []string {
+	names := make([]string, len(suites))
+	for i, suite := range suites {
+		names[i] = tls13CipherSuiteNames[suite]
+	}
+	return names
+}
+
+func (suites tls13Suites) String() string {
+	return strings.Join(suites.names(), "\n")
+}
+
+// MarshalJSON encodes the cipher suites as a list of their names.
+func (suites tls13Suites) MarshalJSON() ([]byte, error) {
+	return json.Marshal(suites.names())
+}
+
+// tls13CipherSuitesScan offers each TLS 1.3 cipher suite on its own to find
+// those the host accepts. A host accepting a single suite leaves no
+// alternative should its algorithm be broken. Hosts not supporting TLS 1.3
+// are Skipped.
+func tls13CipherSuitesScan(host string) (grade Grade, output Output, err error) {
+	var suites tls13Suites
+	for _, suite := range tls13CipherSuites {
+		hello := newClientHello(host)
+		hello.offerTLS13()
+		hello.cipherSuites = []uint16{suite}
+		hello.setExtension(supportedVersionsExtension(versionTLS13))
+		serverHello, helloErr := sendClientHello(host, hello)
+		if _, ok := helloErr.(alert); ok {
+			continue
+		}
+		if helloErr != nil {
+			err = helloErr
+			return
+		}
+		if serverHello.version() != versionTLS13 || serverHello.cipherSuite != suite {
+			err = fmt.Errorf("server answered a TLS 1.3 ClientHello offering only %s with version %#04x and cipher suite %#04x",
+				tls13CipherSuiteNames[suite], serverHello.version(), serverHello.cipherSuite)
+			return
+		}
+		suites = append(suites, suite)
+	}
+
+	switch len(suites) {
+	case 0:
+		grade = Skipped
+	case 1:
+		grade, output = Warning, suites
+	default:
+		grade, output = Good, suites
+	}
+	return
+}
+
+var (
+	// strongGroups are the named groups accepted for key exchange.
+	strongGroups = []uint16{groupX25519, groupX448, groupP256, groupP384, groupP521}
+	// weakGroups are deprecated named groups too small for key exchange.
+	weakGroups = []uint16{
+		groupSecp160k1, groupSecp160r1, groupSecp160r2,
+		groupSecp192k1, groupSecp192r1,
+		groupSecp224k1, groupSecp224r1,
+	}
+)
+
+// namedGroups lists the named groups a host accepts for key exchange.
+type namedGroups []uint16
+
+func (groups namedGroups) names() []string {
+	names := make([]string, len(groups))
+	for i, group := range groups {
+		names[i] = groupNames[group]
+	}
+	return names
+}
+
+func (groups namedGroups) String() string {
+	return strings.Join(groups.names(), "\n")
+}
+
+// MarshalJSON encodes the groups as a list of their names.
+func (groups namedGroups) MarshalJSON() ([]byte, error) {
+	return json.Marshal(groups.names())
+}
+
+// acceptsGroup reports whether the host negotiates ECDHE key exchange in
+// TLS 1.2, or a TLS 1.3 key exchange, when group is the only named group
+// offered.
+func acceptsGroup(host string, group uint16) (bool, error) {
+	ok, err := acceptsGroupTLS12(host, group)
+	if ok || err != nil {
+		return ok, err
+	}
+	return acceptsGroupTLS13(host, group)
+}
+
+// acceptsGroupTLS12 reports whether the host negotiates ECDHE key exchange
+// in TLS 1.2 when group is the only named group offered. The group is read
+// from the ServerKeyExchange, since a host may ignore the groups offered.
+func acceptsGroupTLS12(host string, group uint16) (bool, error) {
+	hello := newClientHello(host)
+	hello.cipherSuites = ecdheCipherSuites
+	hello.setExtension(supportedGroupsExtension(group))
+	serverHello, keyExchange, err := sendServerKeyExchangeHello(host, hello)
+	if _, ok := err.(alert); ok {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	offered := false
+	for _, suite := range ecdheCipherSuites {
+		offered = offered || serverHello.cipherSuite == suite
+	}
+	if !offered {
+		return false, fmt.Errorf("server negotiated cipher suite %#04x, which wasn't offered", serverHello.cipherSuite)
+	}
+	if keyExchange == nil {
+		return false, errors.New("server sent no ServerKeyExchange for an ECDHE cipher suite")
+	}
+	selected, _, err := parseServerKeyExchange(keyExchange)
+	if err != nil {
+		return false, err
+	}
+	return selected == group, nil
+}
+
+// acceptsGroupTLS13 reports whether the host accepts group for TLS 1.3 key
+// exchange when it is the only named group offered. No key is shared, so a
+// host accepting the group asks for one in a HelloRetryRequest.
+func acceptsGroupTLS13(host string, group uint16) (bool, error) {
+	hello := newClientHello(host)
+	hello.offerTLS13()
+	hello.cipherSuites = tls13CipherSuites
+	hello.setExtension(supportedVersionsExtension(versionTLS13))
+	hello.setExtension(supportedGroupsExtension(group))
+	hello.setExtension(keySharesExtension(nil))
+	serverHello, err := sendClientHello(host, hello)
+	if _, ok := err.(alert); ok {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if serverHello.version() != versionTLS13 {
+		return false, fmt.Errorf("server answered a TLS 1.3 ClientHello with version %#04x", serverHello.version())
+	}
+	if selected, ok := serverHello.keyShareGroup(); !ok || selected != group {
+		return false, fmt.Errorf("server selected group %#04x, which wasn't offered", selected)
+	}
+	return true, nil
+}
+
+// supportedGroupsScan offers each named group on its own, over TLS 1.2 and
+// then TLS 1.3, to find those the host accepts for key exchange, graded
+// Warning if any weak group is accepted. Hosts accepting no group are
+// Skipped.
+func supportedGroupsScan(host string) (grade Grade, output Output, err error) {
+	var accepted namedGroups
+	weak := false
+	for _, group := range append(append([]uint16{}, strongGroups...), weakGroups...) {
+		ok, err := acceptsGroup(host, group)
+		if err != nil {
+			return Bad, nil, err
+		}
+		if !ok {
+			continue
+		}
+		accepted = append(accepted, group)
+		for _, weakGroup := range weakGroups {
+			weak = weak || group == weakGroup
+		}
+	}
+
+	switch {
+	case len(accepted) == 0:
+		grade = Skipped
+	case weak:
+		grade, output = Warning, accepted
+	default:
+		grade, output = Good, accepted
+	}
+	return
+}
+
+// weakSignatureSchemes are the signature schemes relying on SHA-1.
+var weakSignatureSchemes = []uint16{0x0201, 0x0203}
+
+// signatureScheme is the signature scheme a host signed its handshake with.
+type signatureScheme uint16
+
+func (s signatureScheme) String() string {
+	if name, ok := signatureSchemeNames[uint16(s)]; ok {
+		return name
+	}
+	return fmt.Sprintf("%#04x", uint16(s))
+}
+
+// MarshalJSON encodes the signature scheme as its name.
+func (s signatureScheme) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// parseServerKeyExchange returns the named group and signature scheme of a
+// TLS 1.2 ECDHE ServerKeyExchange message.
+func parseServerKeyExchange(body []byte) (group, scheme uint16, err error) {
+	r := helloReader(body)
+	curveType, err := r.uint8()
+	if err != nil {
+		return
+	}
+	if curveType != 3 {
+		err = fmt.Errorf("unsupported ECDHE curve type %d", curveType)
+		return
+	}
+	if group, err = r.uint16(); err != nil {
+		return
+	}
+	pointLen, err := r.uint8()
+	if err != nil {
+		return
+	}
+	if _, err = r.next(int(pointLen)); err != nil {
+		return
+	}
+	scheme, err = r.uint16()
+	return
+}
+
+// sendServerKeyExchangeHello sends hello to host and returns the ServerHello
+// it responds with, and the body of the ServerKeyExchange following it, which
+// is nil if the host reaches ServerHelloDone without sending one.
+func sendServerKeyExchangeHello(host string, hello *clientHello) (*serverHello, []byte, error) {
+	conn, err := dial(Network, host)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(helloTimeout))
+	if _, err = conn.Write(hello.marshal()); err != nil {
+		return nil, nil, err
+	}
+
+	r := &handshakeReader{conn: conn}
+	serverHello, err := r.serverHello()
+	if err != nil {
+		return nil, nil, err
+	}
+	for {
+		typ, body, err := r.next()
+		if err != nil {
+			return nil, nil, err
+		}
+		switch typ {
+		case typeServerKeyExchange:
+			return serverHello, body, nil
+		case typeServerHelloDone:
+			return serverHello, nil, nil
+		}
+	}
+}
+
+// handshakeSignatureScan offers the host ECDHE cipher suites and every
+// signature scheme in helloSignatureSchemes over TLS 1.2, and reports the
+// scheme it signs its ServerKeyExchange with. Schemes relying on SHA-1 are
+// graded Warning. Hosts not supporting ECDHE, or negotiating an earlier
+// version whose ServerKeyExchange names no scheme, are Skipped.
+func handshakeSignatureScan(host string) (grade Grade, output Output, err error) {
+	hello := newClientHello(host)
+	hello.cipherSuites = ecdheCipherSuites
+
+	serverHello, keyExchange, err := sendServerKeyExchangeHello(host, hello)
+	if _, ok := err.(alert); ok {
+		return Skipped, nil, nil
+	}
+	if err != nil {
+		return
+	}
+	if serverHello.version() < versionTLS12 {
+		return Skipped, noTLS12{}, nil
+	}
+	if keyExchange == nil {
+		return Skipped, nil, nil
+	}
+	_, scheme, err := parseServerKeyExchange(keyExchange)
+	if err != nil {
+		return
+	}
+	grade, output = Good, signatureScheme(scheme)
+	for _, weak := range weakSignatureSchemes {
+		if scheme == weak {
+			grade = Warning
+		}
+	}
+	return
+}
+
+// postQuantumGroups are the hybrid post-quantum groups offered by postQuantumScan.
+var postQuantumGroups = []uint16{groupX25519MLKEM768, groupX25519Kyber768Draft00}
+
+// pqSupport names the post-quantum group a host negotiated, if any.
+type pqSupport string
+
+func (pq pqSupport) String() string {
+	if pq == "" {
+		return "no post-quantum key exchange group negotiated"
+	}
+	return "post-quantum key exchange negotiated with " + string(pq)
+}
+
+// postQuantumScan tests whether the host negotiates a hybrid post-quantum key
+// exchange, which protects recorded traffic from later decryption by a
+// quantum computer. cf-tls can't offer these groups, so they are offered in a
+// hand-built ClientHello whose ML-KEM keys are all zeros: valid encodings the
+// server can encapsulate to, though no handshake is ever completed. Support
+// is still uncommon, so hosts without it are Skipped rather than penalized.
+func postQuantumScan(host string) (grade Grade, output Output, err error) {
+	x25519Key := make([]byte, 32)
+	rand.Read(x25519Key)
+	mlkemKey := make([]byte, mlkem768EncapsulationKeySize)
+	keys := map[uint16][]byte{
+		groupX25519MLKEM768:        append(append([]byte{}, mlkemKey...), x25519Key...),
+		groupX25519Kyber768Draft00: append(append([]byte{}, x25519Key...), mlkemKey...),
+	}
+
+	hello := newClientHello(host)
+	hello.offerTLS13()
+	hello.setExtension(supportedVersionsExtension(versionTLS13))
+	hello.setExtension(supportedGroupsExtension(postQuantumGroups...))
+	hello.setExtension(keySharesExtension(keys, postQuantumGroups...))
+	serverHello, err := sendClientHello(host, hello)
+	if _, ok := err.(alert); ok {
+		return Skipped, pqSupport(""), nil
+	}
+	if err != nil {
+		return
+	}
+
+	group, ok := serverHello.keyShareGroup()
+	if serverHello.version() != versionTLS13 || !ok {
+		err = errors.New("server didn't negotiate TLS 1.3 key exchange")
+		return
+	}
+	for _, pqGroup := range postQuantumGroups {
+		if group == pqGroup {
+			return Good, pqSupport(groupNames[group]), nil
+		}
+	}
+	err = fmt.Errorf("server selected group %#04x, which wasn't offered", group)
+	return
+}
+
+// weakCipherSuites are the obsolete 3DES cipher suites offered by weakCipherScan.
+var weakCipherSuites = []uint16{
+	0xc012, // TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA
+	0xc008, // TLS_ECDHE_ECDSA_WITH_3DES_EDE_CBC_SHA
+	0x000a, // TLS_RSA_WITH_3DES_EDE_CBC_SHA
+}
+
+// weakCipherHandshake describes the outcome of a handshake offering only weak cipher suites.
+type weakCipherHandshake struct {
+	Accepted string `json:"accepted,omitempty"`
+	Refusal  string `json:"refusal,omitempty"`
+}
+
+func (h weakCipherHandshake) String() string {
+	if h.Accepted != "" {
+		return "host accepted " + h.Accepted
+	}
+	return "host refused 3DES cipher suites: " + h.Refusal
+}
+
+// weakCipherScan offers only 3DES cipher suites, which are vulnerable to
+// Sweet32, and tests that the host refuses them rather than falling back to
+// them for the sake of compatibility.
+func weakCipherScan(host string) (grade Grade, output Output, err error) {
+	hello := newClientHello(host)
+	hello.cipherSuites = weakCipherSuites
+	serverHello, err := sendClientHello(host, hello)
+	if refusal, ok := err.(alert); ok {
+		return Good, weakCipherHandshake{Refusal: refusal.Error()}, nil
+	}
+	if err != nil {
+		return
+	}
+
+	for _, suite := range weakCipherSuites {
+		if serverHello.cipherSuite == suite {
+			return Bad, weakCipherHandshake{Accepted: tls.CipherSuites[suite].String()}, nil
+		}
+	}
+	err = fmt.Errorf("server negotiated cipher suite %#04x, which wasn't offered", serverHello.cipherSuite)
+	return
+}
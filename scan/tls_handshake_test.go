@@ -0,0 +1,847 @@
+package scan
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// serveTLSVersions starts an HTTPS server supporting TLS versions up to maxVersion.
+func serveTLSVersions(maxVersion uint16) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{MaxVersion: maxVersion}
+	server.StartTLS()
+	return server
+}
+
+func TestDowngradeSentinelScan(t *testing.T) {
+	server := serveTLSVersions(tls.VersionTLS13)
+	defer server.Close()
+
+	grade, output, err := downgradeSentinelScan(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grade != Good {
+		t.Fatalf("expected TLS 1.3 server to signal downgrades, got %s (%s)", grade, output)
+	}
+}
+
+func TestDowngradeSentinelScanTLS12(t *testing.T) {
+	server := serveTLSVersions(tls.VersionTLS12)
+	defer server.Close()
+
+	grade, _, err := downgradeSentinelScan(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grade != Skipped {
+		t.Fatalf("expected scan of TLS 1.2 server to be skipped, got %s", grade)
+	}
+}
+
+func TestECHScan(t *testing.T) {
+	resolver := stubResolver{https: map[string][]dnsmessage.HTTPSResource{
+		"ech.example.com":              {testHTTPSRecord([]byte{0, 4, 0xfe, 0x0d, 0, 0})},
+		"plain.example.com":            {testHTTPSRecord(nil)},
+		"_8443._https.ech.example.com": {testHTTPSRecord([]byte{0, 4, 0xfe, 0x0d, 0, 0})},
+		"unlisted.example.com":         {testHTTPSRecord([]byte{0xfe, 0x0d, 0, 0})},
+		"truncated.example.com":        {testHTTPSRecord([]byte{0, 4, 0xfe, 0x0d, 0, 8})},
+		"old.example.com":              {testHTTPSRecord([]byte{0, 4, 0xfe, 0x0a, 0, 0})},
+	}}
+
+	cases := []struct {
+		host   string
+		grade  Grade
+		output string
+	}{
+		{"ech.example.com:443", Good, "ECH configuration published"},
+		{"ech.example.com:8443", Good, "ECH configuration published"},
+		{"plain.example.com:443", Notice, "no ECH configuration published"},
+		{"missing.example.com:443", Notice, "no ECH configuration published"},
+		{"unlisted.example.com:443", Warning, "ECH configuration published, but its length is wrong"},
+		{"truncated.example.com:443", Warning, "ECH configuration published, but a configuration is truncated"},
+		{"old.example.com:443", Warning, "ECH configuration published, but no configuration is of version 0xfe0d"},
+	}
+
+	withResolver(resolver, func() {
+		for _, c := range cases {
+			grade, output, err := echScan(c.host)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if grade != c.grade || output.String() != c.output {
+				t.Fatalf("%s: expected %s (%s), got %s (%s)", c.host, c.grade, c.output, grade, output)
+			}
+		}
+	})
+}
+
+func TestCertCompressionScanSkipped(t *testing.T) {
+	grade, output, err := TLSHandshake.Scanners["CertificateCompression"].Scan("127.0.0.1:443")
+	if err != nil || grade != Skipped {
+		t.Fatalf("expected unsupported certificate compression to be skipped, got %s (%s): %v", grade, output, err)
+	}
+}
+
+func TestSNIVirtualHostingScan(t *testing.T) {
+	leaf := newTestCert(t, testTemplate("localhost"), testKey.Public(), nil, testKey)
+	defaultLeaf := newTestCert(t, testTemplate("default.example.com"), testKey.Public(), nil, testKey)
+
+	cases := []struct {
+		probe  *x509.Certificate
+		grade  Grade
+		output string
+	}{
+		// A single certificate served whatever the name.
+		{leaf, Warning, "localhost: localhost\nsni-probe.invalid: localhost"},
+		{defaultLeaf, Good, "localhost: localhost\nsni-probe.invalid: default.example.com"},
+		{nil, Good, "localhost: localhost\nsni-probe.invalid: handshake refused"},
+	}
+
+	for i, c := range cases {
+		server := newChainServer(testKey, leaf)
+		probe := c.probe
+		server.TLS.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			if hello.ServerName != sniProbeName {
+				return nil, nil
+			}
+			if probe == nil {
+				return nil, errors.New("unknown server name")
+			}
+			return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{probe.Raw}, PrivateKey: testKey}}}, nil
+		}
+		server.StartTLS()
+		_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+		grade, output, err := sniVirtualHostingScan(net.JoinHostPort("localhost", port))
+		server.Close()
+		if err != nil || grade != c.grade || outputString(output) != c.output {
+			t.Fatalf("case %d: expected %s (%q), got %s (%q): %v", i, c.grade, c.output, grade, output, err)
+		}
+	}
+}
+
+func TestDefaultCertDuplicateScan(t *testing.T) {
+	wildcard := testTemplate("*.example.com")
+	wildcard.DNSNames = []string{"*.example.com", "example.com"}
+	wildcardLeaf := newTestCert(t, wildcard, testKey.Public(), nil, testKey)
+	scoped := testTemplate("*.api.example.com")
+	scoped.DNSNames = []string{"*.api.example.com"}
+	scopedLeaf := newTestCert(t, scoped, testKey.Public(), nil, testKey)
+	vhostLeaf := newTestCert(t, testTemplate("localhost"), testKey.Public(), nil, testKey)
+
+	cases := []struct {
+		defaultLeaf, vhostLeaf *x509.Certificate
+		grade                  Grade
+		output                 string
+	}{
+		{wildcardLeaf, nil, Warning, "localhost: *.example.com\nsni-probe.invalid: *.example.com\nidentical certificates, covering *.example.com"},
+		{scopedLeaf, nil, Good, "localhost: *.api.example.com\nsni-probe.invalid: *.api.example.com\nidentical certificates"},
+		{wildcardLeaf, vhostLeaf, Good, "localhost: localhost\nsni-probe.invalid: *.example.com\ndifferent certificates"},
+	}
+	for _, c := range cases {
+		server := newChainServer(testKey, c.defaultLeaf)
+		if c.vhostLeaf != nil {
+			server.TLS.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+				if hello.ServerName != "localhost" {
+					return &server.TLS.Certificates[0], nil
+				}
+				return &tls.Certificate{Certificate: [][]byte{c.vhostLeaf.Raw}, PrivateKey: testKey}, nil
+			}
+		}
+		server.StartTLS()
+		_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+		grade, output, err := defaultCertDuplicateScan(net.JoinHostPort("localhost", port))
+		server.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if grade != c.grade || output.String() != c.output {
+			t.Fatalf("expected %s (%q), got %s (%q)", c.grade, c.output, grade, output)
+		}
+	}
+}
+
+// serveUnknownSNI accepts a single connection, reads its ClientHello and
+// answers with response, if any, before hanging up.
+func serveUnknownSNI(t *testing.T, response []byte) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if _, _, err = readTestClientHello(conn); err != nil {
+			return
+		}
+		conn.Write(response)
+	}()
+	return l
+}
+
+func TestDefaultCertLeakScan(t *testing.T) {
+	cases := []struct {
+		names  []string
+		ips    []net.IP
+		grade  Grade
+		output string
+	}{
+		{[]string{"www.example.com", "api.example.co.uk"}, []net.IP{net.ParseIP("192.0.2.1")}, Good, ""},
+		{[]string{"www.example.com", "db01.corp", "build.internal", "intranet", "nas.example.lan"},
+			[]net.IP{net.ParseIP("10.0.0.5")}, Warning, "db01.corp\nbuild.internal\nintranet\nnas.example.lan\n10.0.0.5"},
+	}
+	for _, c := range cases {
+		template := testTemplate("www.example.com")
+		template.DNSNames = c.names
+		template.IPAddresses = c.ips
+		server := serveChain(testKey, newTestCert(t, template, testKey.Public(), nil, testKey))
+		grade, output, err := defaultCertLeakScan(server.Listener.Addr().String())
+		server.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if grade != c.grade || (output == nil) != (c.output == "") || output != nil && output.String() != c.output {
+			t.Fatalf("%v: expected %s (%q), got %s (%v)", c.names, c.grade, c.output, grade, output)
+		}
+	}
+}
+
+func TestUnknownSNIScan(t *testing.T) {
+	leaf := newTestCert(t, testTemplate("default.example.com"), testKey.Public(), nil, testKey)
+	server := serveChain(testKey, leaf)
+	defer server.Close()
+	alerting := serveUnknownSNI(t, []byte{recordTypeAlert, 3, 3, 0, 2, alertLevelWarning, 112})
+	defer alerting.Close()
+	closing := serveUnknownSNI(t, nil)
+	defer closing.Close()
+
+	cases := []struct {
+		host     string
+		behavior string
+		detail   string
+	}{
+		{server.Listener.Addr().String(), "default certificate", "default.example.com"},
+		{alerting.Addr().String(), "alert", "warning unrecognized name"},
+		{closing.Addr().String(), "connection closed", ""},
+	}
+	for _, c := range cases {
+		grade, output, err := unknownSNIScan(c.host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		behavior := output.(unknownSNIBehavior)
+		if grade != Good || behavior.Behavior != c.behavior || behavior.Alert+behavior.CommonName != c.detail {
+			t.Fatalf("expected %s (%s), got %s (%s)", c.behavior, c.detail, grade, behavior)
+		}
+		if !strings.HasSuffix(behavior.ServerName, ".invalid") {
+			t.Fatalf("expected a server name under .invalid, got %q", behavior.ServerName)
+		}
+	}
+}
+
+func TestHelloToleranceScan(t *testing.T) {
+	leaf := newTestCert(t, testTemplate("localhost"), testKey.Public(), nil, testKey)
+	server := serveChain(testKey, leaf)
+	defer server.Close()
+
+	grade, output, err := helloToleranceScan(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "GREASE: handshakes normally\nunknown extension: handshakes normally\npadded: handshakes normally"
+	if grade != Good || output.String() != expected {
+		t.Fatalf("expected tolerant server to be Good (%q), got %s (%v)", expected, grade, output)
+	}
+
+	// A server answering ClientHellos carrying unknown extensions with an alert.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			if _, extensions, err := readTestClientHello(conn); err == nil {
+				if _, ok := extensions[extensionUnknown]; ok {
+					conn.Write([]byte{recordTypeAlert, 3, 3, 0, 2, 2, 40})
+				} else {
+					writeTestServerHello(conn, 0xc02f, nil)
+				}
+			}
+			conn.Close()
+		}
+	}()
+
+	grade, output, err = helloToleranceScan(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = "GREASE: handshakes normally\nunknown extension: tls: received alert: handshake failure\npadded: handshakes normally"
+	if grade != Warning || output.String() != expected {
+		t.Fatalf("expected intolerant server to be Warning (%q), got %s (%v)", expected, grade, output)
+	}
+
+	refusing := serveGroups(t)
+	defer refusing.Close()
+	if grade, _, err = helloToleranceScan(refusing.Addr().String()); err != nil || grade != Skipped {
+		t.Fatalf("expected server refusing a plain ClientHello to be Skipped, got %s: %v", grade, err)
+	}
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+	if _, _, err = helloToleranceScan(closed.Addr().String()); err == nil {
+		t.Fatal("expected an unreachable host to fail")
+	}
+}
+
+func TestFalseStartScan(t *testing.T) {
+	leaf := newTestCert(t, testTemplate("localhost"), testKey.Public(), nil, testKey)
+	cases := []struct {
+		protocols  []string
+		suites     []uint16
+		minVersion uint16
+		grade      Grade
+		output     string
+	}{
+		{[]string{"h2"}, nil, 0, Good,
+			"forward secret key exchange: met\nAEAD cipher: met\nALPN or NPN protocol negotiated: met"},
+		{nil, nil, 0, Notice,
+			"forward secret key exchange: met\nAEAD cipher: met\nALPN or NPN protocol negotiated: unmet"},
+		{[]string{"h2"}, []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA}, 0, Notice,
+			"forward secret key exchange: met\nAEAD cipher: unmet\nALPN or NPN protocol negotiated: met"},
+		{[]string{"h2"}, nil, tls.VersionTLS13, Skipped, "TLS 1.2 not supported"},
+	}
+	for _, c := range cases {
+		// httptest servers always negotiate a protocol, so this one doesn't
+		// serve HTTP.
+		l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+			Certificates: []tls.Certificate{{Certificate: [][]byte{leaf.Raw}, PrivateKey: testKey}},
+			NextProtos:   c.protocols,
+			CipherSuites: c.suites,
+			MinVersion:   c.minVersion,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}
+		}()
+		grade, output, err := falseStartScan(l.Addr().String())
+		l.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if grade != c.grade || output.String() != c.output {
+			t.Fatalf("%v %x: expected %s (%q), got %s (%q)", c.protocols, c.suites, c.grade, c.output, grade, output)
+		}
+	}
+}
+
+// serveServerHello accepts a single connection, reads its ClientHello and
+// responds with a TLS 1.2 ServerHello carrying extensions, then hangs up.
+func serveServerHello(t *testing.T, extensions []byte) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		header := make([]byte, 5)
+		if _, err = io.ReadFull(conn, header); err != nil {
+			return
+		}
+		if _, err = io.ReadFull(conn, make([]byte, int(header[3])<<8|int(header[4]))); err != nil {
+			return
+		}
+
+		body := []byte{3, 3}
+		body = append(body, make([]byte, 32)...) // random
+		body = append(body, 0)                   // empty session ID
+		body = append(body, 0xc0, 0x2f, 0)       // cipher suite, null compression
+		body = append(body, byte(len(extensions)>>8), byte(len(extensions)))
+		body = append(body, extensions...)
+		msg := append([]byte{typeServerHello, 0, byte(len(body) >> 8), byte(len(body))}, body...)
+		conn.Write(append([]byte{recordTypeHandshake, 3, 3, byte(len(msg) >> 8), byte(len(msg))}, msg...))
+	}()
+	return l
+}
+
+func TestMaxFragmentLengthScan(t *testing.T) {
+	cases := []struct {
+		extensions []byte
+		grade      Grade
+		fails      bool
+	}{
+		{[]byte{0, 1, 0, 1, maxFragmentLength512}, Good, false},
+		{nil, Skipped, false},
+		{[]byte{0, 1, 0, 1, 4}, Bad, true},
+	}
+
+	for _, c := range cases {
+		l := serveServerHello(t, c.extensions)
+		grade, output, err := maxFragmentLengthScan(l.Addr().String())
+		l.Close()
+		if grade != c.grade || (err != nil) != c.fails {
+			t.Fatalf("extensions %x: expected %s, got %s (%v): %v", c.extensions, c.grade, grade, output, err)
+		}
+	}
+
+	// Go's TLS server doesn't implement max_fragment_length.
+	server := serveTLSVersions(tls.VersionTLS12)
+	defer server.Close()
+	if grade, _, err := maxFragmentLengthScan(server.Listener.Addr().String()); err != nil || grade != Skipped {
+		t.Fatalf("expected server ignoring the extension to be skipped, got %s: %v", grade, err)
+	}
+}
+
+func TestHeartbeatScan(t *testing.T) {
+	l := serveServerHello(t, []byte{0, 15, 0, 1, heartbeatPeerAllowedToSend})
+	defer l.Close()
+	grade, output, err := heartbeatScan(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grade != Warning || output.String() != "heartbeat extension negotiated" {
+		t.Fatalf("expected Warning for server acknowledging heartbeat, got %s (%v)", grade, output)
+	}
+
+	// Go's TLS server doesn't implement heartbeat.
+	server := serveTLSVersions(tls.VersionTLS12)
+	defer server.Close()
+	grade, output, err = heartbeatScan(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grade != Good || output.String() != "heartbeat extension not negotiated" {
+		t.Fatalf("expected Good for server ignoring heartbeat, got %s (%v)", grade, output)
+	}
+}
+
+// serveTLS13Suites starts a server answering ClientHellos with a TLS 1.3
+// ServerHello selecting the first offered cipher suite among suites, or with
+// a handshake_failure alert if none is offered.
+func serveTLS13Suites(t *testing.T, suites ...uint16) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			answerTLS13Suites(conn, suites)
+		}
+	}()
+	return l
+}
+
+// readTestClientHello reads a ClientHello sent in a single record from conn,
+// returning the cipher suites and extensions it offers.
+func readTestClientHello(conn net.Conn) (suites []uint16, extensions map[uint16][]byte, err error) {
+	header := make([]byte, 5)
+	if _, err = io.ReadFull(conn, header); err != nil {
+		return
+	}
+	hello := make([]byte, int(header[3])<<8|int(header[4]))
+	if _, err = io.ReadFull(conn, hello); err != nil {
+		return
+	}
+
+	// Skip the handshake header, version and random to reach the session ID.
+	r := hello[4+2+32:]
+	r = r[1+int(r[0]):]
+	n := int(r[0])<<8 | int(r[1])
+	for i := 2; i < 2+n; i += 2 {
+		suites = append(suites, uint16(r[i])<<8|uint16(r[i+1]))
+	}
+	r = r[2+n:]
+	r = r[1+int(r[0]):] // compression methods
+	r = r[2:]           // extensions length
+	extensions = make(map[uint16][]byte)
+	for len(r) >= 4 {
+		length := int(r[2])<<8 | int(r[3])
+		extensions[uint16(r[0])<<8|uint16(r[1])] = r[4 : 4+length]
+		r = r[4+length:]
+	}
+	return
+}
+
+// writeTestServerHello answers with a TLS 1.2 ServerHello selecting suite
+// and carrying extensions.
+func writeTestServerHello(conn net.Conn, suite uint16, extensions []byte) {
+	body := []byte{3, 3}
+	body = append(body, make([]byte, 32)...)            // random
+	body = append(body, 0)                              // empty session ID
+	body = append(body, byte(suite>>8), byte(suite), 0) // cipher suite, null compression
+	body = append(body, 0, byte(len(extensions)))
+	body = append(body, extensions...)
+	msg := append([]byte{typeServerHello, 0, 0, byte(len(body))}, body...)
+	conn.Write(append([]byte{recordTypeHandshake, 3, 3, 0, byte(len(msg))}, msg...))
+}
+
+// writeTestServerKeyExchange writes an ECDHE ServerKeyExchange for group,
+// signed with scheme, followed by ServerHelloDone.
+func writeTestServerKeyExchange(conn net.Conn, group, scheme uint16) {
+	body := []byte{3, byte(group >> 8), byte(group), 65}     // named_curve
+	body = append(body, make([]byte, 65)...)                 // public point
+	body = append(body, byte(scheme>>8), byte(scheme), 0, 0) // empty signature
+	msg := append([]byte{typeServerKeyExchange, 0, 0, byte(len(body))}, body...)
+	msg = append(msg, typeServerHelloDone, 0, 0, 0)
+	conn.Write(append([]byte{recordTypeHandshake, 3, 3, 0, byte(len(msg))}, msg...))
+}
+
+// writeTestHandshakeFailure answers with a handshake_failure alert.
+func writeTestHandshakeFailure(conn net.Conn) {
+	conn.Write([]byte{recordTypeAlert, 3, 3, 0, 2, 2, 40})
+}
+
+func answerTLS13Suites(conn net.Conn, suites []uint16) {
+	defer conn.Close()
+	offered, _, err := readTestClientHello(conn)
+	if err != nil {
+		return
+	}
+	for _, suite := range offered {
+		for _, s := range suites {
+			if s == suite {
+				writeTestServerHello(conn, suite, []byte{0, 43, 0, 2, 3, 4}) // supported_versions: TLS 1.3
+				return
+			}
+		}
+	}
+	writeTestHandshakeFailure(conn)
+}
+
+func TestTLS13CipherSuitesScan(t *testing.T) {
+	cases := []struct {
+		suites []uint16
+		grade  Grade
+		output string
+	}{
+		{[]uint16{0x1301}, Warning, "TLS_AES_128_GCM_SHA256"},
+		{[]uint16{0x1302, 0x1303}, Good, "TLS_AES_256_GCM_SHA384\nTLS_CHACHA20_POLY1305_SHA256"},
+		{nil, Skipped, ""},
+	}
+
+	for _, c := range cases {
+		l := serveTLS13Suites(t, c.suites...)
+		grade, output, err := tls13CipherSuitesScan(l.Addr().String())
+		l.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if grade != c.grade || (output == nil) != (c.output == "") || output != nil && output.String() != c.output {
+			t.Fatalf("suites %x: expected %s (%q), got %s (%v)", c.suites, c.grade, c.output, grade, output)
+		}
+	}
+
+	server := serveTLSVersions(tls.VersionTLS13)
+	defer server.Close()
+	if grade, output, err := tls13CipherSuitesScan(server.Listener.Addr().String()); err != nil || grade != Good {
+		t.Fatalf("expected Go's TLS 1.3 server to support several suites, got %s (%v): %v", grade, output, err)
+	}
+
+	server12 := serveTLSVersions(tls.VersionTLS12)
+	defer server12.Close()
+	if grade, _, err := tls13CipherSuitesScan(server12.Listener.Addr().String()); err != nil || grade != Skipped {
+		t.Fatalf("expected TLS 1.2 server to be skipped, got %s: %v", grade, err)
+	}
+}
+
+// serveGroups starts a server answering ClientHellos offering one of groups
+// with a ServerHello selecting the first ECDHE cipher suite offered and a
+// ServerKeyExchange for that group, or with a handshake_failure alert
+// otherwise.
+func serveGroups(t *testing.T, groups ...uint16) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			answerGroups(conn, groups)
+		}
+	}()
+	return l
+}
+
+func answerGroups(conn net.Conn, groups []uint16) {
+	defer conn.Close()
+	suites, extensions, err := readTestClientHello(conn)
+	if err != nil {
+		return
+	}
+	offered := extensions[extensionSupportedGroups]
+	for i := 2; i+1 < len(offered); i += 2 {
+		for _, group := range groups {
+			if uint16(offered[i])<<8|uint16(offered[i+1]) == group {
+				writeTestServerHello(conn, suites[0], nil)
+				writeTestServerKeyExchange(conn, group, 0x0403)
+				return
+			}
+		}
+	}
+	writeTestHandshakeFailure(conn)
+}
+
+func TestSupportedGroupsScan(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256}}
+	server.StartTLS()
+	defer server.Close()
+
+	grade, output, err := supportedGroupsScan(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grade != Good || output.String() != "x25519\nsecp256r1" {
+		t.Fatalf("expected server restricted to strong groups to be Good, got %s (%v)", grade, output)
+	}
+
+	tls13 := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	tls13.TLS = &tls.Config{MinVersion: tls.VersionTLS13, CurvePreferences: []tls.CurveID{tls.CurveP256}}
+	tls13.StartTLS()
+	defer tls13.Close()
+	grade, output, err = supportedGroupsScan(tls13.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grade != Good || output.String() != "secp256r1" {
+		t.Fatalf("expected TLS 1.3 only server to accept secp256r1, got %s (%v)", grade, output)
+	}
+
+	l := serveGroups(t, groupP256, groupSecp192r1)
+	defer l.Close()
+	grade, output, err = supportedGroupsScan(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grade != Warning || output.String() != "secp256r1\nsecp192r1" {
+		t.Fatalf("expected server accepting secp192r1 to be Warning, got %s (%v)", grade, output)
+	}
+
+	none := serveGroups(t)
+	defer none.Close()
+	if grade, _, err = supportedGroupsScan(none.Addr().String()); err != nil || grade != Skipped {
+		t.Fatalf("expected server without ECDHE to be Skipped, got %s: %v", grade, err)
+	}
+}
+
+func TestAcceptsGroupTLS12IgnoredGroups(t *testing.T) {
+	// The server uses P-256 whatever groups are offered.
+	l := serveServerKeyExchange(t, 0x0403)
+	defer l.Close()
+	for group, expected := range map[uint16]bool{groupP256: true, groupSecp192r1: false} {
+		if ok, err := acceptsGroupTLS12(l.Addr().String(), group); err != nil || ok != expected {
+			t.Fatalf("%s: expected accepted %v, got %v: %v", groupNames[group], expected, ok, err)
+		}
+	}
+}
+
+func TestPostQuantumScan(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{CurvePreferences: []tls.CurveID{tls.X25519MLKEM768, tls.X25519}}
+	server.StartTLS()
+	defer server.Close()
+	grade, output, err := postQuantumScan(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grade != Good || output.String() != "post-quantum key exchange negotiated with X25519MLKEM768" {
+		t.Fatalf("expected post-quantum key exchange, got %s (%s)", grade, output)
+	}
+
+	classical := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	classical.TLS = &tls.Config{CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256}}
+	classical.StartTLS()
+	defer classical.Close()
+	if grade, output, err = postQuantumScan(classical.Listener.Addr().String()); err != nil || grade != Skipped {
+		t.Fatalf("expected server without post-quantum groups to be Skipped, got %s (%v): %v", grade, output, err)
+	}
+}
+
+func TestWeakCipherScan(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	leaf := newTestCert(t, testTemplate("localhost"), rsaKey.Public(), nil, rsaKey)
+	cases := []struct {
+		suites []uint16
+		grade  Grade
+	}{
+		{[]uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA}, Bad},
+		{[]uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}, Good},
+	}
+
+	for _, c := range cases {
+		server := newChainServer(rsaKey, leaf)
+		server.TLS.CipherSuites = c.suites
+		server.TLS.MaxVersion = tls.VersionTLS12
+		server.StartTLS()
+		grade, output, err := weakCipherScan(server.Listener.Addr().String())
+		server.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if grade != c.grade {
+			t.Fatalf("suites %x: expected %s, got %s (%s)", c.suites, c.grade, grade, output)
+		}
+	}
+}
+
+func TestHandshakeSignatureScan(t *testing.T) {
+	// The P-256 leaf restricts the server to ecdsa_secp256r1_sha256.
+	leaf := newTestCert(t, testTemplate("localhost"), testKey.Public(), nil, testKey)
+	server := serveChain(testKey, leaf)
+	defer server.Close()
+
+	grade, output, err := handshakeSignatureScan(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grade != Good || output.String() != "ecdsa_secp256r1_sha256" {
+		t.Fatalf("expected Good (ecdsa_secp256r1_sha256), got %s (%v)", grade, output)
+	}
+}
+
+// serveServerKeyExchange answers ClientHellos with a ServerHello selecting an
+// ECDHE suite and a ServerKeyExchange for P-256 signed with scheme, whatever
+// groups were offered.
+func serveServerKeyExchange(t *testing.T, scheme uint16) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			if _, _, err = readTestClientHello(conn); err == nil {
+				writeTestServerHello(conn, 0xc02f, nil)
+				writeTestServerKeyExchange(conn, groupP256, scheme)
+			}
+			conn.Close()
+		}
+	}()
+	return l
+}
+
+func TestHandshakeSignatureScanTLS10(t *testing.T) {
+	leaf := newTestCert(t, testTemplate("localhost"), testKey.Public(), nil, testKey)
+	server := newChainServer(testKey, leaf)
+	server.TLS.MinVersion = tls.VersionTLS10
+	server.TLS.MaxVersion = tls.VersionTLS10
+	server.StartTLS()
+	defer server.Close()
+
+	grade, output, err := handshakeSignatureScan(server.Listener.Addr().String())
+	if err != nil || grade != Skipped || outputString(output) != "TLS 1.2 not supported" {
+		t.Fatalf("expected TLS 1.0 server to be Skipped (TLS 1.2 not supported), got %s (%v): %v", grade, output, err)
+	}
+}
+
+func TestHandshakeSignatureScanSHA1(t *testing.T) {
+	l := serveServerKeyExchange(t, 0x0201)
+	defer l.Close()
+
+	grade, output, err := handshakeSignatureScan(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grade != Warning || output.String() != "rsa_pkcs1_sha1" {
+		t.Fatalf("expected Warning (rsa_pkcs1_sha1), got %s (%v)", grade, output)
+	}
+}
+
+// serveRenegotiation starts a TLS 1.2 server that answers an HTTP request by
+// starting to renegotiate. crypto/tls can't renegotiate as a server, so a
+// handshake record is written beneath the connection, which is all the
+// scanner looks for.
+func serveRenegotiation(t *testing.T) net.Listener {
+	leaf := newTestCert(t, testTemplate("localhost"), testKey.Public(), nil, testKey)
+	config := &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{leaf.Raw}, PrivateKey: testKey}},
+		MaxVersion:   tls.VersionTLS12,
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		raw, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer raw.Close()
+		conn := tls.Server(raw, config)
+		if _, err = http.ReadRequest(bufio.NewReader(conn)); err != nil {
+			return
+		}
+		raw.Write(append([]byte{recordTypeHandshake, 3, 3, 0, 32}, make([]byte, 32)...))
+		io.Copy(ioutil.Discard, raw)
+	}()
+	return l
+}
+
+func TestRenegotiationScan(t *testing.T) {
+	l := serveRenegotiation(t)
+	defer l.Close()
+	grade, output, err := renegotiationScan(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grade != Warning {
+		t.Fatalf("expected renegotiating server to be Warning, got %s (%v)", grade, output)
+	}
+
+	leaf := newTestCert(t, testTemplate("localhost"), testKey.Public(), nil, testKey)
+	server := serveChain(testKey, leaf)
+	defer server.Close()
+	grade, output, err = renegotiationScan(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if grade != Good {
+		t.Fatalf("expected server answering directly to be Good, got %s (%v)", grade, output)
+	}
+}
@@ -1,8 +1,11 @@
 package scan
 
 import (
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"net"
+	"time"
 
 	"github.com/cloudflare/cf-tls/tls"
 )
@@ -13,8 +16,12 @@ var TLSSession = &Family{
 	Description: "Scans host's implementation of TLS session resumption using session tickets/session IDs",
 	Scanners: map[string]*Scanner{
 		"SessionResume": {
-			"Host is able to resume sessions across all addresses",
-			sessionResumeScan,
+			Description: "Host is able to resume sessions across all addresses",
+			scan:        sessionResumeScan,
+		},
+		"TicketLifetime": {
+			Description: "Host's session tickets have a bounded lifetime",
+			scan:        ticketLifetimeScan,
 		},
 	},
 }
@@ -33,7 +40,7 @@ func sessionResumeScan(host string) (grade Grade, output Output, err error) {
 	config := defaultTLSConfig(host)
 	config.ClientSessionCache = tls.NewLRUClientSessionCache(1)
 	var conn *tls.Conn
-	conn, err = tls.DialWithDialer(Dialer, Network, host, config)
+	conn, err = tlsDial(host, config)
 	if err != nil {
 		return
 	}
@@ -41,7 +48,7 @@ func sessionResumeScan(host string) (grade Grade, output Output, err error) {
 
 	for _, ip := range ips {
 		host = net.JoinHostPort(ip.String(), port)
-		conn, err = tls.Dial(Network, host, config)
+		conn, err = tlsDial(host, config)
 		if err != nil {
 			return
 		}
@@ -54,3 +61,68 @@ func sessionResumeScan(host string) (grade Grade, output Output, err error) {
 	grade = Good
 	return
 }
+
+// MaxTicketLifetime is the session ticket lifetime hint beyond which a host is
+// flagged. Resuming a session skips the key exchange, so a ticket encrypted
+// under a long-lived key weakens forward secrecy for as long as it's valid.
+var MaxTicketLifetime = 7 * 24 * time.Hour
+
+// ticketLifetime is the lifetime hint of a session ticket.
+type ticketLifetime time.Duration
+
+func (l ticketLifetime) String() string {
+	if l == 0 {
+		return "no lifetime hint"
+	}
+	return fmt.Sprintf("lifetime hint of %s", time.Duration(l))
+}
+
+func (l ticketLifetime) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf("%d", int64(time.Duration(l)/time.Second))), nil
+}
+
+// ticketLifetimeScan tests that the lifetime hint of the session ticket the
+// host issues doesn't exceed MaxTicketLifetime. The hint is read from the
+// NewSessionTicket message of a TLS 1.2 handshake, which unlike its TLS 1.3
+// counterpart is sent in plaintext, since cf-tls doesn't expose it. Hosts that
+// issue no ticket are Skipped, while a hint of zero leaves the lifetime to the
+// client and is Good.
+func ticketLifetimeScan(host string) (grade Grade, output Output, err error) {
+	rawConn, err := dial(Network, host)
+	if err != nil {
+		return
+	}
+	capture := &captureConn{Conn: rawConn}
+	config := defaultTLSConfig(host)
+	config.MaxVersion = tls.VersionTLS12
+	config.ClientSessionCache = tls.NewLRUClientSessionCache(1)
+	conn := tls.Client(capture, config)
+	handshakeErr := clientHandshake(conn, rawConn)
+	conn.Close()
+
+	capture.mu.Lock()
+	ticket, findErr := handshakeMessage(capture.read.Bytes(), typeNewSessionTicket)
+	capture.mu.Unlock()
+	// The ticket precedes the server's Finished message, so it's valid even
+	// if the handshake fails after it was sent.
+	if findErr != nil {
+		if handshakeErr != nil {
+			err = handshakeErr
+			return
+		}
+		return Skipped, nil, nil
+	}
+	if len(ticket) < 8 {
+		err = errors.New("malformed NewSessionTicket")
+		return
+	}
+
+	lifetime := time.Duration(binary.BigEndian.Uint32(ticket[4:])) * time.Second
+	output = ticketLifetime(lifetime)
+	if lifetime > MaxTicketLifetime {
+		grade = Warning
+		return
+	}
+	grade = Good
+	return
+}
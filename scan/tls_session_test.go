@@ -0,0 +1,107 @@
+package scan
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// ticketLifetimeConn overwrites the lifetime hint of the NewSessionTicket
+// messages written through it.
+type ticketLifetimeConn struct {
+	net.Conn
+	lifetime uint32
+}
+
+func (c *ticketLifetimeConn) Write(b []byte) (int, error) {
+	for records := b; len(records) >= 5; {
+		length := int(binary.BigEndian.Uint16(records[3:]))
+		if len(records) < 5+length {
+			break
+		}
+		body := records[5 : 5+length]
+		if records[0] == recordTypeHandshake && len(body) >= 8 && body[0] == typeNewSessionTicket {
+			binary.BigEndian.PutUint32(body[4:], c.lifetime)
+		}
+		records = records[5+length:]
+	}
+	return c.Conn.Write(b)
+}
+
+// serveTickets returns a TLS 1.2 listener issuing session tickets with the
+// given lifetime hint, or none if disabled.
+func serveTickets(t *testing.T, lifetime uint32, disabled bool) net.Listener {
+	leaf := newTestCert(t, testTemplate("localhost"), testKey.Public(), nil, testKey)
+	config := &tls.Config{
+		Certificates:           []tls.Certificate{{Certificate: [][]byte{leaf.Raw}, PrivateKey: testKey}},
+		MaxVersion:             tls.VersionTLS12,
+		SessionTicketsDisabled: disabled,
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			rawConn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer rawConn.Close()
+				conn := tls.Server(&ticketLifetimeConn{Conn: rawConn, lifetime: lifetime}, config)
+				http.ReadRequest(bufio.NewReader(conn))
+			}()
+		}
+	}()
+	return l
+}
+
+func TestTicketLifetimeScan(t *testing.T) {
+	cases := []struct {
+		lifetime time.Duration
+		disabled bool
+		grade    Grade
+		output   string
+	}{
+		{0, false, Good, "no lifetime hint"},
+		{24 * time.Hour, false, Good, "lifetime hint of 24h0m0s"},
+		{30 * 24 * time.Hour, false, Warning, "lifetime hint of 720h0m0s"},
+		{0, true, Skipped, ""},
+	}
+
+	for _, c := range cases {
+		l := serveTickets(t, uint32(c.lifetime/time.Second), c.disabled)
+		grade, output, err := ticketLifetimeScan(l.Addr().String())
+		l.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if grade != c.grade || (output == nil) != (c.output == "") || output != nil && output.String() != c.output {
+			t.Fatalf("%s ticket lifetime: expected %s (%q), got %s (%v)", c.lifetime, c.grade, c.output, grade, output)
+		}
+	}
+}
+
+func TestTicketLifetimeScanDeadline(t *testing.T) {
+	// The listener accepts connections into its backlog but never answers.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	defer func(timeout time.Duration) { Dialer.Timeout = timeout }(Dialer.Timeout)
+	Dialer.Timeout = 100 * time.Millisecond
+	start := time.Now()
+	if _, _, err := ticketLifetimeScan(l.Addr().String()); err == nil {
+		t.Fatal("expected handshake with a silent host to fail")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("handshake with a silent host took %s", elapsed)
+	}
+}
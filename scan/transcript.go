@@ -0,0 +1,135 @@
+package scan
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"regexp"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cloudflare/cf-tls/tls"
+)
+
+// Transcript holds the raw messages of the default TLS handshake shared by
+// scanners, for analysis by other tools.
+type Transcript struct {
+	// ClientHello and ServerHello are the complete handshake messages,
+	// including their four byte headers.
+	ClientHello []byte `json:"client_hello"`
+	ServerHello []byte `json:"server_hello"`
+	// Certificates are the DER encoded certificates presented by the host,
+	// leaf first.
+	Certificates [][]byte `json:"certificates"`
+}
+
+// captureConn records the bytes written to and read from a connection.
+type captureConn struct {
+	net.Conn
+	mu            sync.Mutex
+	written, read bytes.Buffer
+}
+
+func (c *captureConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.mu.Lock()
+	c.read.Write(b[:n])
+	c.mu.Unlock()
+	return n, err
+}
+
+func (c *captureConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.mu.Lock()
+	c.written.Write(b[:n])
+	c.mu.Unlock()
+	return n, err
+}
+
+// handshakeMessage returns the first handshake message of type msgType in the
+// plaintext handshake records at the start of stream.
+func handshakeMessage(stream []byte, msgType uint8) ([]byte, error) {
+	var handshake []byte
+	for len(stream) >= 5 && stream[0] == recordTypeHandshake {
+		length := int(binary.BigEndian.Uint16(stream[3:]))
+		if len(stream) < 5+length {
+			break
+		}
+		handshake = append(handshake, stream[5:5+length]...)
+		stream = stream[5+length:]
+
+		for len(handshake) >= 4 {
+			length := int(handshake[1])<<16 | int(handshake[2])<<8 | int(handshake[3])
+			if len(handshake) < 4+length {
+				break
+			}
+			if handshake[0] == msgType {
+				return handshake[:4+length], nil
+			}
+			handshake = handshake[4+length:]
+		}
+	}
+	return nil, errors.New("handshake message not found in transcript")
+}
+
+// RunScansCapture runs scans as RunScans does, additionally returning the
+// transcript of the handshake shared by the scanners needing one. Grading is
+// unaffected by the capture. The transcript is nil if no such scanner ran.
+func (fs FamilySet) RunScansCapture(host, family, scanner string) (map[string]FamilyResult, *Transcript, error) {
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, "443")
+	}
+
+	familyRegexp, err := regexp.Compile(family)
+	if err != nil {
+		return nil, nil, err
+	}
+	scannerRegexp, err := regexp.Compile(scanner)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var conn *captureConn
+	var state *tls.ConnectionState
+	var once sync.Once
+	handshake := func() (*tls.ConnectionState, error) {
+		performed := false
+		once.Do(func() {
+			performed = true
+			state, err = wrappedConnectionState(host, func(c net.Conn) net.Conn {
+				conn = &captureConn{Conn: c}
+				return conn
+			})
+			if err != nil {
+				err = &HandshakeError{Host: host, Err: err}
+			}
+		})
+		if !performed {
+			atomic.AddUint64(&stats.CacheHits, 1)
+		}
+		return state, err
+	}
+	results := fs.runScans(host, familyRegexp, scannerRegexp, defaultScanOptions(), func(s *Scanner) (Grade, Output, error) {
+		return s.run(host, handshake)
+	})
+
+	// Wait for any handshake still in progress, without starting one.
+	once.Do(func() {})
+	if conn == nil || err != nil {
+		return results, nil, nil
+	}
+	transcript := new(Transcript)
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if transcript.ClientHello, err = handshakeMessage(conn.written.Bytes(), typeClientHello); err != nil {
+		return nil, nil, err
+	}
+	if transcript.ServerHello, err = handshakeMessage(conn.read.Bytes(), typeServerHello); err != nil {
+		return nil, nil, err
+	}
+	for _, cert := range state.PeerCertificates {
+		transcript.Certificates = append(transcript.Certificates, cert.Raw)
+	}
+	return results, transcript, nil
+}
@@ -0,0 +1,64 @@
+package scan
+
+import (
+	"crypto/x509"
+	"testing"
+)
+
+func TestRunScansCapture(t *testing.T) {
+	rootKey := testKey
+	root := newTestCert(t, testCATemplate("Test Root"), rootKey.Public(), nil, rootKey)
+	leaf := newTestCert(t, testTemplate("localhost"), testKey.Public(), root, rootKey)
+	server := serveChain(testKey, leaf, root)
+	defer server.Close()
+	host := server.Listener.Addr().String()
+
+	results, transcript, err := Default.RunScansCapture(host, "PKI", "CompromisedKey|KeyIdentifiers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transcript == nil {
+		t.Fatal("expected a transcript")
+	}
+
+	if len(transcript.Certificates) != 2 {
+		t.Fatalf("expected 2 certificates, got %d", len(transcript.Certificates))
+	}
+	for i, want := range []*x509.Certificate{leaf, root} {
+		cert, err := x509.ParseCertificate(transcript.Certificates[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !cert.Equal(want) {
+			t.Fatalf("certificate %d doesn't round trip", i)
+		}
+	}
+	if transcript.ClientHello[0] != typeClientHello || transcript.ServerHello[0] != typeServerHello {
+		t.Fatalf("unexpected handshake message types %d and %d", transcript.ClientHello[0], transcript.ServerHello[0])
+	}
+	if _, err = parseServerHello(transcript.ServerHello[4:]); err != nil {
+		t.Fatal(err)
+	}
+
+	// Capturing the transcript doesn't change the results.
+	uncaptured, err := Default.RunScans(host, "PKI", "CompromisedKey|KeyIdentifiers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, result := range uncaptured["PKI"] {
+		captured := results["PKI"][name]
+		if captured.Grade != result.Grade || captured.Output.String() != result.Output.String() {
+			t.Fatalf("%s: captured result %s (%s) differs from %s (%s)", name, captured.Grade, captured.Output, result.Grade, result.Output)
+		}
+	}
+}
+
+func TestRunScansCaptureWithoutHandshake(t *testing.T) {
+	_, transcript, err := Default.RunScansCapture("127.0.0.1:1", "PKI", "IntermediateCAs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transcript != nil {
+		t.Fatal("expected no transcript when no scanner shares the handshake")
+	}
+}